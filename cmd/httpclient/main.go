@@ -0,0 +1,291 @@
+package main
+
+import (
+	"MODULE_NAME/internal/capture"
+	"MODULE_NAME/internal/client"
+	"MODULE_NAME/internal/headers"
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// headerFlags collects every -H.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		return fmt.Errorf("header %q is not \"Name: value\"", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+func main() {
+	method := flag.String("X", "", "request method, GET by default or POST with -d")
+	var headerLines headerFlags
+	flag.Var(&headerLines, "H", "request header \"Name: value\", can be repeated")
+	data := flag.String("d", "", "request body, @file reads it from a file and @- from stdin")
+	verbose := flag.Bool("v", false, "show the raw request and response, chunks, trailers and timing on stderr")
+	include := flag.Bool("i", false, "print the response status line and headers before the body")
+	follow := flag.Bool("L", false, "follow redirects")
+	insecure := flag.Bool("k", false, "don't verify the server certificate")
+	timeout := flag.Duration("m", 0, "timeout for each exchange, e.g. 10s")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: httpclient [flags] [METHOD] URL")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 1 || len(args) == 2 {
+		if len(args) == 2 {
+			*method = args[0]
+		}
+	} else {
+		flag.Usage()
+		os.Exit(2)
+	}
+	rawURL := args[len(args)-1]
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	body, err := readData(*data)
+	if err != nil {
+		fail(err)
+	}
+	if *method == "" {
+		*method = "GET"
+		if *data != "" {
+			*method = "POST"
+		}
+	}
+	req, err := client.NewRequest(strings.ToUpper(*method), rawURL, body)
+	if err != nil {
+		fail(err)
+	}
+	for _, line := range headerLines {
+		name, value, _ := strings.Cut(line, ":")
+		req.Headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	c := &client.Client{Timeout: *timeout, MaxRedirects: -1, Jar: client.NewJar()}
+	if *follow {
+		c.MaxRedirects = 0
+	}
+	if *insecure {
+		c.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	var t *timing
+	if *verbose {
+		t = &timing{start: time.Now()}
+		req.Trace = newTrace(os.Stderr, t)
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		fail(err)
+	}
+	defer resp.Body.Close()
+
+	if *include {
+		fmt.Printf("%s %d %s\r\n", resp.Proto, resp.StatusCode, resp.Reason)
+		resp.Headers.Write(os.Stdout)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	if err != nil {
+		fail(err)
+	}
+	if *verbose {
+		printTrailers(os.Stderr, resp)
+		t.done = time.Now()
+		t.print(os.Stderr)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "httpclient:", err)
+	os.Exit(1)
+}
+
+// readData turns the -d value into the body, curl style.
+func readData(data string) ([]byte, error) {
+	switch {
+	case data == "@-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		return os.ReadFile(data[1:])
+	case data == "":
+		return nil, nil
+	}
+	return []byte(data), nil
+}
+
+// timing keeps the moments of the last hop.
+type timing struct {
+	start                   time.Time
+	dnsStart, dnsDone       time.Time
+	connectStart, connected time.Time
+	tlsStart, tlsDone       time.Time
+	wrote, firstByte, done  time.Time
+}
+
+func (t *timing) print(w io.Writer) {
+	phase := func(name string, from time.Time, to time.Time) string {
+		if from.IsZero() || to.IsZero() {
+			return ""
+		}
+		return fmt.Sprintf(" %s %s,", name, to.Sub(from).Round(time.Microsecond))
+	}
+	fmt.Fprintf(w, "* timing:%s%s%s%s%s ttfb %s, total %s\n",
+		phase("dns", t.dnsStart, t.dnsDone),
+		phase("connect", t.connectStart, t.connected),
+		phase("tls", t.tlsStart, t.tlsDone),
+		phase("server", t.wrote, t.firstByte),
+		phase("transfer", t.firstByte, t.done),
+		t.firstByte.Sub(t.start).Round(time.Microsecond),
+		t.done.Sub(t.start).Round(time.Microsecond))
+}
+
+// newTrace prints the exchange to w: "*" lines are information, ">" the raw
+// request and "<" the raw response head. Every hop of a redirect is shown.
+func newTrace(w io.Writer, t *timing) *client.Trace {
+	head := &headPrinter{w: w}
+	return &client.Trace{
+		DNSStart: func(host string) {
+			t.dnsStart = time.Now()
+			fmt.Fprintf(w, "* resolving %s\n", host)
+		},
+		DNSDone: func(addrs []string, err error) {
+			t.dnsDone = time.Now()
+			if err == nil {
+				fmt.Fprintf(w, "* resolved to %s\n", strings.Join(addrs, ", "))
+			}
+		},
+		ConnectStart: func(address string) {
+			t.connectStart = time.Now()
+			fmt.Fprintf(w, "* connecting to %s\n", address)
+		},
+		ConnectDone: func(address string, err error) {
+			t.connected = time.Now()
+			if err != nil {
+				fmt.Fprintf(w, "* %v\n", err)
+				return
+			}
+			fmt.Fprintf(w, "* connected to %s\n", address)
+		},
+		TLSHandshakeStart: func() {
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			t.tlsDone = time.Now()
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "* %s, %s, ALPN %q\n", tls.VersionName(state.Version),
+				tls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol)
+			if len(state.PeerCertificates) > 0 {
+				fmt.Fprintf(w, "* certificate for %s\n", state.PeerCertificates[0].Subject)
+			}
+		},
+		GotConn: func(reused bool) {
+			if reused {
+				// a redirect on the same connection, nothing was dialed
+				t.dnsStart, t.connectStart, t.tlsStart = time.Time{}, time.Time{}, time.Time{}
+				fmt.Fprintln(w, "* reusing connection")
+			}
+			head.reset()
+		},
+		WroteBytes: func(p []byte) {
+			printRaw(w, "> ", p)
+		},
+		WroteRequest: func(err error) {
+			t.wrote = time.Now()
+			t.firstByte = time.Time{}
+		},
+		GotFirstResponseByte: func() {
+			t.firstByte = time.Now()
+		},
+		ReadBytes: head.write,
+		Chunk: func(size int64, extensions string) {
+			if extensions != "" {
+				extensions = " ;" + extensions
+			}
+			fmt.Fprintf(w, "* chunk 0x%x (%d bytes)%s\n", size, size, extensions)
+		},
+	}
+}
+
+// headPrinter prints the raw response heads, interim ones included, and
+// stops at the body.
+type headPrinter struct {
+	w    io.Writer
+	buf  []byte
+	done bool
+}
+
+func (h *headPrinter) reset() {
+	h.buf = nil
+	h.done = false
+}
+
+func (h *headPrinter) write(p []byte) {
+	if h.done {
+		return
+	}
+	h.buf = append(h.buf, p...)
+	for {
+		end := bytes.Index(h.buf, []byte("\r\n\r\n"))
+		if end == -1 {
+			return
+		}
+		head := h.buf[:end+4]
+		printRaw(h.w, "< ", head)
+		h.buf = h.buf[end+4:]
+		// 1xx heads are followed by another one, 101 by another protocol
+		_, status, _ := bytes.Cut(head, []byte(" "))
+		if !bytes.HasPrefix(status, []byte("1")) || bytes.HasPrefix(status, []byte("101")) {
+			h.done = true
+			return
+		}
+	}
+}
+
+// printRaw writes p line by line behind prefix, with CR and LF and other
+// control bytes made visible.
+func printRaw(w io.Writer, prefix string, p []byte) {
+	for len(p) > 0 {
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i != -1 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+		fmt.Fprintf(w, "%s%s\n", prefix, capture.Escape(line))
+	}
+}
+
+func printTrailers(w io.Writer, resp *client.Response) {
+	if len(resp.Trailers) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(resp.Trailers))
+	for key := range resp.Trailers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(w, "* trailers")
+	for _, key := range keys {
+		value, _ := resp.Trailers.Get(key)
+		fmt.Fprintf(w, "< %s: %s\n", headers.CanonicalKey(key), value)
+	}
+}
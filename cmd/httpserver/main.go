@@ -1,25 +1,61 @@
 package main
 
 import (
-	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/h2c"
+	"MODULE_NAME/internal/proxy"
 	"MODULE_NAME/internal/request"
 	"MODULE_NAME/internal/response"
 	"MODULE_NAME/internal/server"
-	"crypto/sha256"
+	"MODULE_NAME/internal/websocket"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
 
+var httpbinProxy *proxy.Proxy
+
+// forwardProxy handles CONNECT and absolute-form requests when -proxy is
+// set, nil otherwise.
+var forwardProxy *proxy.Forward
+
+// assets serves the files under ./assets, with listings for browsing them.
+var assets = server.FileServerWithOptions("assets", server.FileServerOptions{
+	StripPrefix: "/assets",
+	Listings:    true,
+})
+
 func main() {
-	server, err := server.Serve(port, handler)
+	forward := flag.Bool("proxy", false, "also act as a forward proxy: CONNECT tunnels and absolute-form requests")
+	allow := flag.String("proxy-allow", "", "comma separated destinations the proxy may reach, e.g. \"*.example.com,:443\"; empty allows all")
+	deny := flag.String("proxy-deny", "", "comma separated destinations the proxy refuses, e.g. \"10.0.0.0/8,localhost\"")
+	auth := flag.String("proxy-auth", "", "user:password the proxy requires in Proxy-Authorization")
+	flag.Parse()
+
+	var err error
+	httpbinProxy, err = proxy.New("https://httpbin.org")
+	if err != nil {
+		log.Fatalf("Error creating proxy: %v", err)
+	}
+	httpbinProxy.StripPrefix = "/httpbin"
+
+	if *forward {
+		forwardProxy, err = newForwardProxy(*allow, *deny, *auth)
+		if err != nil {
+			log.Fatalf("Error configuring the forward proxy: %v", err)
+		}
+	}
+
+	// HTTP/2 clients are served on the same port, see package h2c
+	server, err := h2c.Serve(port, route, h2c.Options{})
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -32,6 +68,60 @@ func main() {
 	log.Println("Server gracefully stopped")
 }
 
+func newForwardProxy(allow string, deny string, auth string) (*proxy.Forward, error) {
+	f := &proxy.Forward{}
+	var err error
+	if f.Allow, err = proxy.ParseRules(allow); err != nil {
+		return nil, err
+	}
+	if f.Deny, err = proxy.ParseRules(deny); err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		user, password, ok := strings.Cut(auth, ":")
+		if !ok {
+			return nil, fmt.Errorf("-proxy-auth wants user:password")
+		}
+		f.Users = map[string]string{user: password}
+	}
+	return f, nil
+}
+
+// compressed is handler behind the compression middleware.
+var compressed = server.Compress(handler)
+
+// route sends proxy requests and WebSocket handshakes straight to the
+// connection, the compression middleware can't hand it over.
+func route(w *response.Writer, req *request.Request) {
+	if forwardProxy != nil && proxy.IsForwardRequest(req) {
+		forwardProxy.Handle(w, req)
+		return
+	}
+	if req.RequestLine.RequestTarget == "/ws" && websocket.IsUpgrade(req) {
+		echoHandler(w, req)
+		return
+	}
+	compressed(w, req)
+}
+
+// echoHandler sends every WebSocket message back.
+func echoHandler(w *response.Writer, req *request.Request) {
+	conn, err := websocket.Upgrade(w, req, websocket.Options{Compression: true})
+	if err != nil {
+		log.Printf("websocket: %v", err)
+		return
+	}
+	for {
+		typ, p, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(typ, p); err != nil {
+			return
+		}
+	}
+}
+
 func handler(w *response.Writer, req *request.Request) {
 	if req.RequestLine.RequestTarget == "/yourproblem" {
 		handler400(w, req)
@@ -42,33 +132,51 @@ func handler(w *response.Writer, req *request.Request) {
 		return
 	}
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
+		httpbinProxy.Handle(w, req)
 		return
-		// response, err := sendHttpRequest(req.RequestLine.RequestTarget)
-		// if err != nil {
-		// 	handler500(w, req)
-		// }
-		// chunkedHandler(w, response.Body)
-		// return
-	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/video") {
+	}
+	if strings.HasPrefix(req.RequestLine.RequestTarget, "/assets") {
+		assets(w, req)
+		return
+	}
+	if req.RequestLine.RequestTarget == "/video" {
 		videoHandler(w, req)
 		return
 	}
+	if req.RequestLine.RequestTarget == "/events" {
+		eventsHandler(w, req)
+		return
+	}
 }
 
-func videoHandler(w *response.Writer, req *request.Request) {
-	w.WriteStatusLine(response.StatusOK)
-	vidBytes, err := os.ReadFile("assets/vim.mp4")
+// eventsHandler streams the time every second as Server-Sent Events,
+// numbered so a reconnecting client carries on where it left off.
+func eventsHandler(w *response.Writer, req *request.Request) {
+	stream, err := server.NewEventStream(w, req, server.EventStreamOptions{})
 	if err != nil {
-		log.Fatal(err)
-		handler500(w, req)
+		return
 	}
-	headers := response.GetDefaultHeaders(len(vidBytes))
-	headers.SetOVR("Content-Type", "video/mp4")
-	w.WriteHeaders(headers)
-	w.WriteBody(vidBytes)
+	defer stream.Close()
+	id, _ := strconv.Atoi(stream.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case now := <-ticker.C:
+			id++
+			stream.Send(server.Event{Event: "tick", ID: strconv.Itoa(id), Data: now.Format(time.RFC3339)})
+		}
+	}
+}
 
+// videoHandler is the old address of the video, now one of the assets.
+// The request is copied, the caller's keeps its target.
+func videoHandler(w *response.Writer, req *request.Request) {
+	r := *req
+	r.RequestLine.RequestTarget = "/assets/vim.mp4"
+	assets(w, &r)
 }
 
 func chunkedHandler(w *response.Writer, p io.ReadCloser) {
@@ -76,7 +184,7 @@ func chunkedHandler(w *response.Writer, p io.ReadCloser) {
 	buffer := make([]byte, 32)
 	headers := response.GetDefaultHeaders(0)
 	w.WriteStatusLine(200)
-	delete(headers, "Content-Length")
+	headers.Delete("Content-Length")
 	headers.Set("Transfer-Encoding", "chunked")
 	w.WriteHeaders(headers)
 	for {
@@ -149,68 +257,3 @@ func handler200(w *response.Writer, _ *request.Request) {
 	w.WriteHeaders(h)
 	w.WriteBody(body)
 }
-func sendHttpRequest(target string) (*http.Response, error) {
-	trimTarget := strings.TrimPrefix(target, "/httpbin")
-	urlString := fmt.Sprintf("https://httpbin.org%s", trimTarget)
-	response, err := http.Get(urlString)
-	if err != nil {
-		return nil, err
-	}
-	return response, nil
-}
-
-func proxyHandler(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	url := "https://httpbin.org/" + target
-	fmt.Println("Proxying to", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		handler500(w, req)
-		return
-	}
-	defer resp.Body.Close()
-
-	w.WriteStatusLine(response.StatusOK)
-	h := response.GetDefaultHeaders(0)
-	h.SetOVR("Transfer-Encoding", "chunked")
-	h.SetOVR("Trailer", " X-Content-Sha256, X-Content-Length")
-	h.Delete("Content-Length")
-	w.WriteHeaders(h)
-
-	const maxChunkSize = 1024
-	buffer := make([]byte, maxChunkSize)
-	var hashBuffer []byte
-	for {
-		n, err := resp.Body.Read(buffer)
-		fmt.Println("Read", n, "bytes")
-		if n > 0 {
-			hashBuffer = append(hashBuffer, buffer[:n]...)
-			_, err = w.WriteChunkedBody(buffer[:n])
-			if err != nil {
-				fmt.Println("Error writing chunked body:", err)
-				break
-			}
-		}
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			fmt.Println("Error reading response body:", err)
-			break
-		}
-	}
-	_, err = w.WriteChunkedBodyDone()
-	trailer := addTrailer(hashBuffer)
-	w.WriteTrailers(trailer)
-	if err != nil {
-		fmt.Println("Error writing chunked body done:", err)
-	}
-}
-
-func addTrailer(buf []byte) headers.Headers {
-	sum := sha256.Sum256(buf)
-	headers := headers.NewHeaders()
-	headers.Set("X-Content-Sha256", fmt.Sprintf("%x", sum))
-	headers.Set("X-Content-Length", fmt.Sprintf("%d", len(buf)))
-	return headers
-}
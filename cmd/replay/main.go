@@ -0,0 +1,318 @@
+package main
+
+import (
+	"MODULE_NAME/internal/capture"
+	"MODULE_NAME/internal/response"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+func main() {
+	target := flag.String("target", "localhost:42069", "where to send the requests, host:port or an http:// or https:// URL")
+	format := flag.String("format", "auto", "capture format: raw, har, json or auto to go by the file extension")
+	concurrency := flag.Int("c", 1, "how many requests to send at once")
+	diff := flag.Bool("diff", true, "compare the responses with the recorded ones")
+	ignore := flag.String("ignore", "Date,Age", "comma separated headers to leave out of the comparison")
+	save := flag.String("save", "", "write the requests and the responses received to this JSON file, to diff against later")
+	insecure := flag.Bool("k", false, "don't verify the server certificate")
+	timeout := flag.Duration("m", 10*time.Second, "timeout for each exchange")
+	verbose := flag.Bool("v", false, "show every response, not only the mismatches")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] FILE...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *concurrency < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var entries []*capture.Entry
+	for _, path := range flag.Args() {
+		loaded, err := load(path, *format)
+		if err != nil {
+			fail(fmt.Errorf("%s: %w", path, err))
+		}
+		entries = append(entries, loaded...)
+	}
+
+	addr, useTLS, err := parseTarget(*target)
+	if err != nil {
+		fail(err)
+	}
+	d := &dialer{addr: addr, timeout: *timeout}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		d.tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: *insecure}
+	}
+
+	results := make([]*result, len(entries))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = d.replay(entries[i])
+			}
+		}()
+	}
+	for i := range entries {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	ignored := map[string]bool{}
+	for _, name := range strings.Split(*ignore, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ignored[strings.ToLower(name)] = true
+		}
+	}
+	var failed, mismatched int
+	for i, res := range results {
+		entry := entries[i]
+		label := fmt.Sprintf("#%d %s", i+1, describe(entry))
+		if res.err != nil {
+			failed++
+			fmt.Printf("%s: %v\n", label, res.err)
+			continue
+		}
+		var problems []string
+		if *diff && entry.Response != nil {
+			problems = compare(entry.Response, res.resp, ignored)
+		}
+		if len(problems) > 0 {
+			mismatched++
+			fmt.Printf("%s: %d %s, MISMATCH (%s)\n", label, res.resp.StatusCode, res.resp.Reason, res.elapsed.Round(time.Microsecond))
+			for _, p := range problems {
+				fmt.Printf("    %s\n", p)
+			}
+		} else if *verbose || entry.Response == nil || !*diff {
+			fmt.Printf("%s: %d %s (%s)\n", label, res.resp.StatusCode, res.resp.Reason, res.elapsed.Round(time.Microsecond))
+		}
+	}
+	fmt.Printf("%d requests, %d mismatches, %d errors\n", len(entries), mismatched, failed)
+
+	if *save != "" {
+		if err := saveResults(*save, entries, results); err != nil {
+			fail(err)
+		}
+	}
+	if mismatched > 0 || failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "replay:", err)
+	os.Exit(1)
+}
+
+// load reads a capture. A raw file may end with a request that doesn't
+// parse, that one is kept since it's often the one worth replaying.
+func load(path string, format string) ([]*capture.Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if format == "auto" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".har":
+			format = "har"
+		case ".json", ".jsonl":
+			format = "json"
+		default:
+			format = "raw"
+		}
+	}
+	switch format {
+	case "har":
+		return capture.ReadHAR(f)
+	case "json":
+		return capture.ReadJSON(f)
+	case "raw":
+		reader := capture.NewReader(f)
+		var entries []*capture.Entry
+		for {
+			entry, err := reader.Next()
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			if entry == nil || len(entry.Raw) == 0 {
+				return entries, err
+			}
+			entries = append(entries, entry)
+			if err != nil {
+				return entries, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func parseTarget(target string) (string, bool, error) {
+	useTLS := false
+	switch {
+	case strings.HasPrefix(target, "https://"):
+		useTLS = true
+		target = strings.TrimPrefix(target, "https://")
+	case strings.HasPrefix(target, "http://"):
+		target = strings.TrimPrefix(target, "http://")
+	case strings.Contains(target, "://"):
+		return "", false, fmt.Errorf("unsupported target %q", target)
+	}
+	target = strings.TrimSuffix(target, "/")
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		port := "80"
+		if useTLS {
+			port = "443"
+		}
+		target = net.JoinHostPort(target, port)
+	}
+	return target, useTLS, nil
+}
+
+type dialer struct {
+	addr      string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+type result struct {
+	resp    *capture.Response
+	elapsed time.Duration
+	err     error
+}
+
+// replay sends the raw bytes of entry on a connection of its own and reads
+// the response.
+func (d *dialer) replay(entry *capture.Entry) *result {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", d.addr, d.timeout)
+	if err != nil {
+		return &result{err: err}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(d.timeout))
+	if d.tlsConfig != nil {
+		tlsConn := tls.Client(conn, d.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return &result{err: err}
+		}
+		conn = tlsConn
+	}
+
+	if _, err := conn.Write(entry.Raw); err != nil {
+		return &result{err: err}
+	}
+	method := entry.Method
+	if method == "" {
+		// the request didn't parse, the first word is the best guess
+		method, _, _ = strings.Cut(string(entry.Raw), " ")
+	}
+	parsed, err := response.ResponseFromReader(conn, method)
+	if err != nil {
+		return &result{err: fmt.Errorf("reading response: %w", err)}
+	}
+	return &result{
+		resp: &capture.Response{
+			StatusCode: int(parsed.StatusLine.StatusCode),
+			Reason:     parsed.StatusLine.ReasonPhrase,
+			Headers:    capture.HeaderList(parsed.Headers),
+			Body:       parsed.Body,
+		},
+		elapsed: time.Since(start),
+	}
+}
+
+// describe names an entry in the report.
+func describe(entry *capture.Entry) string {
+	if entry.Error != "" {
+		return fmt.Sprintf("malformed request (%s)", entry.Error)
+	}
+	return entry.Method + " " + entry.Target
+}
+
+// compare lists how got differs from the recorded response.
+func compare(want *capture.Response, got *capture.Response, ignored map[string]bool) []string {
+	var problems []string
+	if want.StatusCode != got.StatusCode {
+		problems = append(problems, fmt.Sprintf("status: recorded %d, got %d", want.StatusCode, got.StatusCode))
+	}
+
+	wantHeaders := headerLines(want.Headers, ignored)
+	gotHeaders := headerLines(got.Headers, ignored)
+	for _, line := range wantHeaders {
+		if i := slices.Index(gotHeaders, line); i != -1 {
+			gotHeaders = slices.Delete(gotHeaders, i, i+1)
+			continue
+		}
+		problems = append(problems, "- "+line)
+	}
+	for _, line := range gotHeaders {
+		problems = append(problems, "+ "+line)
+	}
+
+	if !bytes.Equal(want.Body, got.Body) {
+		problems = append(problems, bodyDiff(want.Body, got.Body))
+	}
+	return problems
+}
+
+func headerLines(list []capture.Header, ignored map[string]bool) []string {
+	var lines []string
+	for _, h := range list {
+		if ignored[strings.ToLower(h.Name)] {
+			continue
+		}
+		lines = append(lines, strings.ToLower(h.Name)+": "+h.Value)
+	}
+	return lines
+}
+
+// bodyDiff shows where two bodies start to differ.
+func bodyDiff(want []byte, got []byte) string {
+	i := 0
+	for i < len(want) && i < len(got) && want[i] == got[i] {
+		i++
+	}
+	from := max(i-16, 0)
+	excerpt := func(p []byte) string {
+		return capture.Escape(p[min(from, len(p)):min(i+16, len(p))])
+	}
+	return fmt.Sprintf("body: recorded %d bytes, got %d, first difference at byte %d: recorded \"%s\", got \"%s\"",
+		len(want), len(got), i, excerpt(want), excerpt(got))
+}
+
+// saveResults writes the entries with the responses just received so a
+// later run can diff against them.
+func saveResults(path string, entries []*capture.Entry, results []*result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		saved := *entry
+		saved.Response = results[i].resp
+		if err := capture.WriteJSON(f, &saved); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"MODULE_NAME/internal/capture"
 	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bytes"
+	"encoding/hex"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 func getLinesChannel(file io.ReadCloser) <-chan string {
@@ -88,33 +96,238 @@ func getLinesChannel(file io.ReadCloser) <-chan string {
 const port = ":42069"
 
 func main() {
-	listener, err := net.Listen("tcp", port)
+	addr := flag.String("addr", port, "address to listen on")
+	harPath := flag.String("har", "", "write every request to this HAR file")
+	jsonPath := flag.String("json", "", "append every request to this file as a line of JSON")
+	reply := flag.Bool("reply", true, "answer each request with an empty 200 so keep-alive clients send the next one")
+	idle := flag.Duration("idle", 2*time.Second, "how long to keep showing bytes after a parse error")
+	flag.Parse()
+
+	out := &output{harPath: *harPath}
+	if *jsonPath != "" {
+		f, err := os.OpenFile(*jsonPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			log.Fatalf("error opening %s: %s\n", *jsonPath, err.Error())
+		}
+		defer f.Close()
+		out.jsonFile = f
+	}
+
+	listener, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatalf("error listening for TCP traffic: %s\n", err.Error())
 	}
 	defer listener.Close()
 
-	fmt.Println("Listening for TCP traffic on", port)
+	fmt.Println("Listening for TCP traffic on", *addr)
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Fatalf("error: %s\n", err.Error())
+			log.Printf("error accepting connection: %s\n", err.Error())
+			continue
+		}
+		go inspect(conn, out, *reply, *idle)
+	}
+}
+
+// inspect shows every request sent on conn. After a parse error the stream
+// can't be framed anymore, what still arrives is shown as is.
+func inspect(conn net.Conn, out *output, reply bool, idle time.Duration) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+	out.printf("Accepted connection from %s\n", remote)
+	reader := capture.NewReader(conn)
+	for n := 1; ; n++ {
+		entry, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			out.printf("Connection from %s closed after %d requests\n", remote, n-1)
+			return
+		}
+		var parseErr *request.ParseError
+		if err != nil && !errors.As(err, &parseErr) && len(entry.Raw) == 0 {
+			out.printf("Connection from %s failed: %s\n", remote, err.Error())
+			return
+		}
+		entry.RemoteAddr = remote
+
+		if err == nil {
+			out.report(remote, n, entry, nil)
+			out.record(entry)
+			if reply {
+				writeReply(conn, response.StatusOK)
+			}
+			continue
+		}
+
+		if reply {
+			writeReply(conn, response.StatusBadRequest)
 		}
-		fmt.Println("Accepted connection from", conn.RemoteAddr())
+		out.report(remote, n, entry, drain(conn, idle))
+		out.record(entry)
+		return
+	}
+}
+
+func writeReply(conn net.Conn, code response.StatusCode) {
+	w := &response.Writer{ResWriter: conn}
+	h := response.GetDefaultHeaders(0)
+	if code == response.StatusOK {
+		h.SetOVR("Connection", "keep-alive")
+	}
+	w.WriteStatusLine(code)
+	w.WriteHeaders(h)
+}
 
-		req, err := request.RequestFromReader(conn)
+// drain collects what arrives until the peer is quiet for idle.
+func drain(conn net.Conn, idle time.Duration) []byte {
+	var rest []byte
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		n, err := conn.Read(buf)
+		rest = append(rest, buf[:n]...)
 		if err != nil {
-			log.Fatalf("error parsing request: %s\n", err.Error())
+			return rest
+		}
+	}
+}
+
+// output serializes the reports of concurrent connections and records the
+// entries.
+type output struct {
+	mu       sync.Mutex
+	harPath  string
+	entries  []*capture.Entry
+	jsonFile *os.File
+}
+
+func (o *output) printf(format string, args ...any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Printf(format, args...)
+}
+
+func (o *output) record(entry *capture.Entry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.jsonFile != nil {
+		if err := capture.WriteJSON(o.jsonFile, entry); err != nil {
+			log.Printf("error writing JSON: %s\n", err.Error())
+		}
+	}
+	if o.harPath != "" {
+		o.entries = append(o.entries, entry)
+		if err := o.writeHAR(); err != nil {
+			log.Printf("error writing HAR: %s\n", err.Error())
+		}
+	}
+}
+
+// writeHAR rewrites the whole log so the file is valid whenever the tool
+// is stopped.
+func (o *output) writeHAR() error {
+	tmp := o.harPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = capture.WriteHAR(f, o.entries, "http-in-go tcplistener")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.harPath)
+}
+
+func (o *output) report(remote string, n int, entry *capture.Entry, rest []byte) {
+	var sb strings.Builder
+	status := "ok"
+	if entry.Error != "" {
+		status = "PARSE ERROR"
+	}
+	fmt.Fprintf(&sb, "=== %s request #%d, %d bytes, %s ===\n", remote, n, len(entry.Raw), status)
+
+	if entry.Error == "" {
+		sb.WriteString("Request line:\n")
+		fmt.Fprintf(&sb, "- Method: %s\n", entry.Method)
+		fmt.Fprintf(&sb, "- Target: %s\n", entry.Target)
+		fmt.Fprintf(&sb, "- Version: %s\n", entry.Version)
+		sb.WriteString("Headers:\n")
+		for _, h := range entry.Headers {
+			fmt.Fprintf(&sb, "- %s: %s\n", h.Name, h.Value)
+		}
+		fmt.Fprintf(&sb, "Body: %d bytes\n", len(entry.Body))
+	} else {
+		line, column := position(entry.Raw, entry.ErrorOffset)
+		fmt.Fprintf(&sb, "Error at byte %d (line %d, column %d): %s\n", entry.ErrorOffset, line, column, entry.Error)
+	}
+
+	marker := -1
+	if entry.Error != "" {
+		marker = entry.ErrorOffset
+	}
+	sb.WriteString("--- text ---\n")
+	writeText(&sb, entry.Raw, marker, entry.Error)
+	sb.WriteString("--- hex ---\n")
+	writeHex(&sb, entry.Raw, marker)
+	if len(rest) > 0 {
+		fmt.Fprintf(&sb, "--- %d more bytes after the error, not parsed ---\n", len(rest))
+		writeText(&sb, rest, -1, "")
+		writeHex(&sb, rest, -1)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Print(sb.String())
+}
+
+// position turns a byte offset into a 1-based line and column.
+func position(raw []byte, offset int) (int, int) {
+	offset = min(offset, len(raw))
+	before := raw[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := offset - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, column
+}
+
+// writeText shows raw one line at a time, escaped, with a caret under the
+// byte at marker.
+func writeText(sb *strings.Builder, raw []byte, marker int, why string) {
+	start := 0
+	for start < len(raw) || (start == len(raw) && marker == len(raw)) {
+		end := len(raw)
+		if i := bytes.IndexByte(raw[start:], '\n'); i != -1 {
+			end = start + i + 1
+		}
+		line := raw[start:end]
+		fmt.Fprintf(sb, "%s\n", capture.Escape(line))
+		if marker >= start && (marker < end || end == len(raw)) {
+			indent := len(capture.Escape(raw[start:marker]))
+			fmt.Fprintf(sb, "%s^ %s\n", strings.Repeat(" ", indent), why)
+			marker = -1
 		}
-		fmt.Println("Request line:")
-		fmt.Printf("- Method: %s\n", req.RequestLine.Method)
-		fmt.Printf("- Target: %s\n", req.RequestLine.RequestTarget)
-		fmt.Printf("- Version: %s\n", req.RequestLine.HttpVersion)
-		fmt.Println("Headers:")
-		for key, value := range req.Headers {
-			fmt.Printf("- %s: %s\n", key, value)
+		if end == start {
+			break
+		}
+		start = end
+	}
+}
+
+// writeHex is hex.Dump with carets under the byte at marker.
+func writeHex(sb *strings.Builder, raw []byte, marker int) {
+	lines := strings.SplitAfter(hex.Dump(raw), "\n")
+	for i, line := range lines {
+		sb.WriteString(line)
+		if marker >= i*16 && marker < (i+1)*16 {
+			column := marker % 16
+			// "00000000  " then 3 columns a byte, one more space after 8
+			indent := 10 + 3*column
+			if column >= 8 {
+				indent++
+			}
+			fmt.Fprintf(sb, "%s^^\n", strings.Repeat(" ", indent))
 		}
-		fmt.Println("Body:")
-		fmt.Print(string(req.Body))
 	}
 }
@@ -0,0 +1,205 @@
+// Package capture records HTTP requests as they came off the wire and reads
+// them back: raw byte streams, JSON lines and HAR.
+package capture
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entry is one recorded request, and the response to it when there is one.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	// Raw is the request exactly as received, the bytes a replay sends.
+	Raw []byte `json:"raw"`
+
+	// The parsed request, empty when parsing failed.
+	Method  string   `json:"method,omitempty"`
+	Target  string   `json:"target,omitempty"`
+	Version string   `json:"version,omitempty"`
+	Headers []Header `json:"headers,omitempty"`
+	Body    []byte   `json:"body,omitempty"`
+
+	// Error is why the request didn't parse, ErrorOffset where in Raw.
+	Error       string `json:"error,omitempty"`
+	ErrorOffset int    `json:"errorOffset,omitempty"`
+
+	Response *Response `json:"response,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int      `json:"status"`
+	Reason     string   `json:"reason,omitempty"`
+	Headers    []Header `json:"headers,omitempty"`
+	Body       []byte   `json:"body,omitempty"`
+}
+
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Header returns the value of the first field called name.
+func (e *Entry) Header(name string) string {
+	for _, h := range e.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// HeaderList turns parsed headers into field lines in canonical casing,
+// sorted by name since Headers doesn't keep the order.
+func HeaderList(h headers.Headers) []Header {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := []Header{}
+	for _, key := range keys {
+		for _, value := range h.FieldLines(key) {
+			list = append(list, Header{Name: headers.CanonicalKey(key), Value: value})
+		}
+	}
+	return list
+}
+
+// Reader splits a stream, e.g. a keep-alive connection, into requests and
+// keeps the raw bytes of each.
+type Reader struct {
+	src io.Reader
+	// pending holds what was read past the previous request
+	pending []byte
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{src: src}
+}
+
+// recorder keeps a copy of everything read through it.
+type recorder struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	rec.buf.Write(p[:n])
+	return n, err
+}
+
+// Next reads the next request. It returns io.EOF when the stream ends
+// between two requests. When the request doesn't parse the entry is
+// returned along with the error, Raw holding everything read for it. The
+// stream can't be trusted after that.
+func (r *Reader) Next() (*Entry, error) {
+	rec := &recorder{r: io.MultiReader(bytes.NewReader(r.pending), r.src)}
+	r.pending = nil
+	req, err := request.RequestFromReader(rec)
+	if errors.Is(err, io.EOF) {
+		return nil, io.EOF
+	}
+	entry := &Entry{Time: time.Now()}
+	if err != nil {
+		entry.Raw = rec.buf.Bytes()
+		entry.Error = err.Error()
+		var parseErr *request.ParseError
+		if errors.As(err, &parseErr) {
+			entry.ErrorOffset = parseErr.Offset
+			// the wrapped error, the offset has its own field
+			entry.Error = parseErr.Err.Error()
+		}
+		return entry, err
+	}
+
+	leftover := req.Buffered()
+	raw := rec.buf.Bytes()
+	entry.Raw = raw[:len(raw)-len(leftover)]
+	r.pending = bytes.Clone(leftover)
+	entry.Method = req.RequestLine.Method
+	entry.Target = req.RequestLine.RequestTarget
+	entry.Version = req.RequestLine.HttpVersion
+	entry.Headers = HeaderList(req.Headers)
+	entry.Body = req.Body
+	return entry, nil
+}
+
+// ReadRaw splits a file of back to back requests into entries.
+func ReadRaw(r io.Reader) ([]*Entry, error) {
+	reader := NewReader(r)
+	var entries []*Entry
+	for {
+		entry, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, fmt.Errorf("request %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// WriteJSON appends e to w as one line of JSON.
+func WriteJSON(w io.Writer, e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadJSON reads the entries written by WriteJSON.
+func ReadJSON(r io.Reader) ([]*Entry, error) {
+	var entries []*Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entry := &Entry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return entries, fmt.Errorf("line %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Escape shows p as text with CR, LF and other control bytes made visible.
+func Escape(p []byte) string {
+	var sb strings.Builder
+	for _, b := range p {
+		switch {
+		case b == '\r':
+			sb.WriteString(`\r`)
+		case b == '\n':
+			sb.WriteString(`\n`)
+		case b == '\t':
+			sb.WriteString(`\t`)
+		case b == '\\':
+			sb.WriteString(`\\`)
+		case b < 0x20 || b >= 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, b)
+		default:
+			sb.WriteByte(b)
+		}
+	}
+	return sb.String()
+}
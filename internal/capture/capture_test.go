@@ -0,0 +1,156 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"MODULE_NAME/internal/request"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+const first = "POST /form?a=1 HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello"
+const second = "GET /two HTTP/1.1\r\nHost: example.com\r\n\r\n"
+const broken = "GET /three HTTP/1.1\r\nBad Header: x\r\n\r\n"
+
+func TestReader(t *testing.T) {
+	reader := NewReader(&chunkReader{data: first + second + broken, numBytesPerRead: 6})
+
+	entry, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, first, string(entry.Raw))
+	assert.Equal(t, "POST", entry.Method)
+	assert.Equal(t, "/form?a=1", entry.Target)
+	assert.Equal(t, "1.1", entry.Version)
+	assert.Equal(t, "hello", string(entry.Body))
+	assert.Equal(t, []Header{
+		{"Content-Length", "5"}, {"Content-Type", "text/plain"}, {"Host", "example.com"},
+	}, entry.Headers)
+
+	entry, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, second, string(entry.Raw))
+
+	// Test: The broken request keeps its raw bytes and where it failed
+	entry, err = reader.Next()
+	var parseErr *request.ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.True(t, strings.HasPrefix(string(entry.Raw), "GET /three HTTP/1.1\r\n"))
+	assert.Equal(t, len("GET /three HTTP/1.1\r\n"), entry.ErrorOffset)
+	assert.Equal(t, "Whitespace in field name", entry.Error)
+
+	// Test: A clean end
+	entries, err := ReadRaw(strings.NewReader(first + second))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	_, err = NewReader(strings.NewReader("")).Next()
+	assert.True(t, errors.Is(err, io.EOF))
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	entries, err := ReadRaw(strings.NewReader(first + second))
+	require.NoError(t, err)
+	entries[1].Response = &Response{StatusCode: 200, Reason: "OK", Body: []byte{0, 1, 2}}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		require.NoError(t, WriteJSON(&buf, e))
+	}
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+	read, err := ReadJSON(&buf)
+	require.NoError(t, err)
+	require.Len(t, read, 2)
+	assert.Equal(t, first, string(read[0].Raw))
+	assert.Equal(t, entries[0].Headers, read[0].Headers)
+	assert.Equal(t, []byte{0, 1, 2}, read[1].Response.Body)
+}
+
+func TestHARRoundTrip(t *testing.T) {
+	entries, err := ReadRaw(strings.NewReader(first + second))
+	require.NoError(t, err)
+	entries[0].RemoteAddr = "10.0.0.1:5000"
+	entries[0].Response = &Response{
+		StatusCode: 201,
+		Reason:     "Created",
+		Headers:    []Header{{"Content-Type", "application/octet-stream"}},
+		Body:       []byte{0xff, 0x00},
+	}
+	_, err = NewReader(strings.NewReader(broken)).Next()
+	require.Error(t, err)
+	bad, _ := NewReader(strings.NewReader(broken)).Next()
+	entries = append(entries, bad)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteHAR(&buf, entries, "test"))
+	assert.Contains(t, buf.String(), `"url": "http://example.com/form?a=1"`)
+	assert.Contains(t, buf.String(), `"text": "hello"`)
+
+	read, err := ReadHAR(&buf)
+	require.NoError(t, err)
+	require.Len(t, read, 3)
+	assert.Equal(t, first, string(read[0].Raw))
+	assert.Equal(t, "/form?a=1", read[0].Target)
+	assert.Equal(t, "hello", string(read[0].Body))
+	assert.Equal(t, "10.0.0.1:5000", read[0].RemoteAddr)
+	assert.Equal(t, 201, read[0].Response.StatusCode)
+	assert.Equal(t, []byte{0xff, 0x00}, read[0].Response.Body)
+	assert.Nil(t, read[1].Response)
+	assert.Equal(t, broken[:len(read[2].Raw)], string(read[2].Raw))
+	assert.Equal(t, bad.ErrorOffset, read[2].ErrorOffset)
+	assert.WithinDuration(t, entries[0].Time, read[0].Time, time.Millisecond)
+}
+
+func TestReadBrowserHAR(t *testing.T) {
+	// no _raw, HTTP/2 pseudo-headers, the request is rebuilt
+	har := `{"log": {"version": "1.2", "creator": {"name": "browser", "version": "1"}, "entries": [{
+		"startedDateTime": "2024-01-02T03:04:05.000Z",
+		"request": {
+			"method": "PUT", "url": "https://api.example.com/items/7?x=y", "httpVersion": "h2",
+			"headers": [{"name": ":authority", "value": "api.example.com"}, {"name": "content-type", "value": "application/json"},
+				{"name": "content-length", "value": "99"}],
+			"postData": {"mimeType": "application/json", "text": "{\"a\":1}"}
+		},
+		"response": {"status": 200, "statusText": "", "headers": [], "content": {"size": 2, "mimeType": "text/plain", "text": "b2s=", "encoding": "base64"}}
+	}]}}`
+	entries, err := ReadHAR(strings.NewReader(har))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "PUT /items/7?x=y HTTP/1.1\r\n"+
+		"Host: api.example.com\r\n"+
+		"content-type: application/json\r\n"+
+		"Content-Length: 7\r\n"+
+		"\r\n"+
+		`{"a":1}`, string(entries[0].Raw))
+	assert.Equal(t, "ok", string(entries[0].Response.Body))
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, `GET / HTTP/1.1\r\n\x00\\\xff`, Escape([]byte("GET / HTTP/1.1\r\n\x00\\\xff")))
+}
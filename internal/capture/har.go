@@ -0,0 +1,309 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The HAR 1.2 format, http://www.softwareishard.com/blog/har-12-spec/.
+// Fields starting with an underscore are custom, HAR allows those. _raw
+// keeps the request bytes so a replay can be byte exact.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	RemoteAddr      string      `json:"_remoteAddr,omitempty"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []Header     `json:"cookies"`
+	Headers     []Header     `json:"headers"`
+	QueryString []Header     `json:"queryString"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+	Raw         string       `json:"_raw,omitempty"`
+	Error       string       `json:"_error,omitempty"`
+	ErrorOffset int          `json:"_errorOffset,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Cookies     []Header   `json:"cookies"`
+	Headers     []Header   `json:"headers"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int        `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// WriteHAR writes entries as a HAR log. Requests that didn't parse are kept
+// with their raw bytes and the error.
+func WriteHAR(w io.Writer, entries []*Entry, creator string) error {
+	file := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: creator, Version: "1.0"},
+		Entries: []harEntry{},
+	}}
+	for _, e := range entries {
+		file.Log.Entries = append(file.Log.Entries, toHAR(e))
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(file)
+}
+
+func toHAR(e *Entry) harEntry {
+	headersSize := len(e.Raw) - len(e.Body)
+	if e.Error != "" {
+		headersSize = -1
+	}
+	req := harRequest{
+		Method:      e.Method,
+		URL:         absoluteURL(e),
+		HTTPVersion: "HTTP/" + e.Version,
+		Cookies:     []Header{},
+		Headers:     nonNil(e.Headers),
+		QueryString: []Header{},
+		HeadersSize: headersSize,
+		BodySize:    len(e.Body),
+		Raw:         base64.StdEncoding.EncodeToString(e.Raw),
+		Error:       e.Error,
+		ErrorOffset: e.ErrorOffset,
+	}
+	if e.Version == "" {
+		req.HTTPVersion = ""
+	}
+	if u, err := url.Parse(req.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				req.QueryString = append(req.QueryString, Header{Name: name, Value: value})
+			}
+		}
+	}
+	if len(e.Body) > 0 {
+		req.PostData = &harPostData{MimeType: e.Header("Content-Type")}
+		req.PostData.Text, req.PostData.Encoding = encodeText(e.Body)
+	}
+
+	resp := harResponse{
+		Cookies:     []Header{},
+		Headers:     []Header{},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+	if e.Response != nil {
+		resp.Status = e.Response.StatusCode
+		resp.StatusText = e.Response.Reason
+		resp.HTTPVersion = "HTTP/1.1"
+		resp.Headers = nonNil(e.Response.Headers)
+		resp.BodySize = len(e.Response.Body)
+		resp.Content.Size = len(e.Response.Body)
+		for _, h := range e.Response.Headers {
+			if strings.EqualFold(h.Name, "Content-Type") {
+				resp.Content.MimeType = h.Value
+			}
+		}
+		resp.Content.Text, resp.Content.Encoding = encodeText(e.Response.Body)
+	}
+
+	return harEntry{
+		StartedDateTime: e.Time.Format(time.RFC3339Nano),
+		Request:         req,
+		Response:        resp,
+		RemoteAddr:      e.RemoteAddr,
+	}
+}
+
+// absoluteURL builds the URL HAR wants from the target and Host.
+func absoluteURL(e *Entry) string {
+	if strings.Contains(e.Target, "://") {
+		return e.Target
+	}
+	host := e.Header("Host")
+	if host == "" {
+		host = "unknown"
+	}
+	return "http://" + host + e.Target
+}
+
+// encodeText keeps text readable and base64 encodes the rest.
+func encodeText(p []byte) (string, string) {
+	if isText(p) {
+		return string(p), ""
+	}
+	return base64.StdEncoding.EncodeToString(p), "base64"
+}
+
+func isText(p []byte) bool {
+	if !utf8.Valid(p) {
+		return false
+	}
+	for _, b := range p {
+		if (b < 0x20 && b != '\r' && b != '\n' && b != '\t') || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func nonNil(list []Header) []Header {
+	if list == nil {
+		return []Header{}
+	}
+	return list
+}
+
+// ReadHAR reads the entries of a HAR log, ours or a browser's. Without _raw
+// the request bytes are rebuilt from the recorded fields.
+func ReadHAR(r io.Reader) ([]*Entry, error) {
+	var file harFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for i, he := range file.Log.Entries {
+		entry, err := fromHAR(he)
+		if err != nil {
+			return entries, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func fromHAR(he harEntry) (*Entry, error) {
+	entry := &Entry{
+		RemoteAddr:  he.RemoteAddr,
+		Method:      he.Request.Method,
+		Version:     strings.TrimPrefix(he.Request.HTTPVersion, "HTTP/"),
+		Headers:     he.Request.Headers,
+		Error:       he.Request.Error,
+		ErrorOffset: he.Request.ErrorOffset,
+	}
+	entry.Time, _ = time.Parse(time.RFC3339Nano, he.StartedDateTime)
+	if he.Request.PostData != nil {
+		body, err := decodeText(he.Request.PostData.Text, he.Request.PostData.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		entry.Body = body
+	}
+
+	u, err := url.Parse(he.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Error == "" {
+		entry.Target = u.RequestURI()
+	}
+	if he.Request.Raw != "" {
+		entry.Raw, err = base64.StdEncoding.DecodeString(he.Request.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("_raw: %w", err)
+		}
+	} else {
+		entry.Raw = rebuildRaw(entry, u.Host)
+	}
+
+	// status 0 means no response was recorded
+	if he.Response.Status != 0 {
+		body, err := decodeText(he.Response.Content.Text, he.Response.Content.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		entry.Response = &Response{
+			StatusCode: he.Response.Status,
+			Reason:     he.Response.StatusText,
+			Headers:    he.Response.Headers,
+			Body:       body,
+		}
+	}
+	return entry, nil
+}
+
+func decodeText(text string, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// rebuildRaw writes the request an entry describes. Browsers record
+// HTTP/2 pseudo-headers and versions, those become HTTP/1.1.
+func rebuildRaw(e *Entry, host string) []byte {
+	var buf bytes.Buffer
+	version := e.Version
+	if version != "1.0" && version != "1.1" {
+		version = "1.1"
+	}
+	fmt.Fprintf(&buf, "%s %s HTTP/%s\r\n", e.Method, e.Target, version)
+	if e.Header("Host") == "" {
+		fmt.Fprintf(&buf, "Host: %s\r\n", host)
+	}
+	for _, h := range e.Headers {
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		if strings.EqualFold(h.Name, "Content-Length") || strings.EqualFold(h.Name, "Transfer-Encoding") {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.Name, h.Value)
+	}
+	if len(e.Body) > 0 || e.Method == "POST" || e.Method == "PUT" || e.Method == "PATCH" {
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n", len(e.Body))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(e.Body)
+	return buf.Bytes()
+}
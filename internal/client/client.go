@@ -0,0 +1,375 @@
+package client
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client sends HTTP/1.1 requests over plain TCP or TLS.
+type Client struct {
+	// Timeout bounds the whole exchange, from dialing to the end of the
+	// body. Zero means no timeout.
+	Timeout time.Duration
+	// TLSConfig is used for https URLs. ServerName defaults to the URL host.
+	TLSConfig *tls.Config
+
+	// DisableKeepAlives sends Connection: close and dials for every request.
+	DisableKeepAlives bool
+	// MaxIdleConns caps the idle connections kept across all hosts. Zero
+	// means 100.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost caps the idle connections kept per host. Zero
+	// means 2.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost caps the connections per host, busy or idle. Requests
+	// over the limit wait for one to free up. Zero means no limit.
+	MaxConnsPerHost int
+	// IdleTimeout is how long an idle connection stays in the pool. Zero
+	// means 90 seconds.
+	IdleTimeout time.Duration
+
+	// MaxRedirects is how many redirects Do follows. Zero means 10, a
+	// negative value turns redirects off and returns the 3xx response.
+	MaxRedirects int
+	// Jar stores the cookies of every response and adds the matching ones
+	// to requests. Nil means no cookies.
+	Jar CookieJar
+
+	poolOnce sync.Once
+	pool     *connPool
+}
+
+// Request is an outgoing request.
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	Body    []byte
+	// Trace, if set, is told about every step of the exchange.
+	Trace *Trace
+}
+
+// Response is what came back. Body has to be closed, it holds the
+// connection.
+type Response struct {
+	// URL is where the response came from, the last hop of a redirect.
+	URL        *url.URL
+	Proto      string
+	StatusCode response.StatusCode
+	Reason     string
+	Headers    headers.Headers
+	// Trailers are filled in once a chunked Body has been read to the end.
+	Trailers headers.Headers
+	Body     io.ReadCloser
+}
+
+func NewRequest(method string, rawURL string, body []byte) (*Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("url %q has no host", rawURL)
+	}
+	return &Request{
+		Method:  method,
+		URL:     u,
+		Headers: headers.NewHeaders(),
+		Body:    body,
+	}, nil
+}
+
+func (c *Client) Get(rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// send makes one exchange. The body is streamed from the connection as the
+// caller reads it, and the connection goes back to the pool once the body is
+// read to the end or closed.
+//
+// An idempotent request that fails on a pooled connection, which the server
+// may have closed while it was idle, is sent once more on a new one.
+func (c *Client) send(req *Request) (*Response, error) {
+	var deadline time.Time
+	if c.Timeout > 0 {
+		deadline = time.Now().Add(c.Timeout)
+	}
+	pool := c.connPool()
+	key := req.URL.Scheme + "://" + hostPort(req.URL)
+
+	pc, err := pool.get(key, deadline, false)
+	if err != nil {
+		return nil, err
+	}
+	if pc == nil {
+		pc, err = c.dialConn(req, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	req.Trace.gotConn(pc.reused)
+	resp, err := c.roundTrip(pc, req, deadline)
+	if err == nil || !pc.reused || !isIdempotent(req.Method) {
+		return resp, err
+	}
+
+	pool.retries.Add(1)
+	if _, err := pool.get(key, deadline, true); err != nil {
+		return nil, err
+	}
+	pc, err = c.dialConn(req, key)
+	if err != nil {
+		return nil, err
+	}
+	req.Trace.gotConn(false)
+	return c.roundTrip(pc, req, deadline)
+}
+
+// Stats returns the connection pool counters.
+func (c *Client) Stats() PoolStats {
+	return c.connPool().stats()
+}
+
+// CloseIdleConnections closes the connections waiting in the pool.
+func (c *Client) CloseIdleConnections() {
+	c.connPool().closeIdle()
+}
+
+func (c *Client) connPool() *connPool {
+	c.poolOnce.Do(func() {
+		c.pool = newConnPool(c)
+	})
+	return c.pool
+}
+
+// dialConn opens a new connection for key, the room for it has been taken
+// with pool.get already.
+func (c *Client) dialConn(req *Request, key string) (*persistConn, error) {
+	conn, err := c.dial(req.URL, req.Trace)
+	if err != nil {
+		c.pool.release(key)
+		return nil, err
+	}
+	c.pool.dials.Add(1)
+	return newPersistConn(conn, key), nil
+}
+
+func (c *Client) roundTrip(pc *persistConn, req *Request, deadline time.Time) (*Response, error) {
+	pc.SetDeadline(deadline)
+	var conn net.Conn = pc
+	if req.Trace != nil {
+		conn = &tracedConn{Conn: pc, trace: req.Trace}
+	}
+	err := writeRequest(conn, req, c.DisableKeepAlives)
+	req.Trace.wroteRequest(err)
+	if err != nil {
+		c.pool.discard(pc)
+		return nil, err
+	}
+
+	parsed, err := response.ResponseHeadersFromReader(conn, req.Method)
+	if err != nil {
+		c.pool.discard(pc)
+		return nil, err
+	}
+	if req.Trace != nil && req.Trace.Chunk != nil {
+		parsed.OnChunk(req.Trace.Chunk)
+	}
+	keepAlive := !c.DisableKeepAlives && !hasOption(req.Headers, "close")
+	return &Response{
+		URL:        req.URL,
+		Proto:      "HTTP/" + parsed.StatusLine.HttpVersion,
+		StatusCode: parsed.StatusLine.StatusCode,
+		Reason:     parsed.StatusLine.ReasonPhrase,
+		Headers:    parsed.Headers,
+		// the parser fills the same map once the body is read to the end
+		Trailers: parsed.Trailers,
+		Body: &connBody{
+			Reader:    parsed.BodyReader(),
+			parsed:    parsed,
+			pc:        pc,
+			pool:      c.pool,
+			keepAlive: keepAlive,
+		},
+	}, nil
+}
+
+// dial resolves the host and tries its addresses in turn, then does the
+// TLS handshake for https.
+func (c *Client) dial(u *url.URL, trace *Trace) (net.Conn, error) {
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	host := u.Hostname()
+	_, port, _ := net.SplitHostPort(hostPort(u))
+	addrs := []string{host}
+	if net.ParseIP(host) == nil {
+		trace.dnsStart(host)
+		var err error
+		addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+		trace.dnsDone(addrs, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dialer net.Dialer
+	var conn net.Conn
+	var err error
+	for _, addr := range addrs {
+		address := net.JoinHostPort(addr, port)
+		trace.connectStart(address)
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+		trace.connectDone(address, err)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return conn, nil
+	}
+
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	trace.tlsHandshakeStart()
+	err = tlsConn.HandshakeContext(ctx)
+	trace.tlsHandshakeDone(tlsConn.ConnectionState(), err)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func writeRequest(w io.Writer, req *Request, closeConn bool) error {
+	h := req.Headers.Clone()
+	if _, err := h.Get("Host"); err != nil {
+		h.SetOVR("Host", req.URL.Host)
+	}
+	if closeConn {
+		h.SetOVR("Connection", "close")
+	}
+	h.Delete("Transfer-Encoding")
+	// a body-less POST still needs to say so, GET and friends don't
+	if len(req.Body) > 0 || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		h.SetOVR("Content-Length", strconv.Itoa(len(req.Body)))
+	} else {
+		h.Delete("Content-Length")
+	}
+
+	r := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.Method,
+			RequestTarget: req.URL.RequestURI(),
+			HttpVersion:   "1.1",
+		},
+		Headers: h,
+		Body:    req.Body,
+	}
+	bw := bufio.NewWriter(w)
+	err := r.Write(bw)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func hasOption(h headers.Headers, option string) bool {
+	for _, value := range h.Values("Connection") {
+		if strings.EqualFold(value, option) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdempotent reports whether a request can safely be sent twice
+// (RFC 9110 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// connBody hands the connection back to the pool once the response was read
+// to the end, and closes it otherwise.
+type connBody struct {
+	io.Reader
+	parsed    *response.Response
+	pc        *persistConn
+	pool      *connPool
+	keepAlive bool
+	closed    bool
+}
+
+func (b *connBody) Read(p []byte) (int, error) {
+	if b.closed {
+		if b.parsed.Done() {
+			return 0, io.EOF
+		}
+		return 0, errors.New("read on closed response body")
+	}
+	n, err := b.Reader.Read(p)
+	if errors.Is(err, io.EOF) {
+		b.Close()
+	}
+	return n, err
+}
+
+func (b *connBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.keepAlive && b.parsed.Reusable() {
+		b.pool.put(b.pc)
+		return nil
+	}
+	b.pool.discard(b.pc)
+	return nil
+}
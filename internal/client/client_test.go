@@ -0,0 +1,181 @@
+package client
+
+import (
+	"MODULE_NAME/internal/response"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawServer answers every connection with the given bytes and then closes it.
+func rawServer(t *testing.T, reply string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// wait for the end of the request headers
+				buf := make([]byte, 4096)
+				conn.Read(buf)
+				conn.Write([]byte(reply))
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String()
+}
+
+func TestClientContentLength(t *testing.T) {
+	var seen *http.Request
+	var seenBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		seenBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("X-Reply", "1")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, "got it")
+	}))
+	defer srv.Close()
+
+	c := &Client{Timeout: 5 * time.Second}
+	req, err := NewRequest("POST", srv.URL+"/submit?x=1", []byte("payload"))
+	require.NoError(t, err)
+	req.Headers.Set("X-Custom", "yes")
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "POST", seen.Method)
+	assert.Equal(t, "/submit?x=1", seen.URL.RequestURI())
+	assert.Equal(t, "yes", seen.Header.Get("X-Custom"))
+	assert.Equal(t, "payload", string(seenBody))
+	assert.Equal(t, "HTTP/1.1", resp.Proto)
+	assert.Equal(t, response.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "Accepted", resp.Reason)
+	value, _ := resp.Headers.Get("X-Reply")
+	assert.Equal(t, "1", value)
+	assert.Equal(t, "got it", string(body))
+}
+
+func TestClientChunked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "part%d;", i)
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("X-Checksum", "abc")
+	}))
+	defer srv.Close()
+
+	resp, err := (&Client{}).Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	te, _ := resp.Headers.Get("Transfer-Encoding")
+	assert.Equal(t, "chunked", te)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "part0;part1;part2;", string(body))
+	value, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", value)
+}
+
+func TestClientCloseDelimited(t *testing.T) {
+	// Test: No length, the body ends with the connection
+	url := rawServer(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nuntil the end")
+	resp, err := (&Client{}).Get(url)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: Interim responses are skipped
+	url = rawServer(t, "HTTP/1.1 103 Early Hints\r\nLink: </a.css>; rel=preload\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	resp, err = (&Client{}).Get(url)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, response.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+
+	// Test: Connection closed before Content-Length bytes arrived
+	url = rawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort")
+	resp, err = (&Client{}).Get(url)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	resp.Body.Close()
+
+	// Test: Garbage status line
+	url = rawServer(t, "SMTP ready\r\n\r\n")
+	_, err = (&Client{}).Get(url)
+	require.Error(t, err)
+}
+
+func TestClientMethods(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("Content-Length", "4")
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+
+	c := &Client{}
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS", "HEAD"} {
+		req, err := NewRequest(method, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		require.NoError(t, err, method)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		value, _ := resp.Headers.Get("X-Method")
+		assert.Equal(t, method, value)
+		if method == "HEAD" {
+			assert.Empty(t, body)
+		} else {
+			assert.Equal(t, "body", string(body), method)
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	c := &Client{TLSConfig: &tls.Config{RootCAs: roots}}
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "secure", string(body))
+
+	// Test: Untrusted certificate
+	_, err = (&Client{}).Get(srv.URL)
+	require.Error(t, err)
+}
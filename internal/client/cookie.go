@@ -0,0 +1,262 @@
+package client
+
+import (
+	"MODULE_NAME/internal/headers"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cookie is a cookie as sent in Set-Cookie (RFC 6265 4.1).
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain  string
+	Path    string
+	Expires time.Time
+	// MaxAge is in seconds. Zero means no Max-Age attribute, a negative
+	// value means Max-Age=0, the cookie is to be deleted.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	// SameSite is "Strict", "Lax", "None" or empty.
+	SameSite string
+}
+
+// CookieJar stores the cookies a server sets and picks the ones to send
+// with a request. Jar is an in-memory implementation.
+type CookieJar interface {
+	SetCookies(u *url.URL, cookies []*Cookie)
+	Cookies(u *url.URL) []*Cookie
+}
+
+// cookieTimeFormat is the Expires format most servers actually send.
+const cookieTimeFormat = "Mon, 02-Jan-2006 15:04:05 MST"
+
+// ParseSetCookie parses a Set-Cookie field value. Unknown or malformed
+// attributes are ignored, like a browser does (RFC 6265 5.2).
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	name, value, found := strings.Cut(parts[0], "=")
+	name = strings.TrimSpace(name)
+	if !found || !headers.IsToken(name) {
+		return nil, fmt.Errorf("malformed Set-Cookie: %q", line)
+	}
+	value = strings.TrimSpace(value)
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	cookie := &Cookie{Name: name, Value: value}
+
+	for _, part := range parts[1:] {
+		attr, attrValue, _ := strings.Cut(part, "=")
+		attr = strings.ToLower(strings.TrimSpace(attr))
+		attrValue = strings.TrimSpace(attrValue)
+		switch attr {
+		case "domain":
+			cookie.Domain = strings.ToLower(strings.TrimPrefix(attrValue, "."))
+		case "path":
+			if strings.HasPrefix(attrValue, "/") {
+				cookie.Path = attrValue
+			}
+		case "expires":
+			t, err := headers.ParseTime(attrValue)
+			if err != nil {
+				t, err = time.Parse(cookieTimeFormat, attrValue)
+			}
+			if err == nil {
+				cookie.Expires = t.UTC()
+			}
+		case "max-age":
+			seconds, err := strconv.Atoi(attrValue)
+			if err != nil {
+				continue
+			}
+			if seconds <= 0 {
+				seconds = -1
+			}
+			cookie.MaxAge = seconds
+		case "secure":
+			cookie.Secure = true
+		case "httponly":
+			cookie.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(attrValue) {
+			case "strict":
+				cookie.SameSite = "Strict"
+			case "lax":
+				cookie.SameSite = "Lax"
+			case "none":
+				cookie.SameSite = "None"
+			}
+		}
+	}
+	return cookie, nil
+}
+
+// Jar is an in-memory CookieJar following the storage model of RFC 6265 5.3.
+// It has no public suffix list, a Domain attribute without a dot is only
+// accepted when it is the request host itself.
+type Jar struct {
+	mu      sync.Mutex
+	entries map[string]*jarEntry
+	// seq orders cookies of the same path length by creation
+	seq uint64
+}
+
+type jarEntry struct {
+	cookie   Cookie
+	hostOnly bool
+	// expires is zero for session cookies
+	expires time.Time
+	created uint64
+}
+
+func NewJar() *Jar {
+	return &Jar{entries: map[string]*jarEntry{}}
+}
+
+func (j *Jar) SetCookies(u *url.URL, cookies []*Cookie) {
+	host := strings.ToLower(u.Hostname())
+	now := time.Now()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		entry := &jarEntry{cookie: *c}
+		if c.Domain == "" || c.Domain == host {
+			entry.cookie.Domain = host
+			entry.hostOnly = c.Domain == ""
+		} else {
+			if !domainMatch(host, c.Domain) || !strings.Contains(c.Domain, ".") {
+				continue
+			}
+		}
+		if entry.cookie.Path == "" {
+			entry.cookie.Path = defaultPath(u)
+		}
+		// a plain http response can't set or overwrite secure cookies
+		if c.Secure && u.Scheme != "https" {
+			continue
+		}
+		if c.SameSite == "None" && !c.Secure {
+			continue
+		}
+
+		key := entry.cookie.Domain + ";" + entry.cookie.Path + ";" + c.Name
+		switch {
+		case c.MaxAge < 0:
+			delete(j.entries, key)
+			continue
+		case c.MaxAge > 0:
+			// Max-Age wins over Expires
+			entry.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			if !c.Expires.After(now) {
+				delete(j.entries, key)
+				continue
+			}
+			entry.expires = c.Expires
+		}
+
+		if old, ok := j.entries[key]; ok {
+			entry.created = old.created
+		} else {
+			j.seq++
+			entry.created = j.seq
+		}
+		j.entries[key] = entry
+	}
+}
+
+// Cookies returns the cookies to send to u, longer paths first
+// (RFC 6265 5.4).
+func (j *Jar) Cookies(u *url.URL) []*Cookie {
+	host := strings.ToLower(u.Hostname())
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	now := time.Now()
+	j.mu.Lock()
+	var matched []*jarEntry
+	for key, entry := range j.entries {
+		if !entry.expires.IsZero() && !entry.expires.After(now) {
+			delete(j.entries, key)
+			continue
+		}
+		if entry.hostOnly && host != entry.cookie.Domain {
+			continue
+		}
+		if !entry.hostOnly && !domainMatch(host, entry.cookie.Domain) {
+			continue
+		}
+		if !pathMatch(path, entry.cookie.Path) {
+			continue
+		}
+		if entry.cookie.Secure && u.Scheme != "https" {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+	j.mu.Unlock()
+
+	sort.Slice(matched, func(a, b int) bool {
+		if len(matched[a].cookie.Path) != len(matched[b].cookie.Path) {
+			return len(matched[a].cookie.Path) > len(matched[b].cookie.Path)
+		}
+		return matched[a].created < matched[b].created
+	})
+	cookies := make([]*Cookie, 0, len(matched))
+	for _, entry := range matched {
+		c := entry.cookie
+		cookies = append(cookies, &c)
+	}
+	return cookies
+}
+
+// domainMatch implements RFC 6265 5.1.3, IP addresses only match exactly.
+func domainMatch(host string, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// pathMatch implements RFC 6265 5.1.4.
+func pathMatch(path string, cookiePath string) bool {
+	if path == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+}
+
+// defaultPath is the directory of the request path (RFC 6265 5.1.4).
+func defaultPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if !strings.HasPrefix(path, "/") {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// cookieHeader joins cookies into a Cookie field value.
+func cookieHeader(cookies []*Cookie) string {
+	pairs := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		pairs = append(pairs, c.Name+"="+c.Value)
+	}
+	return strings.Join(pairs, "; ")
+}
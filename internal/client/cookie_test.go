@@ -0,0 +1,118 @@
+package client
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSetCookie(t *testing.T) {
+	// Test: All the attributes
+	c, err := ParseSetCookie(`id="a3fWa"; Domain=.Example.com; Path=/docs; Expires=Wed, 21 Oct 2037 07:28:00 GMT; ` +
+		`Max-Age=3600; Secure; HttpOnly; SameSite=lax; Priority=High`)
+	require.NoError(t, err)
+	assert.Equal(t, "id", c.Name)
+	assert.Equal(t, "a3fWa", c.Value)
+	assert.Equal(t, "example.com", c.Domain)
+	assert.Equal(t, "/docs", c.Path)
+	assert.Equal(t, time.Date(2037, 10, 21, 7, 28, 0, 0, time.UTC), c.Expires)
+	assert.Equal(t, 3600, c.MaxAge)
+	assert.True(t, c.Secure)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, "Lax", c.SameSite)
+
+	// Test: Dashed Expires, bad attributes are ignored
+	c, err = ParseSetCookie("theme=dark; Expires=Wed, 21-Oct-2037 07:28:00 GMT; Max-Age=soon; Path=relative; SameSite=sometimes")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2037, 10, 21, 7, 28, 0, 0, time.UTC), c.Expires)
+	assert.Equal(t, 0, c.MaxAge)
+	assert.Equal(t, "", c.Path)
+	assert.Equal(t, "", c.SameSite)
+
+	// Test: Max-Age=0 deletes
+	c, err = ParseSetCookie("theme=; Max-Age=0")
+	require.NoError(t, err)
+	assert.Equal(t, "", c.Value)
+	assert.Equal(t, -1, c.MaxAge)
+
+	// Test: Invalid
+	for _, line := range []string{"", "novalue", "=value", "bad name=1"} {
+		_, err = ParseSetCookie(line)
+		require.Error(t, err, line)
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func setCookies(t *testing.T, jar *Jar, rawURL string, lines ...string) {
+	t.Helper()
+	var cookies []*Cookie
+	for _, line := range lines {
+		c, err := ParseSetCookie(line)
+		require.NoError(t, err)
+		cookies = append(cookies, c)
+	}
+	jar.SetCookies(mustParseURL(t, rawURL), cookies)
+}
+
+func cookiesFor(t *testing.T, jar *Jar, rawURL string) string {
+	t.Helper()
+	return cookieHeader(jar.Cookies(mustParseURL(t, rawURL)))
+}
+
+func TestJar(t *testing.T) {
+	jar := NewJar()
+	setCookies(t, jar, "http://www.example.com/account/login",
+		"host=1",
+		"domain=2; Domain=example.com; Path=/",
+		"deep=3; Path=/account/settings",
+		"secure=4; Secure",
+		"other=5; Domain=other.com",
+		"tld=6; Domain=com",
+	)
+	setCookies(t, jar, "https://www.example.com/", "secure=4; Secure; Path=/")
+
+	// Test: Host-only, domain and default path
+	assert.Equal(t, "host=1; domain=2", cookiesFor(t, jar, "http://www.example.com/account/"))
+	assert.Equal(t, "domain=2", cookiesFor(t, jar, "http://www.example.com/"))
+	assert.Equal(t, "domain=2", cookiesFor(t, jar, "http://api.example.com/account/x"))
+	assert.Equal(t, "", cookiesFor(t, jar, "http://example.org/"))
+	// "/accountant" doesn't path-match "/account"
+	assert.Equal(t, "domain=2", cookiesFor(t, jar, "http://www.example.com/accountant"))
+
+	// Test: Longer paths first
+	assert.Equal(t, "deep=3; host=1; domain=2", cookiesFor(t, jar, "http://www.example.com/account/settings/x"))
+
+	// Test: Secure only over https, and only set over https
+	assert.Equal(t, "domain=2; secure=4", cookiesFor(t, jar, "https://www.example.com/"))
+
+	// Test: Replacing and deleting
+	setCookies(t, jar, "http://www.example.com/", "domain=new; Domain=example.com; Path=/")
+	assert.Equal(t, "domain=new", cookiesFor(t, jar, "http://www.example.com/"))
+	setCookies(t, jar, "http://www.example.com/", "domain=; Domain=example.com; Path=/; Max-Age=0")
+	assert.Equal(t, "", cookiesFor(t, jar, "http://www.example.com/"))
+	setCookies(t, jar, "http://www.example.com/account/", "host=; Expires=Thu, 01 Jan 1970 00:00:00 GMT")
+	assert.Equal(t, "", cookiesFor(t, jar, "http://www.example.com/account/"))
+
+	// Test: Max-Age wins over Expires and runs out
+	setCookies(t, jar, "http://example.com/", "short=1; Max-Age=1; Expires=Wed, 21 Oct 2037 07:28:00 GMT")
+	assert.Equal(t, "short=1", cookiesFor(t, jar, "http://example.com/"))
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, "", cookiesFor(t, jar, "http://example.com/"))
+
+	// Test: SameSite=None needs Secure
+	setCookies(t, jar, "https://example.com/", "cross=1; SameSite=None", "cross2=2; SameSite=None; Secure")
+	assert.Equal(t, "cross2=2", cookiesFor(t, jar, "https://example.com/"))
+
+	// Test: IP hosts only match exactly
+	setCookies(t, jar, "http://127.0.0.1/", "ip=1")
+	assert.Equal(t, "ip=1", cookiesFor(t, jar, "http://127.0.0.1:8080/"))
+}
@@ -0,0 +1,256 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleTimeout         = 90 * time.Second
+)
+
+// PoolStats is a snapshot of the connection pool counters.
+type PoolStats struct {
+	// Dials counts new connections.
+	Dials uint64
+	// Reuses counts requests sent on a pooled connection.
+	Reuses uint64
+	// Retries counts requests sent again after a pooled connection failed.
+	Retries uint64
+	// DeadConns counts pooled connections that failed the health check.
+	DeadConns uint64
+	// Evictions counts idle connections closed for age or over the limits.
+	Evictions uint64
+	// Open is the number of connections, busy or idle.
+	Open int
+	// Idle is the number of connections waiting in the pool.
+	Idle int
+}
+
+// persistConn is a connection the pool knows about.
+type persistConn struct {
+	net.Conn
+	key    string
+	reused bool
+	timer  *time.Timer
+}
+
+// probeTimeout is how long alive waits for a sign of life. A deadline in the
+// past would fail the read before the socket is even looked at.
+const probeTimeout = time.Millisecond
+
+// alive checks an idle connection before it's reused. Nothing should
+// arrive between responses, so a read that doesn't time out right away
+// means the peer closed the connection or sent garbage. TLS connections
+// are probed above the TLS layer: records the server may send at any time,
+// like TLS 1.3 session tickets, are handled there and aren't data.
+func (pc *persistConn) alive() bool {
+	pc.SetReadDeadline(time.Now().Add(probeTimeout))
+	var probe [1]byte
+	n, err := pc.Read(probe[:])
+	pc.SetReadDeadline(time.Time{})
+	var netErr net.Error
+	return n == 0 && errors.As(err, &netErr) && netErr.Timeout()
+}
+
+type hostConns struct {
+	// idle connections, the most recently used last
+	idle []*persistConn
+	// open counts busy and idle connections, and dials in progress
+	open int
+	// wait is closed and replaced whenever a connection frees up
+	wait chan struct{}
+}
+
+type connPool struct {
+	client *Client
+
+	mu    sync.Mutex
+	hosts map[string]*hostConns
+	idle  int
+
+	dials     atomic.Uint64
+	reuses    atomic.Uint64
+	retries   atomic.Uint64
+	deadConns atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func newConnPool(c *Client) *connPool {
+	return &connPool{client: c, hosts: map[string]*hostConns{}}
+}
+
+func (p *connPool) host(key string) *hostConns {
+	h, ok := p.hosts[key]
+	if !ok {
+		h = &hostConns{wait: make(chan struct{})}
+		p.hosts[key] = h
+	}
+	return h
+}
+
+// signal wakes up the requests waiting for a connection to key.
+func (h *hostConns) signal() {
+	close(h.wait)
+	h.wait = make(chan struct{})
+}
+
+// get returns a healthy idle connection to key, or nil after making room
+// for a new one, waiting for MaxConnsPerHost if needed. fresh skips the idle
+// connections.
+func (p *connPool) get(key string, deadline time.Time, fresh bool) (*persistConn, error) {
+	for {
+		p.mu.Lock()
+		h := p.host(key)
+		for !fresh && len(h.idle) > 0 {
+			pc := h.idle[len(h.idle)-1]
+			h.idle = h.idle[:len(h.idle)-1]
+			p.idle--
+			pc.timer.Stop()
+			p.mu.Unlock()
+			if pc.alive() {
+				pc.reused = true
+				p.reuses.Add(1)
+				return pc, nil
+			}
+			p.deadConns.Add(1)
+			p.discard(pc)
+			p.mu.Lock()
+			h = p.host(key)
+		}
+		if p.client.MaxConnsPerHost <= 0 || h.open < p.client.MaxConnsPerHost {
+			h.open++
+			p.mu.Unlock()
+			return nil, nil
+		}
+		wait := h.wait
+		p.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-wait
+			continue
+		}
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-wait:
+			timer.Stop()
+		case <-timer.C:
+			return nil, errors.New("timed out waiting for a connection")
+		}
+	}
+}
+
+// release gives back the room taken by get for a connection that is gone.
+func (p *connPool) release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := p.host(key)
+	h.open--
+	h.signal()
+	if h.open == 0 {
+		delete(p.hosts, key)
+	}
+}
+
+func (p *connPool) discard(pc *persistConn) {
+	pc.Close()
+	p.release(pc.key)
+}
+
+// put parks a connection after its response was read to the end.
+func (p *connPool) put(pc *persistConn) {
+	pc.SetDeadline(time.Time{})
+	maxIdle := p.client.MaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = defaultMaxIdleConns
+	}
+	maxIdlePerHost := p.client.MaxIdleConnsPerHost
+	if maxIdlePerHost <= 0 {
+		maxIdlePerHost = defaultMaxIdleConnsPerHost
+	}
+	idleTimeout := p.client.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	p.mu.Lock()
+	h := p.host(pc.key)
+	if p.idle >= maxIdle || len(h.idle) >= maxIdlePerHost {
+		p.mu.Unlock()
+		p.evictions.Add(1)
+		p.discard(pc)
+		return
+	}
+	h.idle = append(h.idle, pc)
+	p.idle++
+	pc.timer = time.AfterFunc(idleTimeout, func() { p.evict(pc) })
+	h.signal()
+	p.mu.Unlock()
+}
+
+// evict closes pc if it is still idle.
+func (p *connPool) evict(pc *persistConn) {
+	p.mu.Lock()
+	h, ok := p.hosts[pc.key]
+	if !ok || !h.remove(pc) {
+		p.mu.Unlock()
+		return
+	}
+	p.idle--
+	p.mu.Unlock()
+	p.evictions.Add(1)
+	p.discard(pc)
+}
+
+func (h *hostConns) remove(pc *persistConn) bool {
+	for i, idle := range h.idle {
+		if idle == pc {
+			h.idle = append(h.idle[:i], h.idle[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *connPool) closeIdle() {
+	p.mu.Lock()
+	var idle []*persistConn
+	for _, h := range p.hosts {
+		idle = append(idle, h.idle...)
+		h.idle = nil
+	}
+	p.idle = 0
+	p.mu.Unlock()
+	for _, pc := range idle {
+		pc.timer.Stop()
+		p.discard(pc)
+	}
+}
+
+func (p *connPool) stats() PoolStats {
+	p.mu.Lock()
+	open := 0
+	for _, h := range p.hosts {
+		open += h.open
+	}
+	idle := p.idle
+	p.mu.Unlock()
+	return PoolStats{
+		Dials:     p.dials.Load(),
+		Reuses:    p.reuses.Load(),
+		Retries:   p.retries.Load(),
+		DeadConns: p.deadConns.Load(),
+		Evictions: p.evictions.Load(),
+		Open:      open,
+		Idle:      idle,
+	}
+}
+
+func newPersistConn(conn net.Conn, key string) *persistConn {
+	return &persistConn{Conn: conn, key: key}
+}
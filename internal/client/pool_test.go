@@ -0,0 +1,299 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingServer is an httptest server that counts the connections it
+// accepted.
+func countingServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	conns := &atomic.Int32{}
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, conns
+}
+
+func readAll(t *testing.T, resp *Response) string {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return string(body)
+}
+
+func TestPoolReuse(t *testing.T) {
+	srv, conns := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	})
+
+	// Test: Sequential requests share one connection
+	c := &Client{}
+	for i := 0; i < 5; i++ {
+		resp, err := c.Get(fmt.Sprintf("%s/%d", srv.URL, i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("/%d", i), readAll(t, resp))
+	}
+	assert.Equal(t, int32(1), conns.Load())
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.Dials)
+	assert.Equal(t, uint64(4), stats.Reuses)
+	assert.Equal(t, 1, stats.Idle)
+	assert.Equal(t, 1, stats.Open)
+
+	c.CloseIdleConnections()
+	stats = c.Stats()
+	assert.Equal(t, 0, stats.Idle)
+	assert.Equal(t, 0, stats.Open)
+
+	// Test: Keep-alive disabled
+	conns.Store(0)
+	c = &Client{DisableKeepAlives: true}
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(srv.URL)
+		require.NoError(t, err)
+		readAll(t, resp)
+	}
+	assert.Equal(t, int32(3), conns.Load())
+	assert.Equal(t, 0, c.Stats().Idle)
+
+	// Test: A body closed before the end doesn't go back to the pool
+	conns.Store(0)
+	c = &Client{}
+	resp, err := c.Get(srv.URL + "/unread")
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, err = c.Get(srv.URL)
+	require.NoError(t, err)
+	readAll(t, resp)
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestPoolLimits(t *testing.T) {
+	srv, conns := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+
+	// Test: Idle connections over the per host limit are closed
+	c := &Client{MaxIdleConnsPerHost: 1}
+	first, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	second, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	readAll(t, first)
+	readAll(t, second)
+	stats := c.Stats()
+	assert.Equal(t, 1, stats.Idle)
+	assert.Equal(t, uint64(1), stats.Evictions)
+
+	// Test: Idle connections are evicted after IdleTimeout
+	c = &Client{IdleTimeout: 20 * time.Millisecond}
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	readAll(t, resp)
+	assert.Equal(t, 1, c.Stats().Idle)
+	assert.Eventually(t, func() bool { return c.Stats().Idle == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, uint64(1), c.Stats().Evictions)
+
+	// Test: MaxConnsPerHost makes requests wait for a free connection
+	conns.Store(0)
+	c = &Client{MaxConnsPerHost: 1, Timeout: 100 * time.Millisecond}
+	held, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	_, err = c.Get(srv.URL)
+	require.Error(t, err)
+
+	done := make(chan string)
+	go func() {
+		resp, err := (&Client{}).Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		c.Timeout = time.Second
+		resp, err = c.Get(srv.URL)
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		done <- string(body)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	readAll(t, held)
+	assert.Equal(t, "ok", <-done)
+	// the other client dialed once, c only once
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+// closingServer answers the first request on every connection with a
+// keep-alive response, then reads the next request and hangs up without an
+// answer, like a server that timed the connection out as it was reused.
+func closingServer(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	conns := &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				conn.Read(buf)
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				conn.Read(buf)
+			}()
+		}
+	}()
+	return "http://" + listener.Addr().String(), conns
+}
+
+func TestPoolRetry(t *testing.T) {
+	url, conns := closingServer(t)
+
+	// Test: An idempotent request is retried on a new connection
+	c := &Client{}
+	resp, err := c.Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	resp, err = c.Get(url)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	assert.Equal(t, int32(2), conns.Load())
+	assert.Equal(t, uint64(1), c.Stats().Retries)
+
+	// Test: A POST is not sent twice
+	req, err := NewRequest("POST", url, []byte("once"))
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.Error(t, err)
+	assert.Equal(t, int32(2), conns.Load())
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	// the server closes the connection right after the response, even
+	// though it didn't say so
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4096)
+			conn.Read(buf)
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+			conn.Close()
+		}
+	}()
+	url := "http://" + listener.Addr().String()
+
+	c := &Client{}
+	resp, err := c.Get(url)
+	require.NoError(t, err)
+	readAll(t, resp)
+	// give the FIN time to arrive
+	time.Sleep(20 * time.Millisecond)
+	req, err := NewRequest("POST", url, []byte("x"))
+	require.NoError(t, err)
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	stats := c.Stats()
+	assert.Equal(t, uint64(1), stats.DeadConns)
+	assert.Equal(t, uint64(2), stats.Dials)
+	assert.Equal(t, uint64(0), stats.Retries)
+}
+
+func TestPoolTLS(t *testing.T) {
+	conns := &atomic.Int32{}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/close" {
+			// closed after the response without saying so
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+			conn.Close()
+			return
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	// Test: Idle TLS connections pass the health check and are reused
+	c := &Client{TLSConfig: &tls.Config{RootCAs: roots}}
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(fmt.Sprintf("%s/%d", srv.URL, i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("/%d", i), readAll(t, resp))
+		// long enough for the probe to see anything the server sent
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, int32(1), conns.Load())
+	stats := c.Stats()
+	assert.Equal(t, uint64(2), stats.Reuses)
+	assert.Equal(t, uint64(0), stats.DeadConns)
+
+	// Test: A TLS connection the server closed is still caught
+	resp, err := c.Get(srv.URL + "/close")
+	require.NoError(t, err)
+	assert.Equal(t, "ok", readAll(t, resp))
+	time.Sleep(20 * time.Millisecond)
+	resp, err = c.Get(srv.URL + "/after")
+	require.NoError(t, err)
+	assert.Equal(t, "/after", readAll(t, resp))
+	assert.Equal(t, uint64(1), c.Stats().DeadConns)
+}
+
+func TestPoolConnectionClose(t *testing.T) {
+	srv, conns := countingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/close") {
+			w.Header().Set("Connection", "close")
+		}
+		fmt.Fprint(w, "ok")
+	})
+
+	c := &Client{}
+	resp, err := c.Get(srv.URL + "/close")
+	require.NoError(t, err)
+	readAll(t, resp)
+	assert.Equal(t, 0, c.Stats().Idle)
+	resp, err = c.Get(srv.URL)
+	require.NoError(t, err)
+	readAll(t, resp)
+	assert.Equal(t, int32(2), conns.Load())
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"MODULE_NAME/internal/response"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const defaultMaxRedirects = 10
+
+// drainLimit is how much of a redirect body is read so the connection can
+// go back to the pool, anything bigger is cheaper to close.
+const drainLimit = 64 << 10
+
+// Do sends req and follows redirects, see send for how the response body
+// and connection are handled. Cookies from every hop go to Jar, and the
+// matching ones are added to each request. req itself is not modified.
+func (c *Client) Do(req *Request) (*Response, error) {
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	req = req.clone()
+	// the caller's own cookies go along to the origin they chose
+	userCookies, _ := req.Headers.Get("Cookie")
+
+	for hops := 0; ; hops++ {
+		if c.Jar != nil {
+			c.addCookies(req, userCookies)
+		}
+		resp, err := c.send(req)
+		if err != nil {
+			return nil, err
+		}
+		if c.Jar != nil {
+			c.storeCookies(req.URL, resp)
+		}
+
+		if maxRedirects < 0 || !isRedirect(resp.StatusCode) {
+			return resp, nil
+		}
+		location, err := resp.Headers.Get("Location")
+		if err != nil {
+			return resp, nil
+		}
+		if hops == maxRedirects {
+			resp.Body.Close()
+			return nil, fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		next, err := redirectRequest(req, resp.StatusCode, location)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if !sameOrigin(req.URL, next.URL) {
+			userCookies = ""
+		}
+		io.CopyN(io.Discard, resp.Body, drainLimit)
+		resp.Body.Close()
+		req = next
+	}
+}
+
+func isRedirect(code response.StatusCode) bool {
+	switch code {
+	case response.StatusMovedPermanently, response.StatusFound, response.StatusSeeOther,
+		response.StatusTemporaryRedirect, response.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectRequest builds the request for the next hop (RFC 9110 15.4).
+// 303 turns anything but HEAD into a body-less GET, 301 and 302 do that for
+// POST like every browser does, 307 and 308 resend the request as it was.
+func redirectRequest(req *Request, code response.StatusCode, location string) (*Request, error) {
+	ref, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Location %q: %w", location, err)
+	}
+	target := req.URL.ResolveReference(ref)
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("redirect to unsupported scheme: %q", target.Scheme)
+	}
+	if target.Host == "" {
+		return nil, fmt.Errorf("redirect to %q has no host", location)
+	}
+
+	next := req.clone()
+	next.URL = target
+	// Host follows the URL from now on
+	next.Headers.Delete("Host")
+
+	rewrite := (code == response.StatusSeeOther && req.Method != "HEAD") ||
+		((code == response.StatusMovedPermanently || code == response.StatusFound) && req.Method == "POST")
+	if rewrite {
+		next.Method = "GET"
+		next.Body = nil
+		for key := range next.Headers {
+			if strings.HasPrefix(key, "content-") {
+				delete(next.Headers, key)
+			}
+		}
+	}
+
+	if !sameOrigin(req.URL, target) {
+		for _, key := range []string{"Authorization", "Proxy-Authorization", "Cookie"} {
+			next.Headers.Delete(key)
+		}
+	}
+	return next, nil
+}
+
+func sameOrigin(a *url.URL, b *url.URL) bool {
+	return a.Scheme == b.Scheme && strings.EqualFold(hostPort(a), hostPort(b))
+}
+
+func (req *Request) clone() *Request {
+	h := req.Headers.Clone()
+	u := *req.URL
+	return &Request{Method: req.Method, URL: &u, Headers: h, Body: req.Body, Trace: req.Trace}
+}
+
+func (c *Client) addCookies(req *Request, userCookies string) {
+	value := cookieHeader(c.Jar.Cookies(req.URL))
+	if userCookies != "" {
+		value = strings.TrimSuffix(userCookies+"; "+value, "; ")
+	}
+	if value == "" {
+		req.Headers.Delete("Cookie")
+		return
+	}
+	req.Headers.SetOVR("Cookie", value)
+}
+
+func (c *Client) storeCookies(u *url.URL, resp *Response) {
+	var cookies []*Cookie
+	for _, line := range resp.Headers.FieldLines("Set-Cookie") {
+		cookie, err := ParseSetCookie(line)
+		if err != nil {
+			continue
+		}
+		cookies = append(cookies, cookie)
+	}
+	if len(cookies) > 0 {
+		c.Jar.SetCookies(u, cookies)
+	}
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"MODULE_NAME/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seenRequest is what the redirect test server received on /final.
+type seenRequest struct {
+	method      string
+	body        string
+	contentType string
+	auth        string
+	cookie      string
+}
+
+func TestRedirects(t *testing.T) {
+	var seen seenRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = seenRequest{r.Method, string(body), r.Header.Get("Content-Type"), r.Header.Get("Authorization"), r.Header.Get("Cookie")}
+		fmt.Fprint(w, "final")
+	})
+	mux.HandleFunc("/code/", func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/code/"))
+		w.Header().Set("Location", "../final")
+		w.WriteHeader(code)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		code   int
+		method string
+		want   string
+		body   string
+	}{
+		{301, "POST", "GET", ""},
+		{302, "POST", "GET", ""},
+		{303, "POST", "GET", ""},
+		{303, "PUT", "GET", ""},
+		{303, "HEAD", "HEAD", "payload"},
+		{301, "PUT", "PUT", "payload"},
+		{307, "POST", "POST", "payload"},
+		{308, "PUT", "PUT", "payload"},
+	} {
+		req, err := NewRequest(tc.method, fmt.Sprintf("%s/code/%d", srv.URL, tc.code), []byte("payload"))
+		require.NoError(t, err)
+		req.Headers.Set("Content-Type", "text/plain")
+		resp, err := (&Client{}).Do(req)
+		require.NoError(t, err)
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, response.StatusOK, resp.StatusCode)
+		assert.Equal(t, "/final", resp.URL.Path)
+		assert.Equal(t, tc.want, seen.method, "%d %s", tc.code, tc.method)
+		assert.Equal(t, tc.body, seen.body, "%d %s", tc.code, tc.method)
+		if tc.body == "" {
+			assert.Equal(t, "", seen.contentType)
+		}
+		// the caller's request is left alone
+		assert.Equal(t, tc.method, req.Method)
+	}
+
+	// Test: Too many hops
+	_, err := (&Client{MaxRedirects: 3}).Get(srv.URL + "/loop")
+	require.ErrorContains(t, err, "stopped after 3 redirects")
+
+	// Test: Redirects turned off
+	resp, err := (&Client{MaxRedirects: -1}).Get(srv.URL + "/code/302")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, response.StatusFound, resp.StatusCode)
+	location, _ := resp.Headers.Get("Location")
+	assert.Equal(t, "../final", location)
+}
+
+func TestRedirectCredentials(t *testing.T) {
+	var seen seenRequest
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = seenRequest{method: r.Method, auth: r.Header.Get("Authorization"), cookie: r.Header.Get("Cookie")}
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, other.URL+"/", http.StatusFound)
+		case "/here":
+			http.Redirect(w, r, "/done", http.StatusFound)
+		default:
+			seen = seenRequest{method: r.Method, auth: r.Header.Get("Authorization"), cookie: r.Header.Get("Cookie")}
+		}
+	}))
+	defer origin.Close()
+
+	c := &Client{}
+	req, err := NewRequest("GET", origin.URL+"/here", nil)
+	require.NoError(t, err)
+	req.Headers.Set("Authorization", "Bearer secret")
+	req.Headers.Set("Cookie", "session=1")
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "Bearer secret", seen.auth)
+	assert.Equal(t, "session=1", seen.cookie)
+
+	req.URL = mustParseURL(t, origin.URL+"/away")
+	resp, err = c.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "GET", seen.method)
+	assert.Equal(t, "", seen.auth)
+	assert.Equal(t, "", seen.cookie)
+}
+
+func TestClientCookies(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+			http.SetCookie(w, &http.Cookie{Name: "pref", Value: "dark", Path: "/app"})
+			http.Redirect(w, r, "/app/home", http.StatusSeeOther)
+		case "/logout":
+			http.SetCookie(w, &http.Cookie{Name: "session", Path: "/", MaxAge: -1})
+		default:
+			fmt.Fprint(w, r.Header.Get("Cookie"))
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{Jar: NewJar()}
+	// Test: Cookies set on a redirect are sent on the next hop
+	resp, err := c.Do(mustRequest(t, "POST", srv.URL+"/login"))
+	require.NoError(t, err)
+	assert.Equal(t, "pref=dark; session=abc", readAll(t, resp))
+
+	// Test: Path scoping
+	resp, err = c.Get(srv.URL + "/other")
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc", readAll(t, resp))
+
+	// Test: Deleted by the server
+	resp, err = c.Get(srv.URL + "/logout")
+	require.NoError(t, err)
+	readAll(t, resp)
+	resp, err = c.Get(srv.URL + "/app/home")
+	require.NoError(t, err)
+	assert.Equal(t, "pref=dark", readAll(t, resp))
+}
+
+func mustRequest(t *testing.T, method string, rawURL string) *Request {
+	t.Helper()
+	req, err := NewRequest(method, rawURL, nil)
+	require.NoError(t, err)
+	return req
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+)
+
+// Trace hooks into the steps of an exchange, for timing and debugging. Every
+// field is optional. With redirects the hooks run for each hop.
+type Trace struct {
+	// DNSStart and DNSDone wrap the host lookup, skipped for IP addresses
+	// and reused connections.
+	DNSStart func(host string)
+	DNSDone  func(addrs []string, err error)
+	// ConnectStart and ConnectDone wrap the TCP dial of one address.
+	ConnectStart func(address string)
+	ConnectDone  func(address string, err error)
+	// TLSHandshakeStart and TLSHandshakeDone wrap the handshake of https
+	// connections.
+	TLSHandshakeStart func()
+	TLSHandshakeDone  func(state tls.ConnectionState, err error)
+	// GotConn runs once a connection is ready, new or from the pool.
+	GotConn func(reused bool)
+	// WroteBytes gets the raw bytes of the request as they are written.
+	WroteBytes func(p []byte)
+	// WroteRequest runs once the whole request was written.
+	WroteRequest func(err error)
+	// GotFirstResponseByte runs when the first byte of the response arrives.
+	GotFirstResponseByte func()
+	// ReadBytes gets the raw bytes read from the connection, framing
+	// included.
+	ReadBytes func(p []byte)
+	// Chunk runs for every chunk header of a chunked response body.
+	Chunk func(size int64, extensions string)
+}
+
+func (t *Trace) dnsStart(host string) {
+	if t != nil && t.DNSStart != nil {
+		t.DNSStart(host)
+	}
+}
+
+func (t *Trace) dnsDone(addrs []string, err error) {
+	if t != nil && t.DNSDone != nil {
+		t.DNSDone(addrs, err)
+	}
+}
+
+func (t *Trace) connectStart(address string) {
+	if t != nil && t.ConnectStart != nil {
+		t.ConnectStart(address)
+	}
+}
+
+func (t *Trace) connectDone(address string, err error) {
+	if t != nil && t.ConnectDone != nil {
+		t.ConnectDone(address, err)
+	}
+}
+
+func (t *Trace) tlsHandshakeStart() {
+	if t != nil && t.TLSHandshakeStart != nil {
+		t.TLSHandshakeStart()
+	}
+}
+
+func (t *Trace) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	if t != nil && t.TLSHandshakeDone != nil {
+		t.TLSHandshakeDone(state, err)
+	}
+}
+
+func (t *Trace) gotConn(reused bool) {
+	if t != nil && t.GotConn != nil {
+		t.GotConn(reused)
+	}
+}
+
+func (t *Trace) wroteRequest(err error) {
+	if t != nil && t.WroteRequest != nil {
+		t.WroteRequest(err)
+	}
+}
+
+// tracedConn reports the bytes going over a connection to a Trace.
+type tracedConn struct {
+	net.Conn
+	trace     *Trace
+	firstByte sync.Once
+}
+
+func (c *tracedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 && c.trace.WroteBytes != nil {
+		c.trace.WroteBytes(p[:n])
+	}
+	return n, err
+}
+
+func (c *tracedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.firstByte.Do(func() {
+			if c.trace.GotFirstResponseByte != nil {
+				c.trace.GotFirstResponseByte()
+			}
+		})
+		if c.trace.ReadBytes != nil {
+			c.trace.ReadBytes(p[:n])
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "part%d", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	// go through DNS, the certificate is valid for example.com
+	c := &Client{TLSConfig: &tls.Config{RootCAs: roots, ServerName: "example.com"}}
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	var events []string
+	var wrote, read strings.Builder
+	var chunks []int64
+	trace := &Trace{
+		DNSStart:     func(host string) { events = append(events, "dns "+host) },
+		DNSDone:      func(addrs []string, err error) { events = append(events, "dns done") },
+		ConnectStart: func(address string) { events = append(events, "connect") },
+		ConnectDone: func(address string, err error) {
+			if err == nil {
+				events = append(events, "connected")
+			}
+		},
+		TLSHandshakeStart: func() { events = append(events, "tls") },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			require.NoError(t, err)
+			events = append(events, "tls done")
+		},
+		GotConn:              func(reused bool) { events = append(events, fmt.Sprintf("conn reused=%v", reused)) },
+		WroteBytes:           func(p []byte) { wrote.Write(p) },
+		WroteRequest:         func(err error) { events = append(events, "wrote") },
+		GotFirstResponseByte: func() { events = append(events, "first byte") },
+		ReadBytes:            func(p []byte) { read.Write(p) },
+		Chunk:                func(size int64, extensions string) { chunks = append(chunks, size) },
+	}
+
+	for _, reused := range []bool{false, true} {
+		events = nil
+		wrote.Reset()
+		read.Reset()
+		chunks = nil
+		req, err := NewRequest("GET", url+"/traced", nil)
+		require.NoError(t, err)
+		req.Trace = trace
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		body := readAll(t, resp)
+		assert.Equal(t, "part0part1part2", body)
+
+		if !reused {
+			// localhost may resolve to an address nothing listens on first
+			assert.Equal(t, []string{"dns localhost", "dns done", "connect"}, events[:3])
+			assert.Equal(t, []string{"connected", "tls", "tls done", "conn reused=false", "wrote", "first byte"}, events[len(events)-6:])
+		} else {
+			assert.Equal(t, []string{"conn reused=true", "wrote", "first byte"}, events)
+		}
+		assert.True(t, strings.HasPrefix(wrote.String(), "GET /traced HTTP/1.1\r\n"))
+		assert.True(t, strings.HasPrefix(read.String(), "HTTP/1.1 200 OK\r\n"))
+		assert.Contains(t, read.String(), "5\r\npart0\r\n")
+		assert.Equal(t, []int64{5, 5, 5, 0}, chunks)
+	}
+}
@@ -0,0 +1,817 @@
+package h2c
+
+import (
+	"MODULE_NAME/internal/response"
+	"MODULE_NAME/internal/server"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// errStreamReset stops the response of a stream the client reset
+	errStreamReset = errors.New("h2c: stream reset")
+	// errConnClosed stops the responses of a connection that ended
+	errConnClosed = errors.New("h2c: connection closed")
+)
+
+// goAwayLinger is how long a connection ended by an error keeps reading
+// after the GOAWAY.
+const goAwayLinger = time.Second
+
+// serverConn is the server end of an HTTP/2 connection. One goroutine
+// reads the frames, each request runs its handler in a goroutine of its
+// own, which writes the response.
+type serverConn struct {
+	srv  *Server
+	conn net.Conn
+	r    *bufio.Reader
+
+	// the reader goroutine's
+	dec          *decoder
+	lastStreamID uint32
+	// continuing is a header block waiting for its CONTINUATION frames
+	continuing   *headerBlock
+	recvWindow   int64
+	settingsSeen bool
+
+	// mu guards the streams and what the writers wait on, cond is
+	// signalled when either changes
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	closed            bool
+
+	// wmu serializes the frames, and the encoder with them: header
+	// blocks go out in the order they were encoded
+	wmu sync.Mutex
+	bw  *bufio.Writer
+	enc *encoder
+
+	handlers sync.WaitGroup
+}
+
+// headerBlock is a HEADERS frame and its CONTINUATION frames.
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	block     []byte
+	// err is a stream error found in the HEADERS frame, returned once the
+	// block is decoded
+	err error
+}
+
+func newServerConn(s *Server, conn net.Conn, r *bufio.Reader) *serverConn {
+	sc := &serverConn{
+		srv:               s,
+		conn:              conn,
+		r:                 r,
+		dec:               newDecoder(),
+		recvWindow:        defaultWindowSize,
+		streams:           map[uint32]*stream{},
+		sendWindow:        defaultWindowSize,
+		peerInitialWindow: defaultWindowSize,
+		peerMaxFrameSize:  minMaxFrameSize,
+		bw:                bufio.NewWriter(conn),
+		enc:               newEncoder(),
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	return sc
+}
+
+// serve runs the connection until it ends. up is the request of an
+// upgrade to h2c, served as stream 1, nil for prior knowledge.
+func (sc *serverConn) serve(up *upgrade) {
+	defer sc.close()
+	opts := sc.srv.options
+	// our SETTINGS come first, without waiting for the client's preface
+	// (RFC 9113 3.4)
+	settings := appendSettings(nil,
+		setting{settingMaxConcurrentStreams, opts.MaxConcurrentStreams},
+		setting{settingInitialWindowSize, opts.InitialWindowSize},
+		setting{settingMaxFrameSize, opts.MaxFrameSize},
+		setting{settingMaxHeaderListSize, opts.MaxHeaderListSize},
+	)
+	if err := sc.writeFrame(frameSettings, 0, 0, settings); err != nil {
+		return
+	}
+	// the connection window starts at 65535 whatever the settings, it
+	// grows to what the buffered bodies may take up
+	if err := sc.giveBack(opts.MaxConnBodySize - defaultWindowSize); err != nil {
+		return
+	}
+	if up != nil {
+		// HTTP2-Settings count as the client's first SETTINGS, which isn't
+		// acknowledged (RFC 7540 3.2.1)
+		if err := sc.applySettings(up.settings); err != nil {
+			sc.goAway(err)
+			return
+		}
+		st := sc.newStream(1)
+		st.remoteClosed = true
+		sc.lastStreamID = 1
+		up.req.RemoteAddr = sc.conn.RemoteAddr().String()
+		sc.start(st, up.req.RequestLine.Method, true, func(w *response.Writer) {
+			sc.srv.handler(w, up.req)
+		})
+	}
+
+	preface := make([]byte, len(clientPreface))
+	if _, err := io.ReadFull(sc.r, preface); err != nil {
+		return
+	}
+	if string(preface) != clientPreface {
+		sc.goAway(connError(ErrCodeProtocol, "invalid connection preface"))
+		return
+	}
+	for {
+		f, err := readFrame(sc.r, opts.MaxFrameSize)
+		if err == nil {
+			err = sc.processFrame(f)
+		}
+		var se *StreamError
+		switch {
+		case err == nil:
+		case errors.As(err, &se):
+			sc.resetStream(se)
+		case errors.Is(err, io.EOF):
+			return
+		default:
+			sc.goAway(err)
+			return
+		}
+	}
+}
+
+// close ends the responses in flight and waits for their handlers.
+func (sc *serverConn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	sc.conn.Close()
+	sc.handlers.Wait()
+}
+
+// goAway tells the client why the connection ends, if it's a protocol
+// error.
+func (sc *serverConn) goAway(err error) {
+	var ce *ConnError
+	if !errors.As(err, &ce) {
+		return
+	}
+	payload := binary.BigEndian.AppendUint32(nil, sc.lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(ce.Code))
+	payload = append(payload, ce.Reason...)
+	if err := sc.writeFrame(frameGoAway, 0, 0, payload); err != nil {
+		return
+	}
+	// closing with unread bytes resets the connection, the client could
+	// lose the GOAWAY: stop sending and drain what it still sends a while
+	if cw, ok := sc.conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	sc.conn.SetReadDeadline(time.Now().Add(goAwayLinger))
+	io.Copy(io.Discard, sc.r)
+}
+
+// resetStream ends a stream with a RST_STREAM.
+func (sc *serverConn) resetStream(se *StreamError) {
+	sc.mu.Lock()
+	st, ok := sc.streams[se.StreamID]
+	if ok {
+		st.reset = true
+		delete(sc.streams, se.StreamID)
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+	sc.writeReset(se.StreamID, se.Code)
+	if ok {
+		sc.release(st)
+	}
+}
+
+func (sc *serverConn) writeReset(id uint32, code ErrCode) error {
+	return sc.writeFrame(frameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (sc *serverConn) writeWindowUpdate(id uint32, n int) error {
+	return sc.writeFrame(frameWindowUpdate, 0, id, binary.BigEndian.AppendUint32(nil, uint32(n)))
+}
+
+func (sc *serverConn) writeFrame(typ uint8, flags uint8, id uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	if err := writeFrame(sc.bw, typ, flags, id, payload); err != nil {
+		return err
+	}
+	return sc.bw.Flush()
+}
+
+func (sc *serverConn) stream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.streams[id]
+}
+
+// idle reports whether the client hasn't opened stream id yet. Streams
+// below the last one it opened and not in streams are closed.
+func (sc *serverConn) idle(id uint32) bool {
+	return id > sc.lastStreamID
+}
+
+func (sc *serverConn) processFrame(f frame) error {
+	if !sc.settingsSeen {
+		if f.typ != frameSettings || f.has(flagAck) {
+			return connError(ErrCodeProtocol, "the preface doesn't end with SETTINGS")
+		}
+		sc.settingsSeen = true
+	}
+	if sc.continuing != nil && (f.typ != frameContinuation || f.streamID != sc.continuing.streamID) {
+		return connError(ErrCodeProtocol, "header block of stream %d interrupted", sc.continuing.streamID)
+	}
+	switch f.typ {
+	case frameData:
+		return sc.processData(f)
+	case frameHeaders:
+		return sc.processHeaders(f)
+	case frameContinuation:
+		return sc.processContinuation(f)
+	case framePriority:
+		// priorities are only advice, and deprecated (RFC 9113 5.3.2)
+		if f.streamID == 0 {
+			return connError(ErrCodeProtocol, "PRIORITY on stream 0")
+		}
+		if len(f.payload) != 5 {
+			return streamError(f.streamID, ErrCodeFrameSize, "PRIORITY of %d bytes", len(f.payload))
+		}
+		if binary.BigEndian.Uint32(f.payload)&(1<<31-1) == f.streamID {
+			return streamError(f.streamID, ErrCodeProtocol, "stream depends on itself")
+		}
+		return nil
+	case frameRSTStream:
+		if len(f.payload) != 4 {
+			return connError(ErrCodeFrameSize, "RST_STREAM of %d bytes", len(f.payload))
+		}
+		if f.streamID == 0 || sc.idle(f.streamID) {
+			return connError(ErrCodeProtocol, "RST_STREAM on idle stream %d", f.streamID)
+		}
+		sc.mu.Lock()
+		st, ok := sc.streams[f.streamID]
+		if ok {
+			st.reset = true
+			delete(sc.streams, f.streamID)
+			sc.cond.Broadcast()
+		}
+		sc.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		return sc.release(st)
+	case frameSettings:
+		return sc.processSettings(f)
+	case framePushPromise:
+		return connError(ErrCodeProtocol, "PUSH_PROMISE from a client")
+	case framePing:
+		if f.streamID != 0 {
+			return connError(ErrCodeProtocol, "PING on stream %d", f.streamID)
+		}
+		if len(f.payload) != 8 {
+			return connError(ErrCodeFrameSize, "PING of %d bytes", len(f.payload))
+		}
+		if f.has(flagAck) {
+			return nil
+		}
+		return sc.writeFrame(framePing, flagAck, 0, f.payload)
+	case frameGoAway:
+		// the client opens no more streams, those in flight still get
+		// their answer
+		if f.streamID != 0 {
+			return connError(ErrCodeProtocol, "GOAWAY on stream %d", f.streamID)
+		}
+		return nil
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		// unknown types are ignored (RFC 9113 5.5)
+		return nil
+	}
+}
+
+func (sc *serverConn) processSettings(f frame) error {
+	if f.streamID != 0 {
+		return connError(ErrCodeProtocol, "SETTINGS on stream %d", f.streamID)
+	}
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connError(ErrCodeFrameSize, "SETTINGS ack with a payload")
+		}
+		return nil
+	}
+	settings, err := parseSettings(f.payload)
+	if err != nil {
+		return err
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+// applySettings applies the client's settings (RFC 9113 6.5.2). Those
+// about streams the server opens don't matter, it opens none.
+func (sc *serverConn) applySettings(settings []setting) error {
+	for _, s := range settings {
+		switch s.id {
+		case settingHeaderTableSize:
+			sc.wmu.Lock()
+			sc.enc.setMaxTableSize(s.value)
+			sc.wmu.Unlock()
+		case settingEnablePush:
+			if s.value > 1 {
+				return connError(ErrCodeProtocol, "SETTINGS_ENABLE_PUSH of %d", s.value)
+			}
+		case settingInitialWindowSize:
+			if s.value > maxWindowSize {
+				return connError(ErrCodeFlowControl, "SETTINGS_INITIAL_WINDOW_SIZE of %d", s.value)
+			}
+			// the change applies to the streams in flight too (RFC 9113
+			// 6.9.2)
+			sc.mu.Lock()
+			delta := int64(s.value) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(s.value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					sc.mu.Unlock()
+					return connError(ErrCodeFlowControl, "window of stream %d over 2^31-1", st.id)
+				}
+			}
+			sc.cond.Broadcast()
+			sc.mu.Unlock()
+		case settingMaxFrameSize:
+			if s.value < minMaxFrameSize || s.value > maxMaxFrameSize {
+				return connError(ErrCodeProtocol, "SETTINGS_MAX_FRAME_SIZE of %d", s.value)
+			}
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = s.value
+			sc.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(f frame) error {
+	if len(f.payload) != 4 {
+		return connError(ErrCodeFrameSize, "WINDOW_UPDATE of %d bytes", len(f.payload))
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & (1<<31 - 1))
+	if f.streamID == 0 {
+		if increment == 0 {
+			return connError(ErrCodeProtocol, "WINDOW_UPDATE of 0")
+		}
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connError(ErrCodeFlowControl, "connection window over 2^31-1")
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+	if sc.idle(f.streamID) {
+		return connError(ErrCodeProtocol, "WINDOW_UPDATE on idle stream %d", f.streamID)
+	}
+	if increment == 0 {
+		return streamError(f.streamID, ErrCodeProtocol, "WINDOW_UPDATE of 0")
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st, ok := sc.streams[f.streamID]
+	if !ok {
+		// a stream that just closed
+		return nil
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return streamError(f.streamID, ErrCodeFlowControl, "window over 2^31-1")
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processData(f frame) error {
+	if f.streamID == 0 {
+		return connError(ErrCodeProtocol, "DATA on stream 0")
+	}
+	// flow control counts the padding too, and DATA for streams that are
+	// gone
+	n := int64(len(f.payload))
+	sc.recvWindow -= n
+	if sc.recvWindow < 0 {
+		return connError(ErrCodeFlowControl, "connection window exceeded")
+	}
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+
+	st := sc.stream(f.streamID)
+	if st == nil && sc.idle(f.streamID) {
+		return connError(ErrCodeProtocol, "DATA on idle stream %d", f.streamID)
+	}
+	var se error
+	switch {
+	case st == nil:
+		se = streamError(f.streamID, ErrCodeStreamClosed, "DATA on closed stream")
+	case st.remoteClosed:
+		se = streamError(f.streamID, ErrCodeStreamClosed, "DATA after END_STREAM")
+	default:
+		st.recvWindow -= n
+		if st.recvWindow < 0 {
+			se = streamError(f.streamID, ErrCodeFlowControl, "stream window exceeded")
+		}
+	}
+	// the connection window is what the buffered bodies may take up, it
+	// goes back to the client right away for what isn't kept
+	kept := 0
+	if se == nil && !st.rejected {
+		kept = len(data)
+	}
+	if err := sc.giveBack(n - int64(kept)); err != nil {
+		return err
+	}
+	if se != nil {
+		return se
+	}
+
+	maxBodySize := sc.srv.options.MaxBodySize
+	if !st.rejected {
+		st.body = append(st.body, data...)
+		if int64(len(st.body)) > maxBodySize {
+			st.rejected = true
+			if err := sc.release(st); err != nil {
+				return err
+			}
+			sc.start(st, st.head.method, false, func(w *response.Writer) {
+				writeError(w, response.StatusContentTooLarge, nil)
+			})
+		}
+	}
+	if f.has(flagEndStream) {
+		st.remoteClosed = true
+		if !st.rejected {
+			return sc.dispatch(st)
+		}
+		return nil
+	}
+	// the stream gets window for one byte past MaxBodySize at most, that
+	// byte tells a body that's too large
+	if credit := min(n, maxBodySize+1-int64(len(st.body))-st.recvWindow); credit > 0 && !st.rejected {
+		if err := sc.writeWindowUpdate(f.streamID, int(credit)); err != nil {
+			return err
+		}
+		st.recvWindow += credit
+	}
+	return nil
+}
+
+// release drops the body st buffered and gives its window back to the
+// connection.
+func (sc *serverConn) release(st *stream) error {
+	n := len(st.body)
+	st.body = nil
+	return sc.giveBack(int64(n))
+}
+
+// giveBack returns n bytes of the connection window to the client.
+func (sc *serverConn) giveBack(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if err := sc.writeWindowUpdate(0, int(n)); err != nil {
+		return err
+	}
+	sc.recvWindow += n
+	return nil
+}
+
+func (sc *serverConn) processHeaders(f frame) error {
+	if f.streamID == 0 {
+		return connError(ErrCodeProtocol, "HEADERS on stream 0")
+	}
+	if f.streamID%2 == 0 {
+		return connError(ErrCodeProtocol, "HEADERS on server stream %d", f.streamID)
+	}
+	p, err := unpad(f)
+	if err != nil {
+		return err
+	}
+	b := &headerBlock{streamID: f.streamID, endStream: f.has(flagEndStream)}
+	if f.has(flagPriority) {
+		if len(p) < 5 {
+			return connError(ErrCodeFrameSize, "HEADERS too short for its priority")
+		}
+		if binary.BigEndian.Uint32(p)&(1<<31-1) == f.streamID {
+			b.err = streamError(f.streamID, ErrCodeProtocol, "stream depends on itself")
+		}
+		p = p[5:]
+	}
+	b.block = bytes.Clone(p)
+	if !f.has(flagEndHeaders) {
+		sc.continuing = b
+		return nil
+	}
+	return sc.processHeaderBlock(b)
+}
+
+func (sc *serverConn) processContinuation(f frame) error {
+	b := sc.continuing
+	if b == nil {
+		return connError(ErrCodeProtocol, "CONTINUATION without HEADERS")
+	}
+	b.block = append(b.block, f.payload...)
+	// the block is decoded whole, even past MaxHeaderListSize, to keep
+	// the dynamic table in sync; a client sending that much is abusive
+	if len(b.block) > 2*int(sc.srv.options.MaxHeaderListSize) {
+		return connError(ErrCodeEnhanceYourCalm, "header block of stream %d too large", b.streamID)
+	}
+	if !f.has(flagEndHeaders) {
+		return nil
+	}
+	sc.continuing = nil
+	return sc.processHeaderBlock(b)
+}
+
+// processHeaderBlock handles a complete header block: the request head of
+// a new stream, or the trailers of one in flight.
+func (sc *serverConn) processHeaderBlock(b *headerBlock) error {
+	opts := sc.srv.options
+	fields, tooLarge, err := sc.dec.decode(b.block, opts.MaxHeaderListSize)
+	if err != nil {
+		return connError(ErrCodeCompression, "%v", err)
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	if st := sc.stream(b.streamID); st != nil {
+		if st.remoteClosed {
+			return streamError(b.streamID, ErrCodeStreamClosed, "HEADERS after END_STREAM")
+		}
+		if !b.endStream {
+			return streamError(b.streamID, ErrCodeProtocol, "trailers without END_STREAM")
+		}
+		if err := checkTrailers(b.streamID, fields); err != nil {
+			return err
+		}
+		st.remoteClosed = true
+		if st.rejected {
+			return nil
+		}
+		return sc.dispatch(st)
+	}
+	if !sc.idle(b.streamID) {
+		return connError(ErrCodeStreamClosed, "HEADERS on closed stream %d", b.streamID)
+	}
+	sc.lastStreamID = b.streamID
+	sc.mu.Lock()
+	refused := uint32(len(sc.streams)) >= opts.MaxConcurrentStreams
+	sc.mu.Unlock()
+	if refused {
+		return streamError(b.streamID, ErrCodeRefusedStream, "over %d concurrent streams", opts.MaxConcurrentStreams)
+	}
+
+	st := sc.newStream(b.streamID)
+	st.remoteClosed = b.endStream
+	if tooLarge {
+		st.rejected = true
+		sc.start(st, "GET", b.endStream, func(w *response.Writer) {
+			writeError(w, response.StatusRequestHeaderFieldsTooLarge, nil)
+		})
+		return nil
+	}
+	st.head, err = parseRequestHead(b.streamID, fields)
+	if err != nil {
+		return err
+	}
+	if b.endStream {
+		return sc.dispatch(st)
+	}
+	// the client waits for it before sending the body, and the handler
+	// only runs once the body is in
+	for _, f := range st.head.fields {
+		if f.name == "expect" && strings.EqualFold(f.value, "100-continue") {
+			return sc.writeHeaders(st, []headerField{{name: ":status", value: "100"}}, false)
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) newStream(id uint32) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st := &stream{
+		id:         id,
+		recvWindow: int64(sc.srv.options.InitialWindowSize),
+		sendWindow: sc.peerInitialWindow,
+	}
+	sc.streams[id] = st
+	return st
+}
+
+// dispatch runs the handler for a stream whose request is complete.
+func (sc *serverConn) dispatch(st *stream) error {
+	req, err := newRequest(st.id, st.head, st.body, sc.srv.options.Server.Request)
+	// req has a copy of the body, the buffer's window goes back
+	if err := sc.release(st); err != nil {
+		return err
+	}
+	var se *StreamError
+	if errors.As(err, &se) {
+		return err
+	}
+	if err != nil {
+		sc.start(st, st.head.method, true, func(w *response.Writer) { requestError(w, err) })
+		return nil
+	}
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	sc.start(st, st.head.method, true, func(w *response.Writer) { sc.srv.handler(w, req) })
+	return nil
+}
+
+// start runs handle in a goroutine and sends what it writes as the
+// response of st. complete is false when the request isn't over: the
+// client is then told to stop sending it once the response is out.
+func (sc *serverConn) start(st *stream, method string, complete bool, handle func(w *response.Writer)) {
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		err := server.RelayResponse(method, handle, func(resp *response.Response) error {
+			return sc.relay(st, method, resp)
+		})
+		switch {
+		case err == nil && !complete:
+			// RFC 9113 8.1
+			sc.writeReset(st.id, ErrCodeNo)
+		case errors.Is(err, errStreamReset) || errors.Is(err, errConnClosed):
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			// the response is incomplete, the reset tells the client
+			sc.writeReset(st.id, ErrCodeInternal)
+		case err != nil:
+			log.Printf("h2c: stream %d: %v", st.id, err)
+			sc.writeReset(st.id, ErrCodeInternal)
+		}
+		sc.mu.Lock()
+		if sc.streams[st.id] == st {
+			delete(sc.streams, st.id)
+		}
+		sc.mu.Unlock()
+	}()
+}
+
+// relay sends the handler's response as frames: the head as HEADERS, the
+// body as DATA and the trailers as a last HEADERS.
+func (sc *serverConn) relay(st *stream, method string, resp *response.Response) error {
+	for _, interim := range resp.Interim {
+		// the body is in before the handler runs
+		if interim.StatusLine.StatusCode == response.StatusContinue {
+			continue
+		}
+		if err := sc.writeHeaders(st, responseFields(interim.StatusLine.StatusCode, interim.Headers), false); err != nil {
+			return err
+		}
+	}
+
+	code := resp.StatusLine.StatusCode
+	fields := responseFields(code, resp.Headers)
+	length, _ := resp.Headers.Get("Content-Length")
+	if method == "HEAD" || !response.BodyAllowed(method, code) || length == "0" {
+		return sc.writeHeaders(st, fields, true)
+	}
+	if err := sc.writeHeaders(st, fields, false); err != nil {
+		return err
+	}
+	body := resp.BodyReader()
+	buf := make([]byte, minMaxFrameSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if err := sc.writeData(st, buf[:n], false); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(resp.Trailers) > 0 {
+		return sc.writeHeaders(st, regularFields(resp.Trailers), true)
+	}
+	return sc.writeData(st, nil, true)
+}
+
+// writeHeaders sends a header block, split into CONTINUATION frames when
+// it doesn't fit one.
+func (sc *serverConn) writeHeaders(st *stream, fields []headerField, endStream bool) error {
+	sc.mu.Lock()
+	maxFrameSize := int(sc.peerMaxFrameSize)
+	err := sc.writable(st)
+	sc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	block := sc.enc.encode(nil, fields)
+	typ := uint8(frameHeaders)
+	for {
+		chunk := block[:min(len(block), maxFrameSize)]
+		block = block[len(chunk):]
+		var flags uint8
+		if typ == frameHeaders && endStream {
+			flags |= flagEndStream
+		}
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		if err := writeFrame(sc.bw, typ, flags, st.id, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			break
+		}
+		typ = frameContinuation
+	}
+	return sc.bw.Flush()
+}
+
+// writeData sends p as DATA frames as the flow control windows allow.
+func (sc *serverConn) writeData(st *stream, p []byte, endStream bool) error {
+	for len(p) > 0 || endStream {
+		n, err := sc.reserve(st, len(p))
+		if err != nil {
+			return err
+		}
+		var flags uint8
+		if endStream && n == len(p) {
+			flags = flagEndStream
+		}
+		if err := sc.writeFrame(frameData, flags, st.id, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+		if flags != 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// reserve waits until both windows have room and takes up to want bytes
+// off them, no more than a frame holds.
+func (sc *serverConn) reserve(st *stream, want int) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for {
+		if err := sc.writable(st); err != nil {
+			return 0, err
+		}
+		if want == 0 {
+			return 0, nil
+		}
+		n := min(int64(want), sc.sendWindow, st.sendWindow, int64(sc.peerMaxFrameSize))
+		if n > 0 {
+			sc.sendWindow -= n
+			st.sendWindow -= n
+			return int(n), nil
+		}
+		sc.cond.Wait()
+	}
+}
+
+// writable reports why nothing more can be sent on st, if so. sc.mu must
+// be held.
+func (sc *serverConn) writable(st *stream) error {
+	if sc.closed {
+		return errConnClosed
+	}
+	if st.reset {
+		return errStreamReset
+	}
+	return nil
+}
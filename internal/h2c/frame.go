@@ -0,0 +1,225 @@
+package h2c
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// frame types (RFC 9113 6)
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameRSTStream    = 0x3
+	frameSettings     = 0x4
+	framePushPromise  = 0x5
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+)
+
+// frame flags, their meaning depends on the type
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// settings (RFC 9113 6.5.2)
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+const (
+	frameHeaderLen = 9
+	// minMaxFrameSize is where SETTINGS_MAX_FRAME_SIZE starts and the least
+	// it can be, maxMaxFrameSize the most
+	minMaxFrameSize = 1 << 14
+	maxMaxFrameSize = 1<<24 - 1
+	// defaultWindowSize is every flow control window until changed,
+	// maxWindowSize the largest one can grow
+	defaultWindowSize = 65535
+	maxWindowSize     = 1<<31 - 1
+)
+
+// ErrCode is an HTTP/2 error code, sent in RST_STREAM and GOAWAY frames
+// (RFC 9113 7).
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(c))
+}
+
+// ConnError ends the whole connection, with a GOAWAY carrying Code.
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e *ConnError) Error() string {
+	return fmt.Sprintf("h2c: connection error %v: %s", e.Code, e.Reason)
+}
+
+// StreamError ends one stream, with a RST_STREAM carrying Code.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+	Reason   string
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("h2c: stream %d error %v: %s", e.StreamID, e.Code, e.Reason)
+}
+
+func connError(code ErrCode, format string, args ...any) error {
+	return &ConnError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+func streamError(id uint32, code ErrCode, format string, args ...any) error {
+	return &StreamError{StreamID: id, Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// frame is a frame as read, its payload padding included.
+type frame struct {
+	typ      uint8
+	flags    uint8
+	streamID uint32
+	payload  []byte
+}
+
+func (f frame) has(flag uint8) bool {
+	return f.flags&flag != 0
+}
+
+// readFrame reads the next frame. One longer than maxSize, our
+// SETTINGS_MAX_FRAME_SIZE, is refused before its payload is read.
+func readFrame(r io.Reader, maxSize uint32) (frame, error) {
+	var head [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return frame{}, err
+	}
+	length := uint32(head[0])<<16 | uint32(head[1])<<8 | uint32(head[2])
+	f := frame{
+		typ:   head[3],
+		flags: head[4],
+		// the reserved bit is ignored on receipt
+		streamID: binary.BigEndian.Uint32(head[5:]) & (1<<31 - 1),
+	}
+	if length > maxSize {
+		return f, connError(ErrCodeFrameSize, "frame of %d bytes over %d", length, maxSize)
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return f, err
+	}
+	return f, nil
+}
+
+// writeFrame writes a frame whose payload fits the peer's
+// SETTINGS_MAX_FRAME_SIZE.
+func writeFrame(w io.Writer, typ uint8, flags uint8, streamID uint32, payload []byte) error {
+	buf := make([]byte, frameHeaderLen, frameHeaderLen+len(payload))
+	buf[0] = byte(len(payload) >> 16)
+	buf[1] = byte(len(payload) >> 8)
+	buf[2] = byte(len(payload))
+	buf[3] = typ
+	buf[4] = flags
+	binary.BigEndian.PutUint32(buf[5:], streamID)
+	_, err := w.Write(append(buf, payload...))
+	return err
+}
+
+// unpad strips the padding of a DATA, HEADERS or PUSH_PROMISE frame
+// (RFC 9113 6.1).
+func unpad(f frame) ([]byte, error) {
+	p := f.payload
+	if !f.has(flagPadded) {
+		return p, nil
+	}
+	if len(p) == 0 {
+		return nil, connError(ErrCodeFrameSize, "padded frame without a pad length")
+	}
+	padding := int(p[0])
+	if padding >= len(p) {
+		return nil, connError(ErrCodeProtocol, "padding of %d bytes in a %d byte frame", padding, len(p))
+	}
+	return p[1 : len(p)-padding], nil
+}
+
+// setting is one parameter of a SETTINGS frame.
+type setting struct {
+	id    uint16
+	value uint32
+}
+
+func parseSettings(p []byte) ([]setting, error) {
+	if len(p)%6 != 0 {
+		return nil, connError(ErrCodeFrameSize, "SETTINGS of %d bytes", len(p))
+	}
+	settings := make([]setting, 0, len(p)/6)
+	for ; len(p) > 0; p = p[6:] {
+		settings = append(settings, setting{
+			id:    binary.BigEndian.Uint16(p),
+			value: binary.BigEndian.Uint32(p[2:]),
+		})
+	}
+	return settings, nil
+}
+
+func appendSettings(dst []byte, settings ...setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, s.id)
+		dst = binary.BigEndian.AppendUint32(dst, s.value)
+	}
+	return dst
+}
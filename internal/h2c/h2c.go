@@ -0,0 +1,252 @@
+// Package h2c serves HTTP/2 over cleartext TCP (RFC 9113) next to
+// HTTP/1.1: clients that know the server speaks it start with the HTTP/2
+// connection preface, others can ask for it with "Upgrade: h2c". Each
+// stream runs an ordinary server.Handler, what it writes is translated
+// into HEADERS and DATA frames.
+package h2c
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"MODULE_NAME/internal/server"
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// clientPreface starts every HTTP/2 connection (RFC 9113 3.4). It doesn't
+// parse as an HTTP/1.1 request, on purpose.
+const clientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Options configure the HTTP/2 side of the server.
+type Options struct {
+	// Server configures the HTTP/1.1 side, its request options apply to
+	// HTTP/2 requests too.
+	Server server.Options
+	// MaxConcurrentStreams caps the requests a client has in flight on
+	// one connection, 100 when 0.
+	MaxConcurrentStreams uint32
+	// InitialWindowSize is how much of each request body the client may
+	// send ahead, 65535 when 0 or less.
+	InitialWindowSize uint32
+	// MaxFrameSize is the largest frame payload accepted, 16384 when 0.
+	MaxFrameSize uint32
+	// MaxHeaderListSize caps the request headers, as counted by RFC 7541
+	// 4.1, 64KB when 0. Larger ones are answered with 431.
+	MaxHeaderListSize uint32
+	// MaxBodySize caps a request body, which is read whole before the
+	// handler runs, 10MB when 0. Larger ones are answered with 413.
+	MaxBodySize int64
+	// MaxConnBodySize caps the request bodies one connection buffers
+	// until their stream ends, the client is given window for no more.
+	// Twice MaxBodySize when 0, and never less than one body.
+	MaxConnBodySize int64
+}
+
+const (
+	defaultMaxConcurrentStreams = 100
+	defaultMaxHeaderListSize    = 64 << 10
+	defaultMaxBodySize          = 10 << 20
+)
+
+func (o *Options) setDefaults() {
+	if o.MaxConcurrentStreams == 0 {
+		o.MaxConcurrentStreams = defaultMaxConcurrentStreams
+	}
+	o.InitialWindowSize = min(max(o.InitialWindowSize, defaultWindowSize), maxWindowSize)
+	if o.MaxFrameSize == 0 {
+		o.MaxFrameSize = minMaxFrameSize
+	}
+	o.MaxFrameSize = min(max(o.MaxFrameSize, minMaxFrameSize), maxMaxFrameSize)
+	if o.MaxHeaderListSize == 0 {
+		o.MaxHeaderListSize = defaultMaxHeaderListSize
+	}
+	if o.MaxBodySize == 0 {
+		o.MaxBodySize = defaultMaxBodySize
+	}
+	if o.MaxConnBodySize == 0 {
+		o.MaxConnBodySize = 2 * o.MaxBodySize
+	}
+	// one byte past MaxBodySize tells a body that's too large
+	o.MaxConnBodySize = min(max(o.MaxConnBodySize, o.MaxBodySize+1, defaultWindowSize), maxWindowSize)
+}
+
+// Server serves HTTP/1.1 and HTTP/2 on the same port.
+type Server struct {
+	listener net.Listener
+	http1    *server.Server
+	handler  server.Handler
+	options  Options
+	closed   atomic.Bool
+}
+
+// Serve listens on port and serves handler over HTTP/1.1 and HTTP/2.
+func Serve(port int, handler server.Handler, opts Options) (*Server, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("error creating server: %w", err)
+	}
+	s := New(handler, opts)
+	s.listener = listener
+	go s.listen()
+	return s, nil
+}
+
+// New returns a server that doesn't listen, its connections come from
+// ServeConn.
+func New(handler server.Handler, opts Options) *Server {
+	opts.setDefaults()
+	s := &Server{
+		handler: handler,
+		options: opts,
+	}
+	s.http1 = server.New(s.serveHTTP1, opts.Server)
+	return s
+}
+
+func (s *Server) Close() {
+	s.closed.Store(true)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
+
+func (s *Server) listen() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.closed.Load() {
+				return
+			}
+			log.Printf("Error accepting connection: %v", err)
+			continue
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves conn with the protocol the client speaks and closes it,
+// unless a handler hijacked it. It returns once the handlers did.
+func (s *Server) ServeConn(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	if hasPreface(br) {
+		newServerConn(s, conn, br).serve(nil)
+		return
+	}
+	s.http1.ServeConn(&bufferedConn{Conn: conn, r: br})
+}
+
+// hasPreface reports whether the connection starts with the preface. It
+// stops reading at the first byte that doesn't match, an HTTP/1.1 request
+// can be shorter than the preface.
+func hasPreface(br *bufio.Reader) bool {
+	for i := 1; i <= len(clientPreface); i++ {
+		p, err := br.Peek(i)
+		if err != nil || !strings.HasPrefix(clientPreface, string(p)) {
+			return false
+		}
+	}
+	return true
+}
+
+// bufferedConn reads what hasPreface peeked at before the rest.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite half-closes the connection when it can, for handlers that
+// hijack it.
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// serveHTTP1 runs the handler for HTTP/1.1 requests, except those asking
+// for an upgrade to h2c (RFC 7540 3.2): they get a 101 and their answer
+// comes on stream 1 of the HTTP/2 connection that follows.
+func (s *Server) serveHTTP1(w *response.Writer, req *request.Request) {
+	settings, ok := upgradeSettings(req)
+	if !ok {
+		s.handler(w, req)
+		return
+	}
+	// with "Expect: 100-continue" the body is still on the connection
+	if _, err := req.ReadBody(); err != nil {
+		s.handler(w, req)
+		return
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		s.handler(w, req)
+		return
+	}
+	out := headers.NewHeaders()
+	out.Set("Connection", "Upgrade")
+	out.Set("Upgrade", "h2c")
+	hw := &response.Writer{ResWriter: conn}
+	if err := hw.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		conn.Close()
+		return
+	}
+	if err := hw.WriteHeaders(out); err != nil {
+		conn.Close()
+		return
+	}
+	for _, key := range []string{"Connection", "Upgrade", "HTTP2-Settings"} {
+		req.Headers.Delete(key)
+	}
+	r := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn))
+	newServerConn(s, conn, r).serve(&upgrade{req: req, settings: settings})
+}
+
+// upgrade is the HTTP/1.1 request a connection was upgraded with.
+type upgrade struct {
+	req      *request.Request
+	settings []setting
+}
+
+// upgradeSettings returns the settings of an upgrade to h2c, ok is false
+// when req isn't one, or not a valid one: it's then served as HTTP/1.1.
+func upgradeSettings(req *request.Request) (settings []setting, ok bool) {
+	if !hasToken(req.Headers, "Upgrade", "h2c") || !hasToken(req.Headers, "Connection", "upgrade") ||
+		!hasToken(req.Headers, "Connection", "http2-settings") {
+		return nil, false
+	}
+	// there must be exactly one, two would have been joined with a comma
+	value, err := req.Headers.Get("HTTP2-Settings")
+	if err != nil {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, false
+	}
+	settings, err = parseSettings(payload)
+	if err != nil {
+		return nil, false
+	}
+	return settings, true
+}
+
+func hasToken(h headers.Headers, key string, token string) bool {
+	for _, v := range h.Values(key) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,541 @@
+package h2c
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveH2c runs handler on a listener the way Serve would and returns its
+// address. accepted counts the connections.
+func serveH2c(t *testing.T, handler func(w *response.Writer, req *request.Request), opts Options) (addr string, accepted *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	s := New(handler, opts)
+	accepted = &atomic.Int32{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go s.ServeConn(conn)
+		}
+	}()
+	return listener.Addr().String(), accepted
+}
+
+// echo answers with the request line, the cookies and the body.
+func echo(w *response.Writer, req *request.Request) {
+	reqBody, _ := req.ReadBody()
+	body := []byte(fmt.Sprintf("%s %s HTTP/%s %s", req.RequestLine.Method, req.RequestLine.RequestTarget,
+		req.RequestLine.HttpVersion, reqBody))
+	h := response.GetDefaultHeaders(len(body))
+	if cookie, err := req.Headers.Get("Cookie"); err == nil {
+		h.Set("X-Cookie", cookie)
+	}
+	h.Set("Set-Cookie", "a=1")
+	h.Set("Set-Cookie", "b=2")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
+
+// h2Client speaks HTTP/2 with prior knowledge, HTTP/1.1 not at all.
+func h2Client() *http.Client {
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: tr, Timeout: 5 * time.Second}
+}
+
+func TestPriorKnowledge(t *testing.T) {
+	addr, _ := serveH2c(t, echo, Options{})
+	client := h2Client()
+
+	// Test: A GET over HTTP/2, Set-Cookie split again
+	resp, err := client.Get("http://" + addr + "/hello?x=1")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "GET /hello?x=1 HTTP/2 ", string(body))
+	assert.Equal(t, []string{"a=1", "b=2"}, resp.Header.Values("Set-Cookie"))
+	assert.Empty(t, resp.Header.Get("Connection"))
+
+	// Test: A POST body, and cookies sent as separate fields
+	req, _ := http.NewRequest("POST", "http://"+addr+"/submit", strings.NewReader("payload"))
+	req.Header.Add("Cookie", "a=1")
+	req.Header.Add("Cookie", "b=2")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "POST /submit HTTP/2 payload", string(body))
+	assert.Equal(t, "a=1; b=2", resp.Header.Get("X-Cookie"))
+
+	// Test: HEAD gets the headers alone
+	resp, err = client.Head("http://" + addr + "/hello")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, int64(len("HEAD /hello HTTP/2 ")), resp.ContentLength)
+
+	// Test: HTTP/1.1 clients are served on the same port, short requests
+	// included
+	resp, err = http.Get("http://" + addr + "/old")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 1, resp.ProtoMajor)
+	assert.Equal(t, "GET /old HTTP/1.1 ", string(body))
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestMultiplexing(t *testing.T) {
+	const n = 20
+	var started sync.WaitGroup
+	started.Add(n)
+	all := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(all)
+	}()
+	addr, accepted := serveH2c(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/warm" {
+			echo(w, req)
+			return
+		}
+		// no handler answers before all of them run
+		started.Done()
+		select {
+		case <-all:
+		case <-time.After(5 * time.Second):
+		}
+		echo(w, req)
+	}, Options{})
+	client := h2Client()
+	// one request first, so the others share its connection
+	resp, err := client.Get("http://" + addr + "/warm")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// Test: Concurrent requests are answered on a single connection
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(fmt.Sprintf("http://%s/%d", addr, i))
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+		}()
+	}
+	wg.Wait()
+	for i := range n {
+		assert.Equal(t, fmt.Sprintf("GET /%d HTTP/2 ", i), bodies[i])
+	}
+	assert.Equal(t, int32(1), accepted.Load())
+}
+
+func TestLargeBodies(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	addr, _ := serveH2c(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method == "POST" {
+			reqBody, _ := req.ReadBody()
+			body := []byte(fmt.Sprintf("%d", len(reqBody)))
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+			return
+		}
+		// chunked, with trailers
+		h := headers.NewHeaders()
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		for i := 0; i < len(big); i += 10000 {
+			w.WriteChunkedBody(big[i:min(i+10000, len(big))])
+		}
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "ok")
+		w.WriteTrailers(trailers)
+	}, Options{MaxBodySize: 3 << 19})
+	client := h2Client()
+
+	// Test: A response well past the flow control windows, and trailers
+	resp, err := client.Get("http://" + addr + "/big")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, big, body)
+	assert.Equal(t, "ok", resp.Trailer.Get("X-Checksum"))
+
+	// Test: An upload past them too
+	resp, err = client.Post("http://"+addr+"/upload", "application/octet-stream", bytes.NewReader(big))
+	require.NoError(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, fmt.Sprintf("%d", len(big)), string(body))
+
+	// Test: Bodies over MaxBodySize get 413
+	resp, err = client.Post("http://"+addr+"/upload", "application/octet-stream", bytes.NewReader(append(big, big...)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 413, resp.StatusCode)
+}
+
+// rawClient speaks HTTP/2 frame by frame.
+type rawClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	enc  *encoder
+	dec  *decoder
+}
+
+func dialRaw(t *testing.T, addr string) *rawClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &rawClient{t: t, conn: conn, r: bufio.NewReader(conn), enc: newEncoder(), dec: newDecoder()}
+}
+
+// start sends the preface and settings.
+func (c *rawClient) start(settings ...setting) {
+	c.conn.Write([]byte(clientPreface))
+	c.writeFrame(frameSettings, 0, 0, appendSettings(nil, settings...))
+}
+
+// writeFrame sends a frame, the server may have hung up already: what it
+// reads tells.
+func (c *rawClient) writeFrame(typ uint8, flags uint8, id uint32, payload []byte) {
+	writeFrame(c.conn, typ, flags, id, payload)
+}
+
+func (c *rawClient) writeHeaders(id uint32, endStream bool, f []headerField) {
+	flags := uint8(flagEndHeaders)
+	if endStream {
+		flags |= flagEndStream
+	}
+	c.writeFrame(frameHeaders, flags, id, c.enc.encode(nil, f))
+}
+
+// next returns the next frame of type typ, answering the server's
+// SETTINGS and skipping what else comes first.
+func (c *rawClient) next(typ uint8) frame {
+	c.t.Helper()
+	for {
+		f, err := readFrame(c.r, maxMaxFrameSize)
+		require.NoError(c.t, err)
+		if f.typ == frameSettings && !f.has(flagAck) {
+			c.writeFrame(frameSettings, flagAck, 0, nil)
+		}
+		if f.typ == typ {
+			return f
+		}
+	}
+}
+
+// response collects the response on stream id.
+func (c *rawClient) response(id uint32) (status string, h []headerField, body string) {
+	c.t.Helper()
+	for {
+		f, err := readFrame(c.r, maxMaxFrameSize)
+		require.NoError(c.t, err)
+		if f.streamID != id {
+			continue
+		}
+		switch f.typ {
+		case frameHeaders:
+			fields, _, err := c.dec.decode(f.payload, 1<<20)
+			require.NoError(c.t, err)
+			if status == "" || strings.HasPrefix(status, "1") {
+				status = fields[0].value
+			}
+			h = append(h, fields[1:]...)
+		case frameData:
+			body += string(f.payload)
+		case frameRSTStream:
+			c.t.Fatalf("stream %d reset: %v", id, ErrCode(binary.BigEndian.Uint32(f.payload)))
+		}
+		if f.has(flagEndStream) {
+			return status, h, body
+		}
+	}
+}
+
+func get(path string) []headerField {
+	return fields(":method", "GET", ":scheme", "http", ":authority", "localhost", ":path", path)
+}
+
+func TestUpgrade(t *testing.T) {
+	addr, _ := serveH2c(t, echo, Options{})
+	c := dialRaw(t, addr)
+	settings := base64.RawURLEncoding.EncodeToString(appendSettings(nil, setting{settingInitialWindowSize, 1 << 20}))
+
+	// Test: The upgrade request is answered on stream 1
+	fmt.Fprintf(c.conn, "POST /up HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: %s\r\nContent-Length: 4\r\n\r\nbody", settings)
+	resp, err := http.ReadResponse(c.r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+	c.start()
+	status, _, body := c.response(1)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "POST /up HTTP/1.1 body", body)
+
+	// Test: Later requests come as frames
+	c.writeHeaders(3, true, get("/next"))
+	status, _, body = c.response(3)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "GET /next HTTP/2 ", body)
+
+	// Test: Upgrades without valid settings are plain HTTP/1.1
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET /plain HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: !!\r\n\r\n"))
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestFrames(t *testing.T) {
+	addr, _ := serveH2c(t, echo, Options{})
+
+	// Test: PING is echoed
+	c := dialRaw(t, addr)
+	c.start()
+	c.writeFrame(framePing, 0, 0, []byte("12345678"))
+	f := c.next(framePing)
+	assert.True(t, f.has(flagAck))
+	assert.Equal(t, "12345678", string(f.payload))
+
+	// Test: A header block split in CONTINUATION frames, a padded body
+	block := c.enc.encode(nil, fields(":method", "POST", ":scheme", "http", ":authority", "localhost", ":path", "/split"))
+	c.writeFrame(frameHeaders, 0, 1, block[:3])
+	c.writeFrame(frameContinuation, 0, 1, block[3:6])
+	c.writeFrame(frameContinuation, flagEndHeaders, 1, block[6:])
+	c.writeFrame(frameData, flagPadded|flagEndStream, 1, append([]byte{3}, "abc\x00\x00\x00"...))
+	status, _, body := c.response(1)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "POST /split HTTP/2 abc", body)
+
+	// Test: The send window is respected, DATA waits for WINDOW_UPDATE
+	c = dialRaw(t, addr)
+	c.start(setting{settingInitialWindowSize, 5})
+	c.next(frameSettings)
+	c.writeHeaders(1, true, get("/window"))
+	c.next(frameHeaders)
+	f = c.next(frameData)
+	assert.Equal(t, "GET /", string(f.payload))
+	c.writeFrame(frameWindowUpdate, 0, 1, binary.BigEndian.AppendUint32(nil, 100))
+	f = c.next(frameData)
+	assert.Equal(t, "window HTTP/2 ", string(f.payload))
+}
+
+func TestFlowControl(t *testing.T) {
+	addr, _ := serveH2c(t, echo, Options{MaxBodySize: 70000})
+	// updates returns the WINDOW_UPDATEs sent for the frames so far, by
+	// stream: they come before the ack of a PING sent after them
+	updates := func(c *rawClient) map[uint32]uint32 {
+		t.Helper()
+		c.writeFrame(framePing, 0, 0, []byte("12345678"))
+		out := map[uint32]uint32{}
+		for {
+			f, err := readFrame(c.r, maxMaxFrameSize)
+			require.NoError(t, err)
+			switch {
+			case f.typ == frameSettings && !f.has(flagAck):
+				c.writeFrame(frameSettings, flagAck, 0, nil)
+			case f.typ == frameWindowUpdate:
+				out[f.streamID] += binary.BigEndian.Uint32(f.payload)
+			case f.typ == framePing:
+				return out
+			}
+		}
+	}
+	c := dialRaw(t, addr)
+	c.start()
+
+	// Test: The connection window grows to MaxConnBodySize
+	assert.Equal(t, map[uint32]uint32{0: 2*70000 - defaultWindowSize}, updates(c))
+
+	// Test: A stream gets window for one byte past MaxBodySize, the
+	// connection none back while the body is buffered
+	c.writeHeaders(1, false, fields(":method", "POST", ":scheme", "http", ":authority", "localhost", ":path", "/up"))
+	part := bytes.Repeat([]byte("a"), 16000)
+	c.writeFrame(frameData, 0, 1, part)
+	assert.Equal(t, map[uint32]uint32{1: 70000 + 1 - defaultWindowSize}, updates(c))
+	c.writeFrame(frameData, 0, 1, part)
+	assert.Empty(t, updates(c))
+
+	// Test: The body's window goes back once it's handed to the handler
+	c.writeFrame(frameData, flagEndStream, 1, part[:100])
+	f := c.next(frameWindowUpdate)
+	assert.Equal(t, uint32(0), f.streamID)
+	assert.Equal(t, uint32(32100), binary.BigEndian.Uint32(f.payload))
+	status, _, body := c.response(1)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "POST /up HTTP/2 "+strings.Repeat("a", 32100), body)
+}
+
+func TestStreamErrors(t *testing.T) {
+	addr, _ := serveH2c(t, echo, Options{MaxHeaderListSize: 1024})
+	reset := func(c *rawClient, id uint32) ErrCode {
+		t.Helper()
+		for {
+			f := c.next(frameRSTStream)
+			if f.streamID == id {
+				return ErrCode(binary.BigEndian.Uint32(f.payload))
+			}
+		}
+	}
+	c := dialRaw(t, addr)
+	c.start()
+
+	// Test: Malformed requests reset their stream alone
+	malformed := [][]headerField{
+		fields(":method", "GET", ":path", "/"),
+		fields(":method", "GET", ":scheme", "http", ":path", "/", "Upper", "x"),
+		fields(":method", "GET", ":scheme", "http", ":path", "/", "connection", "close"),
+		fields(":method", "GET", ":scheme", "http", "x", "y", ":path", "/"),
+		fields(":method", "GET", ":scheme", "http", ":path", "/", ":status", "200"),
+		fields(":method", "GET", ":scheme", "http", ":path", "/", "te", "gzip"),
+	}
+	id := uint32(1)
+	for _, m := range malformed {
+		c.writeHeaders(id, true, m)
+		assert.Equal(t, ErrCodeProtocol, reset(c, id), m)
+		id += 2
+	}
+	c.writeHeaders(id, true, fields(":method", "POST", ":scheme", "http", ":path", "/", "content-length", "3"))
+	assert.Equal(t, ErrCodeProtocol, reset(c, id))
+	id += 2
+
+	// Test: Headers over MaxHeaderListSize get 431
+	c.writeHeaders(id, true, append(get("/"), headerField{name: "x-big", value: strings.Repeat("x", 2000)}))
+	status, _, _ := c.response(id)
+	assert.Equal(t, "431", status)
+	id += 2
+
+	// Test: The connection still works
+	c.writeHeaders(id, true, get("/fine"))
+	status, _, body := c.response(id)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "GET /fine HTTP/2 ", body)
+}
+
+func TestRefusedStream(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	addr, _ := serveH2c(t, func(w *response.Writer, req *request.Request) {
+		<-release
+	}, Options{MaxConcurrentStreams: 1})
+	c := dialRaw(t, addr)
+	c.start()
+
+	// Test: Streams past MaxConcurrentStreams are refused
+	c.writeHeaders(1, true, get("/a"))
+	c.writeHeaders(3, true, get("/b"))
+	f := c.next(frameRSTStream)
+	assert.Equal(t, uint32(3), f.streamID)
+	assert.Equal(t, ErrCodeRefusedStream, ErrCode(binary.BigEndian.Uint32(f.payload)))
+}
+
+func TestConnErrors(t *testing.T) {
+	addr, _ := serveH2c(t, echo, Options{})
+	tests := []struct {
+		name string
+		send func(c *rawClient)
+		code ErrCode
+	}{
+		{"HEADERS on a server stream", func(c *rawClient) { c.writeHeaders(2, true, get("/")) }, ErrCodeProtocol},
+		{"DATA on an idle stream", func(c *rawClient) { c.writeFrame(frameData, 0, 5, []byte("x")) }, ErrCodeProtocol},
+		{"CONTINUATION alone", func(c *rawClient) { c.writeFrame(frameContinuation, flagEndHeaders, 1, nil) }, ErrCodeProtocol},
+		{"interrupted header block", func(c *rawClient) {
+			c.writeFrame(frameHeaders, 0, 1, c.enc.encode(nil, get("/")))
+			c.writeFrame(framePing, 0, 0, make([]byte, 8))
+		}, ErrCodeProtocol},
+		{"invalid header block", func(c *rawClient) { c.writeFrame(frameHeaders, flagEndHeaders, 1, []byte{0x80}) }, ErrCodeCompression},
+		{"window overflow", func(c *rawClient) {
+			c.writeFrame(frameWindowUpdate, 0, 0, binary.BigEndian.AppendUint32(nil, maxWindowSize))
+		}, ErrCodeFlowControl},
+		{"frame over MAX_FRAME_SIZE", func(c *rawClient) { c.writeFrame(frameData, 0, 1, make([]byte, minMaxFrameSize+1)) }, ErrCodeFrameSize},
+		{"SETTINGS with a bad MAX_FRAME_SIZE", func(c *rawClient) {
+			c.writeFrame(frameSettings, 0, 0, appendSettings(nil, setting{settingMaxFrameSize, 100}))
+		}, ErrCodeProtocol},
+		{"PUSH_PROMISE", func(c *rawClient) { c.writeFrame(framePushPromise, flagEndHeaders, 1, make([]byte, 4)) }, ErrCodeProtocol},
+	}
+	for _, tt := range tests {
+		// Test: Errors that end the connection with a GOAWAY
+		c := dialRaw(t, addr)
+		c.start()
+		tt.send(c)
+		f := c.next(frameGoAway)
+		assert.Equal(t, tt.code, ErrCode(binary.BigEndian.Uint32(f.payload[4:])), tt.name)
+	}
+
+	// Test: A preface that doesn't end with SETTINGS
+	c := dialRaw(t, addr)
+	c.conn.Write([]byte(clientPreface))
+	c.writeFrame(framePing, 0, 0, make([]byte, 8))
+	f := c.next(frameGoAway)
+	assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.payload[4:])))
+}
+
+func TestServe(t *testing.T) {
+	s, err := Serve(0, echo, Options{})
+	require.NoError(t, err)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://"+s.listener.Addr().String()+"/", nil)
+
+	// Test: Serve listens for both protocols
+	resp, err := h2Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 2, resp.ProtoMajor)
+}
@@ -0,0 +1,417 @@
+package h2c
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errCompression is a header block that doesn't decode, the connection
+// can't go on since both ends no longer agree on the dynamic table.
+var errCompression = errors.New("hpack: invalid header block")
+
+// headerField is a field as HPACK sees it, pseudo-header fields included.
+// sensitive fields are never added to a dynamic table (RFC 7541 7.1.3).
+type headerField struct {
+	name      string
+	value     string
+	sensitive bool
+}
+
+// size is what the field counts for in a dynamic table and in
+// SETTINGS_MAX_HEADER_LIST_SIZE (RFC 7541 4.1).
+func (f headerField) size() uint32 {
+	return uint32(len(f.name) + len(f.value) + 32)
+}
+
+// staticTable is RFC 7541 Appendix A, index 1 is staticTable[0].
+var staticTable = [...]headerField{
+	{name: ":authority"},
+	{name: ":method", value: "GET"},
+	{name: ":method", value: "POST"},
+	{name: ":path", value: "/"},
+	{name: ":path", value: "/index.html"},
+	{name: ":scheme", value: "http"},
+	{name: ":scheme", value: "https"},
+	{name: ":status", value: "200"},
+	{name: ":status", value: "204"},
+	{name: ":status", value: "206"},
+	{name: ":status", value: "304"},
+	{name: ":status", value: "400"},
+	{name: ":status", value: "404"},
+	{name: ":status", value: "500"},
+	{name: "accept-charset"},
+	{name: "accept-encoding", value: "gzip, deflate"},
+	{name: "accept-language"},
+	{name: "accept-ranges"},
+	{name: "accept"},
+	{name: "access-control-allow-origin"},
+	{name: "age"},
+	{name: "allow"},
+	{name: "authorization"},
+	{name: "cache-control"},
+	{name: "content-disposition"},
+	{name: "content-encoding"},
+	{name: "content-language"},
+	{name: "content-length"},
+	{name: "content-location"},
+	{name: "content-range"},
+	{name: "content-type"},
+	{name: "cookie"},
+	{name: "date"},
+	{name: "etag"},
+	{name: "expect"},
+	{name: "expires"},
+	{name: "from"},
+	{name: "host"},
+	{name: "if-match"},
+	{name: "if-modified-since"},
+	{name: "if-none-match"},
+	{name: "if-range"},
+	{name: "if-unmodified-since"},
+	{name: "last-modified"},
+	{name: "link"},
+	{name: "location"},
+	{name: "max-forwards"},
+	{name: "proxy-authenticate"},
+	{name: "proxy-authorization"},
+	{name: "range"},
+	{name: "referer"},
+	{name: "refresh"},
+	{name: "retry-after"},
+	{name: "server"},
+	{name: "set-cookie"},
+	{name: "strict-transport-security"},
+	{name: "transfer-encoding"},
+	{name: "user-agent"},
+	{name: "vary"},
+	{name: "via"},
+	{name: "www-authenticate"},
+}
+
+// defaultTableSize is SETTINGS_HEADER_TABLE_SIZE until told otherwise.
+const defaultTableSize = 4096
+
+// dynamicTable is the FIFO of RFC 7541 2.3.2, newest entry last.
+type dynamicTable struct {
+	entries []headerField
+	size    uint32
+	maxSize uint32
+}
+
+func newDynamicTable() dynamicTable {
+	return dynamicTable{maxSize: defaultTableSize}
+}
+
+func (t *dynamicTable) add(f headerField) {
+	t.entries = append(t.entries, f)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n uint32) {
+	t.maxSize = n
+	t.evict()
+}
+
+// evict drops the oldest entries until the table fits, an entry larger
+// than the table empties it (RFC 7541 4.4).
+func (t *dynamicTable) evict() {
+	n := 0
+	for t.size > t.maxSize {
+		t.size -= t.entries[n].size()
+		n++
+	}
+	if n > 0 {
+		t.entries = append(t.entries[:0], t.entries[n:]...)
+	}
+}
+
+// field returns the entry at index of the combined address space, the
+// static table first (RFC 7541 2.3.3).
+func (t *dynamicTable) field(index uint64) (headerField, bool) {
+	if index == 0 {
+		return headerField{}, false
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], true
+	}
+	index -= uint64(len(staticTable))
+	if index > uint64(len(t.entries)) {
+		return headerField{}, false
+	}
+	return t.entries[len(t.entries)-int(index)], true
+}
+
+// search looks for f in both tables. It returns the index of an entry
+// that matches the name and the value, or of one that matches the name
+// only, 0 for neither.
+func (t *dynamicTable) search(f headerField) (index uint64, nameAndValue bool) {
+	for i, s := range staticTable {
+		if s.name != f.name {
+			continue
+		}
+		if s.value == f.value {
+			return uint64(i + 1), true
+		}
+		if index == 0 {
+			index = uint64(i + 1)
+		}
+	}
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		e := t.entries[i]
+		if e.name != f.name {
+			continue
+		}
+		at := uint64(len(staticTable) + len(t.entries) - i)
+		if e.value == f.value {
+			return at, true
+		}
+		if index == 0 {
+			index = at
+		}
+	}
+	return index, false
+}
+
+// decoder decodes the header blocks of one direction of a connection.
+type decoder struct {
+	table dynamicTable
+	// maxTableSize is the SETTINGS_HEADER_TABLE_SIZE we announced, size
+	// updates above it are errors
+	maxTableSize uint32
+}
+
+func newDecoder() *decoder {
+	return &decoder{table: newDynamicTable(), maxTableSize: defaultTableSize}
+}
+
+// decode decodes a whole header block. Past maxListSize it keeps decoding,
+// the dynamic table has to stay in sync, but drops the fields and reports
+// tooLarge.
+func (d *decoder) decode(block []byte, maxListSize uint32) (fields []headerField, tooLarge bool, err error) {
+	var listSize uint32
+	// size updates only come first (RFC 7541 4.2)
+	updates := true
+	for len(block) > 0 {
+		b := block[0]
+		var f headerField
+		switch {
+		case b&0x80 != 0:
+			// indexed (RFC 7541 6.1)
+			var index uint64
+			index, block, err = readInt(block, 7)
+			if err != nil {
+				return nil, false, err
+			}
+			var ok bool
+			if f, ok = d.table.field(index); !ok {
+				return nil, false, fmt.Errorf("%w: index %d out of range", errCompression, index)
+			}
+		case b&0xe0 == 0x20:
+			// dynamic table size update (RFC 7541 6.3)
+			if !updates {
+				return nil, false, fmt.Errorf("%w: table size update after a field", errCompression)
+			}
+			var size uint64
+			size, block, err = readInt(block, 5)
+			if err != nil {
+				return nil, false, err
+			}
+			if size > uint64(d.maxTableSize) {
+				return nil, false, fmt.Errorf("%w: table size %d over %d", errCompression, size, d.maxTableSize)
+			}
+			d.table.setMaxSize(uint32(size))
+			continue
+		default:
+			// literals: with incremental indexing (6.2.1), without (6.2.2)
+			// or never indexed (6.2.3)
+			prefix := uint8(4)
+			indexing := b&0xc0 == 0x40
+			if indexing {
+				prefix = 6
+			}
+			f.sensitive = b&0xf0 == 0x10
+			var index uint64
+			index, block, err = readInt(block, prefix)
+			if err != nil {
+				return nil, false, err
+			}
+			if index == 0 {
+				f.name, block, err = readString(block)
+			} else {
+				named, ok := d.table.field(index)
+				if !ok {
+					return nil, false, fmt.Errorf("%w: index %d out of range", errCompression, index)
+				}
+				f.name = named.name
+			}
+			if err != nil {
+				return nil, false, err
+			}
+			f.value, block, err = readString(block)
+			if err != nil {
+				return nil, false, err
+			}
+			if indexing {
+				d.table.add(headerField{name: f.name, value: f.value})
+			}
+		}
+		updates = false
+		listSize += f.size()
+		if listSize > maxListSize {
+			tooLarge = true
+			fields = nil
+		}
+		if !tooLarge {
+			fields = append(fields, f)
+		}
+	}
+	return fields, tooLarge, nil
+}
+
+// encoder encodes the header blocks of one direction of a connection.
+type encoder struct {
+	table dynamicTable
+	// pendingSize is set when the table size changed since the last
+	// block, which then starts with a size update
+	pendingSize bool
+}
+
+func newEncoder() *encoder {
+	return &encoder{table: newDynamicTable()}
+}
+
+// setMaxTableSize applies the peer's SETTINGS_HEADER_TABLE_SIZE. We don't
+// use more than the default even if allowed to.
+func (e *encoder) setMaxTableSize(n uint32) {
+	n = min(n, defaultTableSize)
+	if n != e.table.maxSize {
+		e.table.setMaxSize(n)
+		e.pendingSize = true
+	}
+}
+
+// encode appends the header block of fields to dst.
+func (e *encoder) encode(dst []byte, fields []headerField) []byte {
+	if e.pendingSize {
+		dst = appendInt(dst, 0x20, 5, uint64(e.table.maxSize))
+		e.pendingSize = false
+	}
+	for _, f := range fields {
+		index, exact := e.table.search(f)
+		if exact && !f.sensitive {
+			dst = appendInt(dst, 0x80, 7, index)
+			continue
+		}
+		// values that change with every response would only churn the
+		// table
+		indexing := !f.sensitive && f.size() <= e.table.maxSize/2 && !volatile[f.name]
+		switch {
+		case f.sensitive:
+			dst = appendInt(dst, 0x10, 4, index)
+		case indexing:
+			dst = appendInt(dst, 0x40, 6, index)
+		default:
+			dst = appendInt(dst, 0x00, 4, index)
+		}
+		if index == 0 {
+			dst = appendString(dst, f.name)
+		}
+		dst = appendString(dst, f.value)
+		if indexing {
+			e.table.add(headerField{name: f.name, value: f.value})
+		}
+	}
+	return dst
+}
+
+// volatile are the fields sent as literals without indexing.
+var volatile = map[string]bool{
+	":path":          true,
+	"content-length": true,
+	"content-range":  true,
+	"date":           true,
+	"etag":           true,
+	"last-modified":  true,
+	"expires":        true,
+	"age":            true,
+}
+
+// readInt decodes an integer with an n bit prefix (RFC 7541 5.1).
+func readInt(p []byte, n uint8) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, p, fmt.Errorf("%w: truncated integer", errCompression)
+	}
+	max := uint64(1)<<n - 1
+	i := uint64(p[0]) & max
+	p = p[1:]
+	if i < max {
+		return i, p, nil
+	}
+	for shift := 0; ; shift += 7 {
+		if len(p) == 0 {
+			return 0, p, fmt.Errorf("%w: truncated integer", errCompression)
+		}
+		// nothing we decode comes near 2^32
+		if shift > 28 {
+			return 0, p, fmt.Errorf("%w: integer too large", errCompression)
+		}
+		b := p[0]
+		p = p[1:]
+		i += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+	}
+}
+
+// appendInt encodes i with an n bit prefix, the bits above it in the first
+// byte come from flags.
+func appendInt(dst []byte, flags byte, n uint8, i uint64) []byte {
+	max := uint64(1)<<n - 1
+	if i < max {
+		return append(dst, flags|byte(i))
+	}
+	dst = append(dst, flags|byte(max))
+	i -= max
+	for i >= 0x80 {
+		dst = append(dst, byte(i&0x7f)|0x80)
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
+
+// readString decodes a string literal (RFC 7541 5.2).
+func readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", p, fmt.Errorf("%w: truncated string", errCompression)
+	}
+	huffman := p[0]&0x80 != 0
+	length, p, err := readInt(p, 7)
+	if err != nil {
+		return "", p, err
+	}
+	if uint64(len(p)) < length {
+		return "", p, fmt.Errorf("%w: truncated string", errCompression)
+	}
+	s := p[:length]
+	p = p[length:]
+	if !huffman {
+		return string(s), p, nil
+	}
+	decoded, err := huffmanDecode(s)
+	if err != nil {
+		return "", p, err
+	}
+	return string(decoded), p, nil
+}
+
+// appendString encodes s, with the Huffman code when that's shorter.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanLength(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
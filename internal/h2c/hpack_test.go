@@ -0,0 +1,235 @@
+package h2c
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func fields(pairs ...string) []headerField {
+	var out []headerField
+	for i := 0; i < len(pairs); i += 2 {
+		out = append(out, headerField{name: pairs[i], value: pairs[i+1]})
+	}
+	return out
+}
+
+func TestInteger(t *testing.T) {
+	// Test: RFC 7541 C.1, both ways
+	tests := []struct {
+		i      uint64
+		prefix uint8
+		hex    string
+	}{
+		{10, 5, "0a"},
+		{1337, 5, "1f9a0a"},
+		{42, 8, "2a"},
+		{31, 5, "1f00"},
+	}
+	for _, tt := range tests {
+		encoded := appendInt(nil, 0, tt.prefix, tt.i)
+		assert.Equal(t, tt.hex, hex.EncodeToString(encoded))
+		i, rest, err := readInt(encoded, tt.prefix)
+		require.NoError(t, err)
+		assert.Equal(t, tt.i, i)
+		assert.Empty(t, rest)
+	}
+
+	// Test: Truncated and oversized integers
+	for _, bad := range []string{"1f", "1f9a", "1fffffffffff01"} {
+		_, _, err := readInt(unhex(t, bad), 5)
+		assert.ErrorIs(t, err, errCompression, bad)
+	}
+}
+
+// requests are RFC 7541 C.3 (plain) and C.4 (Huffman): three requests on
+// one connection, sharing a dynamic table.
+var requests = []struct {
+	plain   string
+	huffman string
+	fields  []headerField
+	size    uint32
+}{
+	{
+		"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+		"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+		fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com"),
+		57,
+	},
+	{
+		"8286 84be 5808 6e6f 2d63 6163 6865",
+		"8286 84be 5886 a8eb 1064 9cbf",
+		fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com", "cache-control", "no-cache"),
+		110,
+	},
+	{
+		"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+		"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+		fields(":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com", "custom-key", "custom-value"),
+		164,
+	},
+}
+
+func TestDecodeRequests(t *testing.T) {
+	plain, huffman := newDecoder(), newDecoder()
+	for i, tt := range requests {
+		// Test: RFC 7541 C.3, literals as they are
+		got, tooLarge, err := plain.decode(unhex(t, tt.plain), 1<<20)
+		require.NoError(t, err, i)
+		assert.False(t, tooLarge)
+		assert.Equal(t, tt.fields, got, i)
+		assert.Equal(t, tt.size, plain.table.size, i)
+
+		// Test: RFC 7541 C.4, Huffman coded literals
+		got, _, err = huffman.decode(unhex(t, tt.huffman), 1<<20)
+		require.NoError(t, err, i)
+		assert.Equal(t, tt.fields, got, i)
+		assert.Equal(t, tt.size, huffman.table.size, i)
+	}
+}
+
+func TestEncodeRequests(t *testing.T) {
+	// Test: The encoder indexes and Huffman codes as RFC 7541 C.4 does
+	enc := newEncoder()
+	for i, tt := range requests {
+		got := enc.encode(nil, tt.fields)
+		assert.Equal(t, strings.ReplaceAll(tt.huffman, " ", ""), hex.EncodeToString(got), i)
+	}
+}
+
+func TestDecodeEviction(t *testing.T) {
+	// Test: RFC 7541 C.5, responses in a 256 byte table
+	d := newDecoder()
+	d.maxTableSize = 256
+	d.table.setMaxSize(256)
+	blocks := []struct {
+		hex    string
+		fields []headerField
+		size   uint32
+	}{
+		{
+			"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			fields(":status", "302", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT", "location", "https://www.example.com"),
+			222,
+		},
+		{
+			"4803 3330 37c1 c0bf",
+			fields(":status", "307", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT", "location", "https://www.example.com"),
+			222,
+		},
+		{
+			"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31",
+			fields(":status", "200", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:22 GMT", "location", "https://www.example.com",
+				"content-encoding", "gzip", "set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"),
+			215,
+		},
+	}
+	for i, tt := range blocks {
+		got, _, err := d.decode(unhex(t, tt.hex), 1<<20)
+		require.NoError(t, err, i)
+		assert.Equal(t, tt.fields, got, i)
+		assert.Equal(t, tt.size, d.table.size, i)
+	}
+	assert.Len(t, d.table.entries, 3)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"index 0", "80"},
+		{"index past the tables", "ff00"},
+		{"literal name index past the tables", "7f0001 61"},
+		{"truncated string", "0003 6162"},
+		{"size update after a field", "82 20"},
+		{"size update over the setting", "3fe21f"},
+		{"Huffman padding of a whole byte", "0082 1fff"},
+		{"Huffman padding that isn't EOS", "0081 00"},
+		{"EOS in a Huffman string", "0084 ffff fffc"},
+	}
+	for _, tt := range tests {
+		// Test: Blocks that break the decoder
+		_, _, err := newDecoder().decode(unhex(t, tt.hex), 1<<20)
+		assert.ErrorIs(t, err, errCompression, tt.name)
+	}
+
+	// Test: A list over the limit is reported, the table still updated
+	d := newDecoder()
+	got, tooLarge, err := d.decode(unhex(t, requests[0].plain), 100)
+	require.NoError(t, err)
+	assert.True(t, tooLarge)
+	assert.Nil(t, got)
+	assert.Equal(t, uint32(57), d.table.size)
+
+	// Test: A size update at the start shrinks the table
+	got, _, err = d.decode(unhex(t, "20 82"), 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, fields(":method", "GET"), got)
+	assert.Empty(t, d.table.entries)
+}
+
+func TestEncoder(t *testing.T) {
+	enc, dec := newEncoder(), newDecoder()
+	roundTrip := func(in []headerField) []byte {
+		t.Helper()
+		block := enc.encode(nil, in)
+		out, _, err := dec.decode(block, 1<<20)
+		require.NoError(t, err)
+		assert.Equal(t, len(in), len(out))
+		for i := range in {
+			assert.Equal(t, in[i].name, out[i].name)
+			assert.Equal(t, in[i].value, out[i].value)
+		}
+		return block
+	}
+
+	// Test: Repeated fields come from the dynamic table
+	in := fields(":status", "200", "content-type", "text/html", "server", "http-in-go")
+	first := roundTrip(in)
+	second := roundTrip(in)
+	assert.Less(t, len(second), len(first))
+	assert.Equal(t, "88bfbe", hex.EncodeToString(second))
+
+	// Test: Sensitive fields are never indexed, volatile ones not indexed
+	before := len(enc.table.entries)
+	block := roundTrip([]headerField{{name: "set-cookie", value: "id=1", sensitive: true}, {name: "content-length", value: "42"}})
+	assert.Equal(t, byte(0x10), block[0]&0xf0)
+	assert.Equal(t, before, len(enc.table.entries))
+
+	// Test: A smaller table from the peer is announced in the next block
+	enc.setMaxTableSize(0)
+	dec.maxTableSize = 0
+	block = roundTrip(in)
+	assert.Equal(t, byte(0x20), block[0])
+	assert.Empty(t, enc.table.entries)
+	assert.Empty(t, dec.table.entries)
+}
+
+func TestHuffman(t *testing.T) {
+	// Test: Every byte value survives encoding
+	var all strings.Builder
+	for i := 0; i < 256; i++ {
+		all.WriteByte(byte(i))
+	}
+	for _, s := range []string{"", "a", "www.example.com", "no-cache", all.String()} {
+		encoded := huffmanEncode(nil, s)
+		assert.Equal(t, huffmanLength(s), len(encoded), s)
+		decoded, err := huffmanDecode(encoded)
+		require.NoError(t, err, s)
+		assert.Equal(t, s, string(decoded))
+	}
+
+	// Test: RFC 7541 C.4.1
+	assert.Equal(t, "f1e3c2e5f23a6ba0ab90f4ff", hex.EncodeToString(huffmanEncode(nil, "www.example.com")))
+}
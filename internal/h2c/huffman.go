@@ -0,0 +1,100 @@
+package h2c
+
+import (
+	"fmt"
+	"sync"
+)
+
+// huffmanLength is the size of s Huffman encoded, in bytes.
+func huffmanLength(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanLengths[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// huffmanEncode appends s Huffman encoded to dst, padded with the most
+// significant bits of EOS (RFC 7541 5.2).
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	n := 0
+	for i := 0; i < len(s); i++ {
+		length := int(huffmanLengths[s[i]])
+		acc = acc<<length | uint64(huffmanCodes[s[i]])
+		n += length
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
+
+// huffmanNode is a node of the decoding tree, a leaf when sym >= 0.
+type huffmanNode struct {
+	children [2]int32
+	sym      int16
+}
+
+var (
+	huffmanTreeOnce sync.Once
+	huffmanTree     []huffmanNode
+)
+
+// buildHuffmanTree turns the code table into a binary tree, the root at 0.
+func buildHuffmanTree() {
+	huffmanTree = []huffmanNode{{sym: -1}}
+	for sym := range huffmanCodes {
+		code, length := huffmanCodes[sym], int(huffmanLengths[sym])
+		node := 0
+		for i := length - 1; i >= 0; i-- {
+			bit := code >> i & 1
+			next := huffmanTree[node].children[bit]
+			if next == 0 {
+				huffmanTree = append(huffmanTree, huffmanNode{sym: -1})
+				next = int32(len(huffmanTree) - 1)
+				huffmanTree[node].children[bit] = next
+			}
+			node = int(next)
+		}
+		huffmanTree[node].sym = int16(sym)
+	}
+}
+
+// huffmanDecode decodes a Huffman encoded string. The padding must be
+// shorter than a byte and all ones, and EOS mustn't show up (RFC 7541 5.2).
+func huffmanDecode(p []byte) ([]byte, error) {
+	huffmanTreeOnce.Do(buildHuffmanTree)
+	out := make([]byte, 0, len(p)*8/5)
+	node := 0
+	// bits since the last symbol, and whether they were all ones
+	pending, ones := 0, true
+	for _, b := range p {
+		for i := 7; i >= 0; i-- {
+			bit := b >> i & 1
+			node = int(huffmanTree[node].children[bit])
+			if node == 0 {
+				return nil, fmt.Errorf("%w: invalid Huffman code", errCompression)
+			}
+			pending++
+			ones = ones && bit == 1
+			sym := huffmanTree[node].sym
+			if sym < 0 {
+				continue
+			}
+			if sym == 256 {
+				return nil, fmt.Errorf("%w: EOS in Huffman string", errCompression)
+			}
+			out = append(out, byte(sym))
+			node, pending, ones = 0, 0, true
+		}
+	}
+	if pending > 7 || !ones {
+		return nil, fmt.Errorf("%w: invalid Huffman padding", errCompression)
+	}
+	return out, nil
+}
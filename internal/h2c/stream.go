@@ -0,0 +1,205 @@
+package h2c
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// stream is a request from the client and the response to it.
+type stream struct {
+	id uint32
+
+	// the reader goroutine's, until the handler starts
+	head         *requestHead
+	body         []byte
+	recvWindow   int64
+	remoteClosed bool
+	// rejected is set once the stream is answered before the request
+	// ended, what the client still sends is discarded
+	rejected bool
+
+	// guarded by serverConn.mu
+	sendWindow int64
+	reset      bool
+}
+
+// requestHead is a request's header block, checked.
+type requestHead struct {
+	method    string
+	scheme    string
+	authority string
+	path      string
+	fields    []headerField
+}
+
+// connectionSpecific are the fields HTTP/2 does without (RFC 9113 8.2.2).
+var connectionSpecific = []string{"connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade"}
+
+// parseRequestHead checks the fields of a request (RFC 9113 8.2 and 8.3.1),
+// a malformed request is a stream error.
+func parseRequestHead(id uint32, fields []headerField) (*requestHead, error) {
+	head := &requestHead{}
+	seen := map[string]bool{}
+	regular := false
+	for _, f := range fields {
+		if f.name != strings.ToLower(f.name) {
+			return nil, streamError(id, ErrCodeProtocol, "uppercase field name %q", f.name)
+		}
+		if !strings.HasPrefix(f.name, ":") {
+			regular = true
+			if slices.Contains(connectionSpecific, f.name) {
+				return nil, streamError(id, ErrCodeProtocol, "connection-specific field %q", f.name)
+			}
+			if f.name == "te" && f.value != "trailers" {
+				return nil, streamError(id, ErrCodeProtocol, "te: %q", f.value)
+			}
+			head.fields = append(head.fields, f)
+			continue
+		}
+		if regular {
+			return nil, streamError(id, ErrCodeProtocol, "pseudo-header %q after regular fields", f.name)
+		}
+		if seen[f.name] {
+			return nil, streamError(id, ErrCodeProtocol, "duplicate pseudo-header %q", f.name)
+		}
+		seen[f.name] = true
+		switch f.name {
+		case ":method":
+			head.method = f.value
+		case ":scheme":
+			head.scheme = f.value
+		case ":authority":
+			head.authority = f.value
+		case ":path":
+			head.path = f.value
+		default:
+			return nil, streamError(id, ErrCodeProtocol, "unknown pseudo-header %q", f.name)
+		}
+	}
+	if head.method == "" {
+		return nil, streamError(id, ErrCodeProtocol, "missing :method")
+	}
+	if head.method == "CONNECT" {
+		if head.authority == "" || seen[":scheme"] || seen[":path"] {
+			return nil, streamError(id, ErrCodeProtocol, "CONNECT wants :authority alone")
+		}
+		return head, nil
+	}
+	if head.scheme == "" || head.path == "" {
+		return nil, streamError(id, ErrCodeProtocol, "missing :scheme or :path")
+	}
+	return head, nil
+}
+
+// checkTrailers checks the fields of a trailer section, request trailers
+// have nowhere to go in a request.Request and are dropped once checked.
+func checkTrailers(id uint32, fields []headerField) error {
+	for _, f := range fields {
+		if strings.HasPrefix(f.name, ":") || f.name != strings.ToLower(f.name) {
+			return streamError(id, ErrCodeProtocol, "invalid trailer %q", f.name)
+		}
+	}
+	return nil
+}
+
+// newRequest makes the request.Request handlers get: it's written out as
+// HTTP/1.1 and parsed back, so it goes through the same checks and options
+// as the requests of the HTTP/1.1 side. A Content-Length that doesn't match
+// the body is a stream error, other failures are answered with
+// requestError.
+func newRequest(id uint32, head *requestHead, body []byte, opts request.Options) (*request.Request, error) {
+	h := headers.NewHeaders()
+	for _, f := range head.fields {
+		// cookies may come split in several fields (RFC 9113 8.2.3)
+		if f.name == "cookie" {
+			if c, err := h.Get("cookie"); err == nil {
+				h.SetOVR("cookie", c+"; "+f.value)
+				continue
+			}
+		}
+		h.Set(f.name, f.value)
+	}
+	if length, err := h.Get("content-length"); err == nil {
+		if n, err := strconv.Atoi(length); err != nil || n != len(body) {
+			return nil, streamError(id, ErrCodeProtocol, "content-length %q for a body of %d bytes", length, len(body))
+		}
+	} else if len(body) > 0 {
+		h.Set("content-length", strconv.Itoa(len(body)))
+	}
+	if _, err := h.Get("host"); err != nil && head.authority != "" {
+		h.Set("host", head.authority)
+	}
+	target := head.path
+	if head.method == "CONNECT" {
+		target = head.authority
+	}
+	out := &request.Request{
+		RequestLine: request.RequestLine{Method: head.method, RequestTarget: target, HttpVersion: "1.1"},
+		Headers:     h,
+		Body:        body,
+	}
+	var buf bytes.Buffer
+	if err := out.Write(&buf); err != nil {
+		return nil, err
+	}
+	req, err := request.RequestFromReaderWithOptions(&buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestLine.HttpVersion = "2"
+	return req, nil
+}
+
+// requestError answers a request that newRequest refused, as the HTTP/1.1
+// side would.
+func requestError(w *response.Writer, err error) {
+	code := response.StatusBadRequest
+	h := response.GetDefaultHeaders(0)
+	switch {
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		code = response.StatusUnsupportedMediaType
+		h.Set("Accept-Encoding", "gzip, deflate")
+	case errors.Is(err, request.ErrBodyTooLarge):
+		code = response.StatusContentTooLarge
+	}
+	writeError(w, code, h)
+}
+
+func writeError(w *response.Writer, code response.StatusCode, h headers.Headers) {
+	if h == nil {
+		h = response.GetDefaultHeaders(0)
+	}
+	w.WriteStatusLine(code)
+	w.WriteHeaders(h)
+}
+
+// responseFields turns a response head written by a handler into the
+// fields of a HEADERS frame, without the connection-specific ones.
+func responseFields(code response.StatusCode, h headers.Headers) []headerField {
+	fields := []headerField{{name: ":status", value: strconv.Itoa(int(code))}}
+	return append(fields, regularFields(h)...)
+}
+
+func regularFields(h headers.Headers) []headerField {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, strings.ToLower(key))
+	}
+	slices.Sort(keys)
+	var fields []headerField
+	for _, key := range keys {
+		if slices.Contains(connectionSpecific, key) {
+			continue
+		}
+		for _, value := range h.FieldLines(key) {
+			fields = append(fields, headerField{name: key, value: value, sensitive: key == "set-cookie"})
+		}
+	}
+	return fields
+}
@@ -4,25 +4,66 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"strings"
 )
 
-type Headers map[string]string
+// Headers maps lower-cased field names to their values, one per field line
+// received or Set. Fields that are lists are combined into one line when
+// written and read, Set-Cookie is the exception (RFC 9110 5.3).
+type Headers map[string][]string
 
 func NewHeaders() Headers {
 	return Headers{}
 }
 
 const crlf = "\r\n"
+const setCookie = "set-cookie"
+
+// ParseOptions tweak how field lines are parsed.
+type ParseOptions struct {
+	// UnfoldObsFold joins obsolete folded lines (a line starting with a space
+	// or tab continues the previous field) into one value separated by a
+	// single space. Without it folded lines are rejected.
+	UnfoldObsFold bool
+}
 
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWithOptions(data, ParseOptions{})
+}
+
+func (h Headers) ParseWithOptions(data []byte, opts ParseOptions) (n int, done bool, err error) {
 	crlfIndex := bytes.Index(data, []byte(crlf))
 	if crlfIndex == -1 {
 		return 0, false, nil
 	} else if crlfIndex == 0 {
 		return 2, true, nil
 	}
+	if isWhitespace(data[0]) {
+		return 0, false, errors.New("obsolete line folding")
+	}
 	headerString := string(data[:crlfIndex])
+	end := crlfIndex + 2
+
+	if opts.UnfoldObsFold {
+		// we can only tell the field is complete once we see the first
+		// byte of the next line
+		for {
+			if end >= len(data) {
+				return 0, false, nil
+			}
+			if !isWhitespace(data[end]) {
+				break
+			}
+			next := bytes.Index(data[end:], []byte(crlf))
+			if next == -1 {
+				return 0, false, nil
+			}
+			headerString = strings.TrimRight(headerString, " \t") + " " + strings.Trim(string(data[end:end+next]), " \t")
+			end += next + 2
+		}
+	}
 
 	key, value, err := getHeaderFromString(headerString)
 
@@ -30,35 +71,144 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 0, false, err
 	}
 	h.Set(key, value)
-	return crlfIndex + 2, false, nil
+	return end, false, nil
 }
 
+// Keys are stored lower cased so lookups are case-insensitive. The casing
+// used on the wire comes from CanonicalKey.
 func (h Headers) Set(key string, value string) {
-	existingValue, exists := h[key]
-	if exists {
-		h[key] = existingValue + "," + value
-		return
+	key = strings.ToLower(key)
+	h[key] = append(h[key], value)
+}
+
+// Write serializes the field lines followed by the empty line. Names are
+// written in their canonical casing, see CanonicalKey. Names and values are
+// validated first so a value carrying CR or LF can't smuggle extra fields or
+// a second message onto the wire.
+func (h Headers) Write(w io.Writer) error {
+	return h.WriteWithCasing(w, nil)
+}
+
+// WriteWithCasing is Write with names written as casing has them, for peers
+// that match names case-sensitively and expect something other than the
+// usual "Content-Length" form. casing maps lower-cased names to the form to
+// write, which must be the same name.
+func (h Headers) WriteWithCasing(w io.Writer, casing map[string]string) error {
+	headerString := ""
+	for key := range h {
+		if key == "" || !Validate(key) {
+			return fmt.Errorf("invalid field name: %q", key)
+		}
+		name := CanonicalKey(key)
+		if override, ok := casing[key]; ok {
+			if !strings.EqualFold(override, key) || !Validate(override) {
+				return fmt.Errorf("invalid casing %q for field %s", override, key)
+			}
+			name = override
+		}
+		for _, value := range h.FieldLines(key) {
+			if !ValidateValue(value) {
+				return fmt.Errorf("invalid value for field %s", key)
+			}
+			headerString += fmt.Sprintf("%s: %s\r\n", name, value)
+		}
+	}
+	headerString += crlf
+	_, err := w.Write([]byte(headerString))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FieldLines returns the values key is written with, one per field line.
+// That's a single line, the values joined with commas, for everything
+// except Set-Cookie: its values can contain commas (Expires) so they can't
+// be combined into a list, each cookie gets a line of its own.
+func (h Headers) FieldLines(key string) []string {
+	keyLower := strings.ToLower(key)
+	values := h[keyLower]
+	if len(values) == 0 {
+		return []string{}
+	}
+	if keyLower == setCookie {
+		return slices.Clone(values)
+	}
+	return []string{strings.Join(values, ",")}
+}
+
+// Clone returns a copy of h that can be changed without touching h.
+func (h Headers) Clone() Headers {
+	out := make(Headers, len(h))
+	for key, values := range h {
+		out[key] = slices.Clone(values)
 	}
-	h[key] = value
+	return out
 }
+
 func (h Headers) SetOVR(key string, value string) {
-	h[key] = value
+	h[strings.ToLower(key)] = []string{value}
 }
 
 func (h Headers) Delete(key string) error {
-	_, exists := h[key]
+	keyLower := strings.ToLower(key)
+	_, exists := h[keyLower]
 	if exists {
-		delete(h, key)
+		delete(h, keyLower)
+		return nil
 	}
 	return fmt.Errorf("key %s doesn't exist in headers", key)
 }
+
+// Get returns the value of key, the values joined with commas when it was
+// Set more than once. Set-Cookie values don't combine, Get returns the
+// first: FieldLines has them all.
 func (h Headers) Get(key string) (string, error) {
 	keyLower := strings.ToLower(key)
-	existingValue, exists := h[keyLower]
-	if exists {
-		return existingValue, nil
+	values := h[keyLower]
+	if len(values) == 0 {
+		return "", errors.New("key doesn't exist")
+	}
+	if keyLower == setCookie {
+		return values[0], nil
+	}
+	return strings.Join(values, ","), nil
+}
+
+// casing has the names whose conventional casing doesn't follow the dash
+// rule.
+var casing = map[string]string{
+	"content-md5":              "Content-MD5",
+	"dnt":                      "DNT",
+	"etag":                     "ETag",
+	"te":                       "TE",
+	"www-authenticate":         "WWW-Authenticate",
+	"x-xss-protection":         "X-XSS-Protection",
+	"sec-websocket-accept":     "Sec-WebSocket-Accept",
+	"sec-websocket-key":        "Sec-WebSocket-Key",
+	"sec-websocket-version":    "Sec-WebSocket-Version",
+	"sec-websocket-protocol":   "Sec-WebSocket-Protocol",
+	"sec-websocket-extensions": "Sec-WebSocket-Extensions",
+}
+
+// CanonicalKey returns the casing a field name is written with: the first
+// letter and every letter after a dash upper cased, the rest lower cased,
+// except for the few names like ETag that are spelled otherwise. Writers
+// can override it per name, see WriteWithCasing.
+func CanonicalKey(key string) string {
+	keyLower := strings.ToLower(key)
+	if canonical, ok := casing[keyLower]; ok {
+		return canonical
+	}
+	b := []byte(keyLower)
+	upper := true
+	for i, c := range b {
+		if upper && c >= 'a' && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+		upper = c == '-'
 	}
-	return "", errors.New("key doesn't exist")
+	return string(b)
 }
 
 func getHeaderFromString(s string) (string, string, error) {
@@ -70,7 +220,7 @@ func getHeaderFromString(s string) (string, string, error) {
 	value := s[colonIndex+1:]
 
 	if strings.Contains(key, " ") {
-		return "", "", errors.New("Bad Request")
+		return "", "", errors.New("Whitespace in field name")
 	}
 	if len(key) < 1 {
 		return "", "", errors.New("Invalid length for key")
@@ -78,7 +228,10 @@ func getHeaderFromString(s string) (string, string, error) {
 	if !Validate(key) {
 		return "", "", errors.New("Invalid character used in key")
 	}
-	value = strings.TrimSpace(value)
+	value = strings.Trim(value, " \t")
+	if !ValidateValue(value) {
+		return "", "", errors.New("Invalid character used in value")
+	}
 	key = strings.ToLower(key)
 	return key, value, nil
 
@@ -105,3 +258,21 @@ func Validate(s string) bool {
 	}
 	return true
 }
+
+// ValidateValue reports whether s is a valid field value: visible ASCII,
+// obs-text, spaces and tabs. Control characters such as NUL, CR and LF are
+// rejected so a value can never end the field line early.
+func ValidateValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\t' || (c >= 0x20 && c != 0x7f) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
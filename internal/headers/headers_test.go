@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -29,12 +30,12 @@ func TestHeadersParsing(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
-	h = map[string]string{"host": "localhost:42069"}
+	h = Headers{"host": {"localhost:42069"}}
 	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
 	n, done, err = h.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, h)
-	assert.Equal(t, "localhost:42069", h["host"])
+	assert.Equal(t, []string{"localhost:42069"}, h["host"])
 	value, _ = h.Get("User-Agent")
 	assert.Equal(t, "curl/7.81.0", value)
 	assert.Equal(t, 25, n)
@@ -60,13 +61,13 @@ func TestHeadersParsing(t *testing.T) {
 
 	// "Valid single header with extra whitespace"
 
-	h = map[string]string{"host": "localhost:42069"}
+	h = Headers{"host": {"localhost:42069"}}
 	data = []byte("auth:sfs4392\r\n\r\n")
 	n, done, err = h.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, h)
-	assert.Equal(t, "localhost:42069", h["host"])
-	assert.Equal(t, "sfs4392", h["auth"])
+	assert.Equal(t, []string{"localhost:42069"}, h["host"])
+	assert.Equal(t, []string{"sfs4392"}, h["auth"])
 	assert.Equal(t, 14, n)
 	assert.False(t, done)
 	// Test for invalid key values
@@ -87,7 +88,7 @@ func TestHeadersParsing(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Valid single header
-	h = map[string]string{"host": "initialValue"}
+	h = Headers{"host": {"initialValue"}}
 	data = []byte("Host: anotherValue\r\n\r\n")
 	n, done, err = h.Parse(data)
 	require.NoError(t, err)
@@ -98,3 +99,108 @@ func TestHeadersParsing(t *testing.T) {
 	assert.False(t, done)
 
 }
+
+func TestHeaderValueValidation(t *testing.T) {
+	// Test: Control characters in the value
+	for _, data := range []string{
+		"Host: local\x00host\r\n\r\n",
+		"Host: local\rhost\r\n\r\n",
+		"Host: local\x7fhost\r\n\r\n",
+	} {
+		h := NewHeaders()
+		n, done, err := h.Parse([]byte(data))
+		require.Error(t, err, data)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
+
+	// Test: Tabs and obs-text are allowed
+	h := NewHeaders()
+	_, _, err := h.Parse([]byte("X-Note: caf\xc3\xa9\tau lait\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"caf\xc3\xa9\tau lait"}, h["x-note"])
+
+	// Test: obs-fold is rejected by default
+	h = NewHeaders()
+	data := []byte("X-Long: first\r\n  second\r\n\r\n")
+	n, _, err := h.Parse(data)
+	require.NoError(t, err)
+	_, _, err = h.Parse(data[n:])
+	require.Error(t, err)
+
+	// Test: obs-fold unfolded into a single space
+	h = NewHeaders()
+	opts := ParseOptions{UnfoldObsFold: true}
+	n, done, err := h.ParseWithOptions(data, opts)
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, len(data)-2, n)
+	assert.Equal(t, []string{"first second"}, h["x-long"])
+
+	// Test: Unfolding waits for the start of the next line
+	h = NewHeaders()
+	n, done, err = h.ParseWithOptions([]byte("X-Long: first\r\n"), opts)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+}
+
+func TestHeaderCasing(t *testing.T) {
+	// Test: Set and Get are case-insensitive
+	h := NewHeaders()
+	h.Set("Content-Length", "10")
+	h.Set("content-length", "11")
+	value, err := h.Get("CONTENT-LENGTH")
+	require.NoError(t, err)
+	assert.Equal(t, "10,11", value)
+	h.SetOVR("CONTENT-length", "12")
+	assert.Equal(t, []string{"12"}, h["content-length"])
+	require.NoError(t, h.Delete("Content-Length"))
+	require.Error(t, h.Delete("Content-Length"))
+	assert.Empty(t, h)
+
+	// Test: Canonical casing
+	assert.Equal(t, "Content-Length", CanonicalKey("content-length"))
+	assert.Equal(t, "Transfer-Encoding", CanonicalKey("TRANSFER-ENCODING"))
+	assert.Equal(t, "X-Content-Sha256", CanonicalKey("x-content-sha256"))
+	assert.Equal(t, "ETag", CanonicalKey("etag"))
+	assert.Equal(t, "WWW-Authenticate", CanonicalKey("www-authenticate"))
+
+	// Test: Override for nonstandard casing, only where asked
+	h = NewHeaders()
+	h.Set("x-api-key", "k")
+	buf := &bytes.Buffer{}
+	require.NoError(t, h.WriteWithCasing(buf, map[string]string{"x-api-key": "X-API-KEY"}))
+	assert.Equal(t, "X-API-KEY: k\r\n\r\n", buf.String())
+	assert.Equal(t, "X-Api-Key", CanonicalKey("x-api-key"))
+}
+
+func TestSetCookieLines(t *testing.T) {
+	h := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\n")
+	n, _, err := h.Parse(data)
+	require.NoError(t, err)
+	_, _, err = h.Parse(data[n:])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, h.FieldLines("Set-Cookie"))
+
+	// Test: Each cookie is a value of its own, none has a line break in it
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, h["set-cookie"])
+	value, err := h.Get("Set-Cookie")
+	require.NoError(t, err)
+	assert.Equal(t, "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", value)
+	buf := &bytes.Buffer{}
+	require.NoError(t, h.Write(buf))
+	assert.Equal(t, "Set-Cookie: a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\nSet-Cookie: b=2\r\n\r\n", buf.String())
+
+	// Test: A clone doesn't share the values
+	clone := h.Clone()
+	clone.Set("Set-Cookie", "c=3")
+	assert.Len(t, h.FieldLines("Set-Cookie"), 2)
+	assert.Len(t, clone.FieldLines("Set-Cookie"), 3)
+
+	h.Set("Vary", "Accept")
+	h.Set("Vary", "Origin")
+	assert.Equal(t, []string{"Accept,Origin"}, h.FieldLines("vary"))
+	assert.Equal(t, []string{}, h.FieldLines("missing"))
+}
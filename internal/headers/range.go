@@ -0,0 +1,124 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Range requests (RFC 9110 14):
+//
+//	Range           = ranges-specifier
+//	ranges-specifier = range-unit "=" range-set
+//	range-set       = 1#range-spec
+//	range-spec      = int-range / suffix-range
+//	int-range       = first-pos "-" [ last-pos ]
+//	suffix-range    = "-" suffix-length
+
+// ErrRangeNotSatisfiable is returned by ParseRange when the Range is valid
+// but none of its ranges overlaps the representation.
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// maxRanges caps how many ranges a request can ask for, a long list of
+// tiny ranges costs the server far more than the client.
+const maxRanges = 64
+
+// ByteRange is Length bytes starting at Start.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange is the Content-Range value of r in a representation of size
+// bytes, e.g. "bytes 0-499/1234".
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// UnsatisfiedRange is the Content-Range value sent with a 416.
+func UnsatisfiedRange(size int64) string {
+	return fmt.Sprintf("bytes */%d", size)
+}
+
+// ParseRange resolves a bytes Range value against a representation of size
+// bytes. Last positions past the end are cut to it, ranges starting past
+// the end are dropped, and ErrRangeNotSatisfiable is returned when none is
+// left. Any other error means the field should be ignored: another unit,
+// bad syntax, or a list that asks for more than the whole representation.
+func ParseRange(v string, size int64) ([]ByteRange, error) {
+	unit, set, found := strings.Cut(v, "=")
+	if !found || !strings.EqualFold(strings.Trim(unit, " \t"), "bytes") {
+		return nil, fmt.Errorf("unsupported range unit: %q", v)
+	}
+	specs := ParseList(set)
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("empty range set: %q", v)
+	}
+	if len(specs) > maxRanges {
+		return nil, fmt.Errorf("too many ranges: %d", len(specs))
+	}
+
+	ranges := []ByteRange{}
+	var total int64
+	for _, spec := range specs {
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid range: %q", spec)
+		}
+		var r ByteRange
+		if first == "" {
+			// suffix-range, the last n bytes
+			n, err := parsePos(last)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			r = ByteRange{Start: size - n, Length: n}
+		} else {
+			start, err := parsePos(first)
+			if err != nil {
+				return nil, err
+			}
+			end := size - 1
+			if last != "" {
+				end, err = parsePos(last)
+				if err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, fmt.Errorf("invalid range: %q", spec)
+				}
+				end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+			r = ByteRange{Start: start, Length: end - start + 1}
+		}
+		total += r.Length
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, ErrRangeNotSatisfiable
+	}
+	// overlapping ranges can ask for the same bytes over and over
+	if len(ranges) > 1 && total > size {
+		return nil, fmt.Errorf("ranges ask for %d bytes out of %d", total, size)
+	}
+	return ranges, nil
+}
+
+func parsePos(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid range position: %q", s)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range position: %q", s)
+	}
+	return n, nil
+}
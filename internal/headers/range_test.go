@@ -0,0 +1,69 @@
+package headers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeParsing(t *testing.T) {
+	// Test: First and last position, both included
+	ranges, err := ParseRange("bytes=0-499", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 500}}, ranges)
+	assert.Equal(t, "bytes 0-499/1000", ranges[0].ContentRange(1000))
+
+	// Test: Open ended, suffix and a last position past the end
+	ranges, err = ParseRange("bytes=900-", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 900, Length: 100}}, ranges)
+	ranges, err = ParseRange("bytes=-100", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 900, Length: 100}}, ranges)
+	ranges, err = ParseRange("bytes=-5000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 1000}}, ranges)
+	ranges, err = ParseRange("bytes=990-2000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 990, Length: 10}}, ranges)
+
+	// Test: Several ranges, with spaces and the unit in any case
+	ranges, err = ParseRange("Bytes=0-0, 10-19 ,-1", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 1}, {10, 10}, {999, 1}}, ranges)
+
+	// Test: Ranges past the end are dropped, with none left it's unsatisfiable
+	ranges, err = ParseRange("bytes=0-9, 5000-6000", 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{0, 10}}, ranges)
+	for _, v := range []string{"bytes=1000-", "bytes=5000-6000", "bytes=-0"} {
+		_, err = ParseRange(v, 1000)
+		assert.True(t, errors.Is(err, ErrRangeNotSatisfiable), v)
+	}
+	_, err = ParseRange("bytes=-10", 0)
+	assert.True(t, errors.Is(err, ErrRangeNotSatisfiable))
+
+	// Test: Invalid fields are errors to ignore, not unsatisfiable
+	for _, v := range []string{
+		"items=0-5",
+		"bytes",
+		"bytes=",
+		"bytes=5",
+		"bytes=10-5",
+		"bytes=a-5",
+		"bytes=-+5",
+		"bytes=0x10-",
+		"bytes=99999999999999999999-",
+		// overlapping ranges asking for more than the whole thing
+		"bytes=0-999, 0-999",
+	} {
+		_, err = ParseRange(v, 1000)
+		require.Error(t, err, v)
+		assert.False(t, errors.Is(err, ErrRangeNotSatisfiable), v)
+	}
+
+	// Test: Unsatisfied Content-Range
+	assert.Equal(t, "bytes */1000", UnsatisfiedRange(1000))
+}
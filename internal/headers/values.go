@@ -0,0 +1,360 @@
+package headers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parsing helpers for field values, following the RFC 9110 grammar:
+//
+//	token         = 1*tchar
+//	quoted-string = DQUOTE *( qdtext / quoted-pair ) DQUOTE
+//	parameters    = *( OWS ";" OWS [ parameter ] )
+//	parameter     = token "=" ( token / quoted-string )
+
+// TimeFormat is the IMF-fixdate layout, the preferred format for dates in
+// fields such as Date, Last-Modified and Expires.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// the obsolete date formats recipients still have to accept
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// AcceptEntry is one element of an Accept style list, e.g. "text/html;q=0.8".
+type AcceptEntry struct {
+	Value  string
+	Params map[string]string
+	Q      float64
+}
+
+// CacheControl maps lower cased directive names to their (unquoted)
+// arguments. Directives without an argument map to "".
+type CacheControl map[string]string
+
+// IsToken reports whether s is a non-empty token.
+func IsToken(s string) bool {
+	return len(s) > 0 && Validate(s)
+}
+
+// Quote returns s as is when it is a token, and as a quoted-string otherwise.
+func Quote(s string) string {
+	if IsToken(s) {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Unquote returns the content of a quoted-string with quoted-pairs resolved.
+// Tokens are returned unchanged.
+func Unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		if !IsToken(s) {
+			return "", fmt.Errorf("invalid token: %q", s)
+		}
+		return s, nil
+	}
+	value, rest, err := consumeQuoted(s)
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		return "", fmt.Errorf("trailing data after quoted-string: %q", rest)
+	}
+	return value, nil
+}
+
+// ParseList splits a comma separated field value into its elements. Empty
+// elements are dropped and commas inside quoted-strings are kept.
+func ParseList(v string) []string {
+	elements := []string{}
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(v); i++ {
+		switch {
+		case inQuotes && v[i] == '\\':
+			i++
+		case v[i] == '"':
+			inQuotes = !inQuotes
+		case !inQuotes && v[i] == ',':
+			if e := strings.Trim(v[start:i], " \t"); e != "" {
+				elements = append(elements, e)
+			}
+			start = i + 1
+		}
+	}
+	if e := strings.Trim(v[start:], " \t"); e != "" {
+		elements = append(elements, e)
+	}
+	return elements
+}
+
+// ParseMediaType parses a Content-Type style value such as
+// `text/html; charset="utf-8"`. The type and parameter names are lower cased.
+func ParseMediaType(v string) (string, map[string]string, error) {
+	v = skipOWS(v)
+	mainType, rest := consumeToken(v)
+	if mainType == "" || !strings.HasPrefix(rest, "/") {
+		return "", nil, fmt.Errorf("invalid media type: %q", v)
+	}
+	subType, rest := consumeToken(rest[1:])
+	if subType == "" {
+		return "", nil, fmt.Errorf("invalid media type: %q", v)
+	}
+	params, err := parseParams(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.ToLower(mainType + "/" + subType), params, nil
+}
+
+// ParseAccept parses an Accept, Accept-Encoding, Accept-Language or
+// Accept-Charset value. Entries are sorted by descending q-value, keeping
+// the original order for equal weights.
+func ParseAccept(v string) ([]AcceptEntry, error) {
+	entries := []AcceptEntry{}
+	for _, element := range ParseList(v) {
+		value, rest := consumeToken(element)
+		// media ranges are the only values with a slash in them
+		if strings.HasPrefix(rest, "/") {
+			subType, r := consumeToken(rest[1:])
+			if subType == "" {
+				return nil, fmt.Errorf("invalid media range: %q", element)
+			}
+			value, rest = value+"/"+subType, r
+		}
+		if value == "" {
+			return nil, fmt.Errorf("invalid accept element: %q", element)
+		}
+		params, err := parseParams(rest)
+		if err != nil {
+			return nil, err
+		}
+		q := 1.0
+		if qValue, ok := params["q"]; ok {
+			q, err = parseQValue(qValue)
+			if err != nil {
+				return nil, err
+			}
+			delete(params, "q")
+		}
+		entries = append(entries, AcceptEntry{
+			Value:  strings.ToLower(value),
+			Params: params,
+			Q:      q,
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Q > entries[j].Q
+	})
+	return entries, nil
+}
+
+// ParseCacheControl parses a Cache-Control value such as
+// `max-age=60, no-cache="Set-Cookie", private`.
+func ParseCacheControl(v string) (CacheControl, error) {
+	cc := CacheControl{}
+	for _, element := range ParseList(v) {
+		name, rest := consumeToken(element)
+		if name == "" {
+			return nil, fmt.Errorf("invalid cache directive: %q", element)
+		}
+		value := ""
+		rest = skipOWS(rest)
+		if strings.HasPrefix(rest, "=") {
+			var err error
+			value, err = Unquote(skipOWS(rest[1:]))
+			if err != nil {
+				return nil, err
+			}
+		} else if rest != "" {
+			return nil, fmt.Errorf("invalid cache directive: %q", element)
+		}
+		cc[strings.ToLower(name)] = value
+	}
+	return cc, nil
+}
+
+// Has reports whether the directive is present.
+func (c CacheControl) Has(directive string) bool {
+	_, ok := c[strings.ToLower(directive)]
+	return ok
+}
+
+// Seconds returns the delta-seconds argument of a directive like max-age.
+func (c CacheControl) Seconds(directive string) (int, bool) {
+	value, ok := c[strings.ToLower(directive)]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return seconds, true
+}
+
+// ParseTime parses an HTTP-date in IMF-fixdate, RFC 850 or asctime format.
+func ParseTime(v string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		t, err := time.Parse(layout, v)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid HTTP-date: %q", v)
+}
+
+// FormatTime formats t as an IMF-fixdate.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ContentType returns the parsed Content-Type of h.
+func (h Headers) ContentType() (string, map[string]string, error) {
+	v, err := h.Get("Content-Type")
+	if err != nil {
+		return "", nil, err
+	}
+	return ParseMediaType(v)
+}
+
+// Accept returns the parsed Accept list of h, or nil when it is missing.
+func (h Headers) Accept() ([]AcceptEntry, error) {
+	v, err := h.Get("Accept")
+	if err != nil {
+		return nil, nil
+	}
+	return ParseAccept(v)
+}
+
+// CacheControl returns the parsed Cache-Control directives of h.
+func (h Headers) CacheControl() (CacheControl, error) {
+	v, err := h.Get("Cache-Control")
+	if err != nil {
+		return CacheControl{}, nil
+	}
+	return ParseCacheControl(v)
+}
+
+// Values returns the comma separated elements of the key header.
+func (h Headers) Values(key string) []string {
+	v, err := h.Get(key)
+	if err != nil {
+		return []string{}
+	}
+	return ParseList(v)
+}
+
+// Time returns the key header parsed as an HTTP-date.
+func (h Headers) Time(key string) (time.Time, error) {
+	v, err := h.Get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ParseTime(v)
+}
+
+func parseParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for {
+		s = skipOWS(s)
+		if s == "" {
+			return params, nil
+		}
+		if s[0] != ';' {
+			return nil, fmt.Errorf("expected ';' before parameter: %q", s)
+		}
+		s = skipOWS(s[1:])
+		if s == "" || s[0] == ';' {
+			// empty parameters are allowed by the grammar
+			continue
+		}
+		name, rest := consumeToken(s)
+		if name == "" || !strings.HasPrefix(rest, "=") {
+			return nil, fmt.Errorf("invalid parameter: %q", s)
+		}
+		rest = rest[1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var err error
+			value, rest, err = consumeQuoted(rest)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			value, rest = consumeToken(rest)
+			if value == "" {
+				return nil, fmt.Errorf("invalid parameter value: %q", s)
+			}
+		}
+		params[strings.ToLower(name)] = value
+		s = rest
+	}
+}
+
+// parseQValue parses a weight: "0" or "1" with at most three decimals.
+func parseQValue(s string) (float64, error) {
+	valid := len(s) > 0 && len(s) <= 5 && (s[0] == '0' || s[0] == '1')
+	if valid && len(s) > 1 {
+		valid = s[1] == '.'
+		for i := 2; valid && i < len(s); i++ {
+			valid = s[i] >= '0' && s[i] <= '9' && (s[0] == '0' || s[i] == '0')
+		}
+	}
+	if !valid {
+		return 0, fmt.Errorf("invalid q-value: %q", s)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func consumeToken(s string) (string, string) {
+	i := 0
+	for i < len(s) && Validate(s[i:i+1]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func consumeQuoted(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", s, errors.New("missing opening quote")
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), s[i+1:], nil
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return "", s, errors.New("unterminated quoted-pair")
+			}
+			b.WriteByte(s[i])
+		case c == '\t' || (c >= 0x20 && c != 0x7f):
+			b.WriteByte(c)
+		default:
+			return "", s, fmt.Errorf("invalid character in quoted-string: %q", c)
+		}
+	}
+	return "", s, errors.New("unterminated quoted-string")
+}
+
+func skipOWS(s string) string {
+	return strings.TrimLeft(s, " \t")
+}
@@ -0,0 +1,115 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaTypeParsing(t *testing.T) {
+	// Test: Type with a token parameter
+	mediaType, params, err := ParseMediaType("Text/HTML; Charset=utf-8")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", mediaType)
+	assert.Equal(t, "utf-8", params["charset"])
+
+	// Test: Quoted-string parameter with an escaped quote and a semicolon
+	_, params, err = ParseMediaType(`multipart/form-data ; boundary="a\"b;c"`)
+	require.NoError(t, err)
+	assert.Equal(t, `a"b;c`, params["boundary"])
+
+	// Test: Missing subtype
+	_, _, err = ParseMediaType("text")
+	require.Error(t, err)
+
+	// Test: Unterminated quoted-string
+	_, _, err = ParseMediaType(`text/plain; charset="utf-8`)
+	require.Error(t, err)
+
+	// Test: Accessor on Headers
+	h := NewHeaders()
+	h.Set("content-type", "application/json")
+	mediaType, _, err = h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "application/json", mediaType)
+}
+
+func TestAcceptParsing(t *testing.T) {
+	// Test: Sorted by q-value, stable for equal weights
+	entries, err := ParseAccept("text/html;q=0.8, application/json, */*;q=0.1, text/plain")
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, "application/json", entries[0].Value)
+	assert.Equal(t, "text/plain", entries[1].Value)
+	assert.Equal(t, "text/html", entries[2].Value)
+	assert.Equal(t, 0.8, entries[2].Q)
+	assert.Equal(t, "*/*", entries[3].Value)
+
+	// Test: Token lists such as Accept-Encoding
+	entries, err = ParseAccept("gzip;q=1.0, identity; q=0.5, *;q=0")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "gzip", entries[0].Value)
+	assert.Equal(t, "*", entries[2].Value)
+	assert.Equal(t, 0.0, entries[2].Q)
+
+	// Test: Invalid q-values
+	_, err = ParseAccept("gzip;q=2")
+	require.Error(t, err)
+	_, err = ParseAccept("gzip;q=0.1234")
+	require.Error(t, err)
+	_, err = ParseAccept("gzip;q=1.5")
+	require.Error(t, err)
+}
+
+func TestListParsing(t *testing.T) {
+	assert.Equal(t, []string{"gzip", "chunked"}, ParseList("gzip, chunked"))
+	assert.Equal(t, []string{"a", "b"}, ParseList(" ,a,, b ,"))
+	assert.Equal(t, []string{`"x, y"`, "z"}, ParseList(`"x, y", z`))
+	assert.Equal(t, []string{}, ParseList(""))
+}
+
+func TestCacheControlParsing(t *testing.T) {
+	cc, err := ParseCacheControl(`Max-Age=60, no-cache="Set-Cookie", private`)
+	require.NoError(t, err)
+	assert.True(t, cc.Has("private"))
+	assert.True(t, cc.Has("no-cache"))
+	assert.Equal(t, "Set-Cookie", cc["no-cache"])
+	seconds, ok := cc.Seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, 60, seconds)
+	_, ok = cc.Seconds("s-maxage")
+	assert.False(t, ok)
+
+	_, err = ParseCacheControl("max-age 60")
+	require.Error(t, err)
+}
+
+func TestTimeParsing(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, v := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseTime(v)
+		require.NoError(t, err, v)
+		assert.True(t, want.Equal(got), v)
+	}
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatTime(want))
+
+	_, err := ParseTime("yesterday")
+	require.Error(t, err)
+}
+
+func TestQuoting(t *testing.T) {
+	assert.Equal(t, "utf-8", Quote("utf-8"))
+	assert.Equal(t, `"a \"b\""`, Quote(`a "b"`))
+	value, err := Unquote(`"a \"b\""`)
+	require.NoError(t, err)
+	assert.Equal(t, `a "b"`, value)
+	_, err = Unquote("a b")
+	require.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"MODULE_NAME/internal/headers"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+)
+
+// digestAlgorithms are the supported trailer digests, keyed by their name in
+// the HTTP digest algorithm registry (RFC 9530).
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+	"crc32c": func() hash.Hash {
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	},
+}
+
+// DefaultDigests is used for requests that match no DigestRoute.
+var DefaultDigests = []string{"sha-256"}
+
+// DigestRoute selects the trailer digests for requests whose path starts
+// with Prefix. The longest matching prefix wins.
+type DigestRoute struct {
+	Prefix     string
+	Algorithms []string
+}
+
+// digestWriter hashes everything written to it, fed a copy of the body as it
+// streams, so the digests are ready once the body is done without keeping
+// the body around.
+type digestWriter struct {
+	algorithms []string
+	hashes     []hash.Hash
+	n          int64
+}
+
+func newDigestWriter(algorithms []string) (*digestWriter, error) {
+	d := &digestWriter{algorithms: algorithms}
+	for _, algorithm := range algorithms {
+		newHash, ok := digestAlgorithms[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unsupported digest algorithm: %q", algorithm)
+		}
+		d.hashes = append(d.hashes, newHash())
+	}
+	return d, nil
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	d.n += int64(len(p))
+	return len(p), nil
+}
+
+// trailerNames lists the fields Trailers returns, for the Trailer header.
+func (d *digestWriter) trailerNames(repr bool) string {
+	names := []string{"Content-Digest"}
+	if repr {
+		names = append(names, "Repr-Digest")
+	}
+	for _, algorithm := range d.algorithms {
+		names = append(names, customDigestField(algorithm))
+	}
+	names = append(names, "X-Content-Length")
+	return strings.Join(names, ", ")
+}
+
+// ownsTrailer reports whether name is one of the trailers the proxy
+// computes, an upstream one by that name would contradict it.
+func (d *digestWriter) ownsTrailer(name string) bool {
+	for _, owned := range strings.Split(d.trailerNames(true), ", ") {
+		if strings.EqualFold(name, owned) {
+			return true
+		}
+	}
+	return false
+}
+
+// Trailers returns Content-Digest, Repr-Digest when the body is the whole
+// representation (not a 206), and the X-Content-* fields with hex digests.
+func (d *digestWriter) Trailers(repr bool) headers.Headers {
+	h := headers.NewHeaders()
+	digests := []string{}
+	for i, algorithm := range d.algorithms {
+		sum := d.hashes[i].Sum(nil)
+		digests = append(digests, fmt.Sprintf("%s=:%s:", algorithm, base64.StdEncoding.EncodeToString(sum)))
+		h.Set(customDigestField(algorithm), fmt.Sprintf("%x", sum))
+	}
+	h.Set("Content-Digest", strings.Join(digests, ", "))
+	if repr {
+		h.Set("Repr-Digest", strings.Join(digests, ", "))
+	}
+	h.Set("X-Content-Length", fmt.Sprintf("%d", d.n))
+	return h
+}
+
+// customDigestField maps "sha-256" to "X-Content-Sha256".
+func customDigestField(algorithm string) string {
+	return headers.CanonicalKey("x-content-" + strings.ReplaceAll(algorithm, "-", ""))
+}
+
+func (p *Proxy) digestsFor(path string) []string {
+	algorithms := DefaultDigests
+	longest := -1
+	for _, route := range p.Digests {
+		if strings.HasPrefix(path, route.Prefix) && len(route.Prefix) > longest {
+			algorithms = route.Algorithms
+			longest = len(route.Prefix)
+		}
+	}
+	return algorithms
+}
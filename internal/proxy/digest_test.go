@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestTrailers(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/partial" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-9/%d", len(payload)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(payload[:10])
+			return
+		}
+		if r.URL.Path == "/trailers" {
+			w.Header().Set("Trailer", "X-Checksum, Content-Digest")
+			w.Write(payload)
+			w.Header().Set("X-Checksum", "abc")
+			w.Header().Set("Content-Digest", "sha-256=:bm90IHRoZSBib2R5:")
+			w.Header().Set(http.TrailerPrefix+"X-Unannounced", "late")
+			return
+		}
+		w.Write(payload)
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+	p.Digests = []DigestRoute{
+		{Prefix: "/files", Algorithms: []string{"sha-512"}},
+		{Prefix: "/files/small", Algorithms: []string{"crc32c", "sha-256"}},
+	}
+	sha256Sum := sha256.Sum256(payload)
+	sha512Sum := sha512.Sum512(payload)
+	crcSum := crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli))
+	crcBytes := []byte{byte(crcSum >> 24), byte(crcSum >> 16), byte(crcSum >> 8), byte(crcSum)}
+
+	// Test: Default route uses sha-256
+	resp, body := proxyRequest(t, p, "GET /anything HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, payload, body)
+	announced := []string{}
+	for key := range resp.Trailer {
+		announced = append(announced, key)
+	}
+	assert.ElementsMatch(t, []string{"Content-Digest", "Repr-Digest", "X-Content-Sha256", "X-Content-Length"}, announced)
+	want := "sha-256=:" + base64.StdEncoding.EncodeToString(sha256Sum[:]) + ":"
+	assert.Equal(t, want, resp.Trailer.Get("Content-Digest"))
+	assert.Equal(t, want, resp.Trailer.Get("Repr-Digest"))
+	assert.Equal(t, fmt.Sprintf("%x", sha256Sum), resp.Trailer.Get("X-Content-Sha256"))
+	assert.Equal(t, fmt.Sprint(len(payload)), resp.Trailer.Get("X-Content-Length"))
+
+	// Test: Route prefix picks sha-512
+	resp, _ = proxyRequest(t, p, "GET /files/big.bin HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "sha-512=:"+base64.StdEncoding.EncodeToString(sha512Sum[:])+":", resp.Trailer.Get("Content-Digest"))
+	assert.Equal(t, fmt.Sprintf("%x", sha512Sum), resp.Trailer.Get("X-Content-Sha512"))
+
+	// Test: Longest prefix wins, several algorithms at once
+	resp, _ = proxyRequest(t, p, "GET /files/small/a.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, "crc32c=:"+base64.StdEncoding.EncodeToString(crcBytes)+":, "+
+		"sha-256=:"+base64.StdEncoding.EncodeToString(sha256Sum[:])+":", resp.Trailer.Get("Content-Digest"))
+	assert.Equal(t, fmt.Sprintf("%08x", crcSum), resp.Trailer.Get("X-Content-Crc32c"))
+	assert.Equal(t, fmt.Sprintf("%x", sha256Sum), resp.Trailer.Get("X-Content-Sha256"))
+
+	// Test: No Repr-Digest for a partial response
+	resp, body = proxyRequest(t, p, "GET /partial HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	partialSum := sha256.Sum256(payload[:10])
+	assert.Equal(t, payload[:10], body)
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(partialSum[:])+":", resp.Trailer.Get("Content-Digest"))
+	assert.Equal(t, "", resp.Trailer.Get("Repr-Digest"))
+
+	// Test: Upstream trailers are announced and relayed, the proxy's digests
+	// win over upstream ones by the same name
+	resp, _ = proxyRequest(t, p, "GET /trailers HTTP/1.1\r\nHost: x\r\n\r\n")
+	announced = []string{}
+	for key := range resp.Trailer {
+		announced = append(announced, key)
+	}
+	assert.ElementsMatch(t, []string{"X-Checksum", "Content-Digest", "Repr-Digest", "X-Content-Sha256", "X-Content-Length"}, announced)
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+	assert.Equal(t, want, resp.Trailer.Get("Content-Digest"))
+	assert.Equal(t, "", resp.Trailer.Get("X-Unannounced"))
+
+	// Test: Unknown algorithm
+	p.Digests = []DigestRoute{{Prefix: "/", Algorithms: []string{"md4"}}}
+	resp, _ = proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
@@ -0,0 +1,402 @@
+package proxy
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Forward is a forward proxy: clients configured to use it send CONNECT
+// for tunnels, https mostly, and absolute-form requests such as
+// "GET http://example.com/ HTTP/1.1" for plain http.
+type Forward struct {
+	// Allow, when not empty, limits the destinations to those matching one
+	// of its rules. Deny refuses destinations whatever Allow says.
+	Allow []Rule
+	Deny  []Rule
+	// Users maps user names to passwords for Basic Proxy-Authorization.
+	// Empty means no authentication.
+	Users map[string]string
+	// Realm is sent in Proxy-Authenticate, "proxy" when empty.
+	Realm string
+	// DialTimeout bounds resolving and connecting to a destination, 10
+	// seconds when 0.
+	DialTimeout time.Duration
+	// Via is the pseudonym added to the Via header of forwarded requests.
+	Via string
+}
+
+const defaultDialTimeout = 10 * time.Second
+
+// errDenied is returned by dial when the rules refuse every address.
+var errDenied = errors.New("destination not allowed")
+
+// IsForwardRequest reports whether req is for a forward proxy rather than
+// for the server itself: a CONNECT, or a request in absolute-form for an
+// http URL. Origin-form targets are the server's, even with a URL in the
+// query.
+func IsForwardRequest(req *request.Request) bool {
+	if req.RequestLine.Method == "CONNECT" {
+		return true
+	}
+	target := req.RequestLine.RequestTarget
+	if strings.HasPrefix(target, "/") {
+		return false
+	}
+	u, err := url.Parse(target)
+	return err == nil && strings.EqualFold(u.Scheme, "http") && u.Host != ""
+}
+
+// Handle has the server.Handler signature. It tunnels CONNECT requests and
+// forwards absolute-form ones, after checking the credentials and the
+// destination rules.
+func (f *Forward) Handle(w *response.Writer, req *request.Request) {
+	host, port, err := f.destination(req)
+	if err != nil {
+		writeError(w, response.StatusBadRequest)
+		return
+	}
+	if !f.authorized(req) {
+		realm := f.Realm
+		if realm == "" {
+			realm = "proxy"
+		}
+		h := response.GetDefaultHeaders(0)
+		h.Set("Proxy-Authenticate", "Basic realm="+headers.Quote(realm))
+		w.WriteStatusLine(response.StatusProxyAuthRequired)
+		w.WriteHeaders(h)
+		return
+	}
+	upstream, err := f.dial(host, port)
+	if err != nil {
+		log.Printf("proxy: %s %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, err)
+		switch {
+		case errors.Is(err, errDenied):
+			writeError(w, response.StatusForbidden)
+		case errors.Is(err, context.DeadlineExceeded):
+			writeError(w, response.StatusGatewayTimeout)
+		default:
+			writeError(w, response.StatusBadGateway)
+		}
+		return
+	}
+	if req.RequestLine.Method == "CONNECT" {
+		f.tunnel(w, upstream)
+		return
+	}
+	defer upstream.Close()
+	f.forward(w, req, upstream)
+}
+
+// destination finds the host and port to connect to: the authority-form
+// target of a CONNECT, the URL of anything else.
+func (f *Forward) destination(req *request.Request) (string, string, error) {
+	target := req.RequestLine.RequestTarget
+	if req.RequestLine.Method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return "", "", err
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 || host == "" {
+			return "", "", fmt.Errorf("invalid CONNECT target: %q", target)
+		}
+		return host, port, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+	// https goes through CONNECT, the client does the TLS itself
+	if u.Scheme != "http" || u.Hostname() == "" {
+		return "", "", fmt.Errorf("unsupported target: %q", target)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	return u.Hostname(), port, nil
+}
+
+// authorized checks Proxy-Authorization against Users (RFC 9110 11.7.2).
+func (f *Forward) authorized(req *request.Request) bool {
+	if len(f.Users) == 0 {
+		return true
+	}
+	value, err := req.Headers.Get("Proxy-Authorization")
+	if err != nil {
+		return false
+	}
+	scheme, credentials, _ := strings.Cut(value, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return false
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	want, known := f.Users[user]
+	if !ok || !known {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// dial resolves host and connects to the first address the rules allow.
+// The rules see the addresses we actually connect to, so a name can't
+// sneak past an address block.
+func (f *Forward) dial(host string, port string) (net.Conn, error) {
+	timeout := f.DialTimeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	var addrs []netip.Addr
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{addr}
+	} else {
+		addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var dialer net.Dialer
+	err := errDenied
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if !f.allowed(host, port, addr) {
+			continue
+		}
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("%s:%s: %w", host, port, err)
+}
+
+// allowed applies Deny, then Allow, to one address of host.
+func (f *Forward) allowed(host string, port string, addr netip.Addr) bool {
+	for _, rule := range f.Deny {
+		if rule.match(host, port, addr) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, rule := range f.Allow {
+		if rule.match(host, port, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// tunnel answers the CONNECT and relays bytes both ways until both sides
+// are done. Each direction is closed on its own when it ends, so a client
+// that finished sending still gets the whole answer.
+func (f *Forward) tunnel(w *response.Writer, upstream net.Conn) {
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		writeError(w, response.StatusInternalError)
+		return
+	}
+	defer conn.Close()
+	defer upstream.Close()
+	// a 2xx to CONNECT has no content and no framing (RFC 9110 9.3.6)
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+	// e.g. a TLS ClientHello sent without waiting for the answer
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			return
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		pipe(upstream, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		pipe(conn, upstream)
+	}()
+	wg.Wait()
+}
+
+// pipe copies src to dst, then closes dst for writing so its reader sees
+// the end.
+func pipe(dst net.Conn, src net.Conn) {
+	io.Copy(dst, src)
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	dst.Close()
+}
+
+// forward sends req to upstream in origin-form and relays the answer.
+func (f *Forward) forward(w *response.Writer, req *request.Request, upstream net.Conn) {
+	body, err := req.ReadBody()
+	if err != nil {
+		writeError(w, response.StatusBadRequest)
+		return
+	}
+	u, _ := url.Parse(req.RequestLine.RequestTarget)
+	out := &request.Request{
+		RequestLine: request.RequestLine{
+			Method:        req.RequestLine.Method,
+			RequestTarget: u.RequestURI(),
+			HttpVersion:   "1.1",
+		},
+		Headers: req.Headers.Clone(),
+		Body:    body,
+	}
+	removeHopByHop(out.Headers)
+	out.Headers.SetOVR("Host", u.Host)
+	out.Headers.SetOVR("Connection", "close")
+	out.Headers.Delete("Content-Length")
+	if len(body) > 0 {
+		out.Headers.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	out.Headers.Set("Via", "1.1 "+f.via())
+	if err := out.Write(upstream); err != nil {
+		writeError(w, response.StatusBadGateway)
+		return
+	}
+
+	method := req.RequestLine.Method
+	resp, err := response.ResponseHeadersFromReader(upstream, method)
+	if err != nil {
+		log.Printf("proxy: error reading the answer to %s %s: %v", method, req.RequestLine.RequestTarget, err)
+		writeError(w, response.StatusBadGateway)
+		return
+	}
+	h := resp.Headers
+	removeHopByHop(h)
+	h.Set("Via", resp.StatusLine.HttpVersion+" "+f.via())
+	h.SetOVR("Connection", "close")
+	for _, interim := range resp.Interim {
+		if interim.StatusLine.StatusCode != response.StatusContinue {
+			removeHopByHop(interim.Headers)
+			w.WriteInformational(interim.StatusLine.StatusCode, interim.Headers)
+		}
+	}
+	if !response.BodyAllowed(method, resp.StatusLine.StatusCode) {
+		w.WriteStatusLine(resp.StatusLine.StatusCode)
+		w.WriteHeaders(h)
+		return
+	}
+	// the body is re-framed as chunked, whatever upstream used
+	h.Delete("Content-Length")
+	h.SetOVR("Transfer-Encoding", "chunked")
+	w.WriteStatusLine(resp.StatusLine.StatusCode)
+	w.WriteHeaders(h)
+	trailers := func() headers.Headers { return resp.Trailers }
+	if err := w.WriteChunkedFrom(resp.BodyReader(), trailers); err != nil {
+		log.Printf("proxy: error streaming the answer to %s %s: %v", method, req.RequestLine.RequestTarget, err)
+	}
+}
+
+func (f *Forward) via() string {
+	if f.Via == "" {
+		return "http-in-go"
+	}
+	return f.Via
+}
+
+// Rule matches destinations of a Forward proxy, see ParseRule.
+type Rule struct {
+	host   string
+	port   string
+	prefix netip.Prefix
+}
+
+// ParseRule parses a destination rule: "example.com" for that host,
+// "*.example.com" for its subdomains, "10.0.0.0/8" for the addresses of a
+// block, "*" for any host. Each takes an optional ":port", and ":443"
+// alone matches any host on that port. IPv6 addresses with a port go in
+// brackets: "[::1]:8080".
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		host, port = s, ""
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return Rule{}, fmt.Errorf("invalid port in rule %q", s)
+		}
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" && port == "" {
+		return Rule{}, fmt.Errorf("empty rule")
+	}
+	rule := Rule{host: host, port: port}
+	if strings.Contains(host, "/") {
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid address block in rule %q: %w", s, err)
+		}
+		rule.prefix = prefix.Masked()
+	} else if addr, err := netip.ParseAddr(host); err == nil {
+		// a single address is a block of one
+		rule.prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	return rule, nil
+}
+
+// ParseRules parses a comma separated list of rules.
+func ParseRules(s string) ([]Rule, error) {
+	rules := []Rule{}
+	for _, element := range strings.Split(s, ",") {
+		if strings.TrimSpace(element) == "" {
+			continue
+		}
+		rule, err := ParseRule(element)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// match reports whether the rule covers host:port, connected to at addr.
+func (r Rule) match(host string, port string, addr netip.Addr) bool {
+	if r.port != "" && r.port != port {
+		return false
+	}
+	switch {
+	case r.host == "" || r.host == "*":
+		return true
+	case r.prefix.IsValid():
+		return r.prefix.Contains(addr)
+	case strings.HasPrefix(r.host, "*."):
+		return strings.HasSuffix(host, r.host[1:])
+	}
+	return host == r.host
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveForward runs f on a listener the way server.Serve would and returns
+// its address.
+func serveForward(t *testing.T, f *Forward) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				w := &response.Writer{ResWriter: conn}
+				w.AllowHijack(conn, req.Buffered)
+				f.Handle(w, req)
+				if !w.Hijacked() {
+					conn.Close()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// shouter reads everything until the client is done sending, then answers
+// with it upper cased: it only works if the tunnel passes the half-close.
+func shouter(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, _ := io.ReadAll(conn)
+				conn.Write([]byte(strings.ToUpper(string(data))))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// connect sends a CONNECT for target with the extra fields and returns the
+// connection and the response.
+func connect(t *testing.T, proxyAddr string, target string, extra string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n%s\r\n", target, target, extra)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, &http.Request{Method: "CONNECT"})
+	require.NoError(t, err)
+	return conn, r, resp
+}
+
+func basicAuth(user string, password string) string {
+	return "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)) + "\r\n"
+}
+
+func TestForwardConnect(t *testing.T) {
+	target := shouter(t)
+	addr := serveForward(t, &Forward{})
+
+	// Test: The tunnel carries bytes both ways and passes the half-close
+	conn, r, resp := connect(t, addr, target, "")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "200 Connection Established", resp.Status)
+	conn.Write([]byte("hello through "))
+	conn.Write([]byte("the tunnel"))
+	conn.(*net.TCPConn).CloseWrite()
+	answer, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO THROUGH THE TUNNEL", string(answer))
+
+	// Test: Bytes sent along with the CONNECT aren't lost
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\neager", target, target)
+	conn.(*net.TCPConn).CloseWrite()
+	all, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n\r\nEAGER", string(all))
+
+	// Test: Targets that aren't host:port
+	for _, bad := range []string{"example.com", "example.com:http", ":443", "example.com:70000"} {
+		_, _, resp = connect(t, addr, bad, "")
+		assert.Equal(t, 400, resp.StatusCode, bad)
+	}
+
+	// Test: Nothing listening
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	_, _, resp = connect(t, addr, closedAddr, "")
+	assert.Equal(t, 502, resp.StatusCode)
+}
+
+func TestForwardAuth(t *testing.T) {
+	target := shouter(t)
+	addr := serveForward(t, &Forward{Users: map[string]string{"dev": "s3cret"}, Realm: "dev proxy"})
+
+	// Test: Missing, wrong and unsupported credentials
+	for _, extra := range []string{"", basicAuth("dev", "wrong"), basicAuth("other", "s3cret"),
+		"Proxy-Authorization: Bearer s3cret\r\n", "Proxy-Authorization: Basic !!!\r\n"} {
+		_, _, resp := connect(t, addr, target, extra)
+		assert.Equal(t, 407, resp.StatusCode, extra)
+		assert.Equal(t, `Basic realm="dev proxy"`, resp.Header.Get("Proxy-Authenticate"), extra)
+	}
+
+	// Test: The right ones open the tunnel
+	_, _, resp := connect(t, addr, target, basicAuth("dev", "s3cret"))
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestForwardRules(t *testing.T) {
+	target := shouter(t)
+	_, port, _ := net.SplitHostPort(target)
+	localhost := "localhost:" + port
+
+	tests := []struct {
+		name   string
+		allow  string
+		deny   string
+		target string
+		status int
+	}{
+		{"no rules", "", "", localhost, 200},
+		{"denied block, reached by name", "", "127.0.0.0/8", localhost, 403},
+		{"denied port", "", ":" + port, target, 403},
+		{"denied host", "", "localhost", localhost, 403},
+		{"allowed host", "localhost", "", localhost, 200},
+		{"allowed host only", "localhost", "", target, 403},
+		{"allowed port", ":" + port, "", target, 200},
+		{"allowed block", "127.0.0.1/32:" + port, "", localhost, 200},
+		{"allowed elsewhere", "*.example.com, 10.0.0.0/8", "", target, 403},
+		{"deny wins", "*", "127.0.0.1", target, 403},
+	}
+	for _, tt := range tests {
+		// Test: Allow and deny lists on names, ports and resolved addresses
+		allow, err := ParseRules(tt.allow)
+		require.NoError(t, err, tt.name)
+		deny, err := ParseRules(tt.deny)
+		require.NoError(t, err, tt.name)
+		addr := serveForward(t, &Forward{Allow: allow, Deny: deny})
+		_, _, resp := connect(t, addr, tt.target, "")
+		assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	addr := netip.MustParseAddr
+	tests := []struct {
+		rule  string
+		host  string
+		port  string
+		addr  netip.Addr
+		match bool
+	}{
+		{"example.com", "example.com", "443", addr("93.184.216.34"), true},
+		{"Example.COM.", "example.com", "80", addr("93.184.216.34"), true},
+		{"example.com", "www.example.com", "443", addr("93.184.216.34"), false},
+		{"*.example.com", "www.example.com", "443", addr("93.184.216.34"), true},
+		{"*.example.com", "example.com", "443", addr("93.184.216.34"), false},
+		{"example.com:443", "example.com", "80", addr("93.184.216.34"), false},
+		{":22", "anything", "22", addr("10.1.2.3"), true},
+		{"10.0.0.0/8", "internal.corp", "443", addr("10.1.2.3"), true},
+		{"10.0.0.0/8:22", "internal.corp", "443", addr("10.1.2.3"), false},
+		{"fd00::/8", "v6.corp", "443", addr("fd12::1"), true},
+		{"[::1]:8080", "::1", "8080", addr("::1"), true},
+		{"*", "anything", "1", addr("1.1.1.1"), true},
+	}
+	for _, tt := range tests {
+		// Test: What each form of rule covers
+		rule, err := ParseRule(tt.rule)
+		require.NoError(t, err, tt.rule)
+		assert.Equal(t, tt.match, rule.match(tt.host, tt.port, tt.addr), "%s on %s:%s", tt.rule, tt.host, tt.port)
+	}
+
+	// Test: Invalid rules
+	for _, bad := range []string{"", "example.com:0", "example.com:ssh", "10.0.0.0/33"} {
+		_, err := ParseRule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestForwardAbsoluteForm(t *testing.T) {
+	var seen *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.RequestURI(), body)
+	}))
+	defer upstream.Close()
+	addr := serveForward(t, &Forward{Users: map[string]string{"dev": "pw"}})
+
+	// Test: The request goes out in origin-form without the proxy fields
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	fmt.Fprintf(conn, "POST %s/submit?x=1 HTTP/1.1\r\nHost: %s\r\n%sProxy-Connection: keep-alive\r\nContent-Length: 4\r\n\r\ndata",
+		upstream.URL, host, basicAuth("dev", "pw"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "POST /submit?x=1 data", string(body))
+	assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	assert.Empty(t, resp.Header.Get("Keep-Alive"))
+	assert.Contains(t, resp.Header.Get("Via"), "http-in-go")
+	require.NotNil(t, seen)
+	assert.Equal(t, host, seen.Host)
+	assert.Empty(t, seen.Header.Get("Proxy-Authorization"))
+	assert.Empty(t, seen.Header.Get("Proxy-Connection"))
+	assert.Equal(t, "1.1 http-in-go", seen.Header.Get("Via"))
+
+	// Test: Absolute https URLs belong in a CONNECT, they aren't forwarded
+	req, err := request.RequestFromReader(strings.NewReader("GET https://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, IsForwardRequest(req))
+	out := &strings.Builder{}
+	(&Forward{}).Handle(&response.Writer{ResWriter: out}, req)
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 400 "))
+
+	// Test: Origin-form requests aren't for the forward proxy
+	req, err = request.RequestFromReader(strings.NewReader("GET /local HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, IsForwardRequest(req))
+
+	// Test: Nor are those with a URL in the query
+	req, err = request.RequestFromReader(strings.NewReader("GET /login?next=http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, IsForwardRequest(req))
+}
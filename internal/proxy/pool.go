@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"MODULE_NAME/internal/client"
+	"MODULE_NAME/internal/request"
+	"fmt"
+	"hash/crc32"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Strategy int
+
+const (
+	RoundRobin Strategy = iota
+	LeastConnections
+	// ConsistentHash sends requests with the same HashHeader value to the
+	// same backend, and only remaps a small share of keys when a backend
+	// goes away.
+	ConsistentHash
+)
+
+// virtual nodes per backend on the hash ring, more spreads keys more evenly
+const ringReplicas = 100
+
+// Backend is one upstream server in a Pool.
+type Backend struct {
+	URL *url.URL
+
+	// unhealthy is set by active health checks
+	unhealthy atomic.Bool
+	// active counts requests in flight, for LeastConnections
+	active atomic.Int64
+	// fails counts consecutive errors, ejectedUntil is when a passively
+	// ejected backend comes back (unix nanoseconds)
+	fails        atomic.Int64
+	ejectedUntil atomic.Int64
+}
+
+// Available reports whether the backend is in rotation.
+func (b *Backend) Available() bool {
+	return !b.unhealthy.Load() && time.Now().UnixNano() >= b.ejectedUntil.Load()
+}
+
+// ActiveRequests is the number of requests currently sent to the backend.
+func (b *Backend) ActiveRequests() int64 {
+	return b.active.Load()
+}
+
+type ringEntry struct {
+	hash    uint32
+	backend *Backend
+}
+
+// Pool spreads requests over a set of backends.
+type Pool struct {
+	Backends []*Backend
+	Strategy Strategy
+	// HashHeader is the field hashed by ConsistentHash. Requests without it
+	// fall back to round-robin.
+	HashHeader string
+	// MaxFails consecutive errors eject a backend for EjectFor. Zero
+	// disables passive ejection.
+	MaxFails int
+	EjectFor time.Duration
+
+	next     atomic.Uint64
+	ringOnce sync.Once
+	ring     []ringEntry
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewPool(upstreams ...string) (*Pool, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("pool needs at least one upstream")
+	}
+	pool := &Pool{
+		MaxFails: 3,
+		EjectFor: 30 * time.Second,
+	}
+	for _, upstream := range upstreams {
+		u, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		pool.Backends = append(pool.Backends, &Backend{URL: u})
+	}
+	return pool, nil
+}
+
+// Pick returns the backend for req, skipping unavailable ones and the ones in
+// exclude (already tried). It returns nil when nothing is left.
+func (p *Pool) Pick(req *request.Request, exclude map[*Backend]bool) *Backend {
+	usable := func(b *Backend) bool {
+		return b.Available() && !exclude[b]
+	}
+	switch p.Strategy {
+	case LeastConnections:
+		var best *Backend
+		start := int(p.next.Add(1))
+		// start from a rotating offset so ties are spread round-robin
+		for i := range p.Backends {
+			b := p.Backends[(start+i)%len(p.Backends)]
+			if usable(b) && (best == nil || b.ActiveRequests() < best.ActiveRequests()) {
+				best = b
+			}
+		}
+		return best
+	case ConsistentHash:
+		if key, err := req.Headers.Get(p.HashHeader); err == nil && p.HashHeader != "" {
+			return p.pickHashed(key, usable)
+		}
+	}
+	start := int(p.next.Add(1) - 1)
+	for i := range p.Backends {
+		b := p.Backends[(start+i)%len(p.Backends)]
+		if usable(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+func (p *Pool) pickHashed(key string, usable func(*Backend) bool) *Backend {
+	p.ringOnce.Do(p.buildRing)
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= h
+	})
+	// walk clockwise until a usable backend shows up
+	for n := 0; n < len(p.ring); n++ {
+		entry := p.ring[(i+n)%len(p.ring)]
+		if usable(entry.backend) {
+			return entry.backend
+		}
+	}
+	return nil
+}
+
+func (p *Pool) buildRing() {
+	for _, b := range p.Backends {
+		for i := 0; i < ringReplicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(b.URL.String() + "#" + strconv.Itoa(i)))
+			p.ring = append(p.ring, ringEntry{hash: h, backend: b})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+}
+
+// markFailure records an error talking to b and ejects it after MaxFails in
+// a row.
+func (p *Pool) markFailure(b *Backend) {
+	fails := b.fails.Add(1)
+	if p.MaxFails > 0 && fails >= int64(p.MaxFails) {
+		b.ejectedUntil.Store(time.Now().Add(p.EjectFor).UnixNano())
+		b.fails.Store(0)
+	}
+}
+
+func (p *Pool) markSuccess(b *Backend) {
+	b.fails.Store(0)
+}
+
+// StartHealthChecks polls path on every backend each interval. Backends that
+// fail to answer with a 2xx or 3xx within timeout are taken out of rotation
+// until they pass again. Stop ends the checks.
+func (p *Pool) StartHealthChecks(path string, interval time.Duration, timeout time.Duration) {
+	c := &client.Client{Timeout: timeout, MaxRedirects: -1}
+	p.stop = make(chan struct{})
+	p.checkAll(c, path)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.checkAll(c, path)
+			}
+		}
+	}()
+}
+
+func (p *Pool) Stop() {
+	p.stopOnce.Do(func() {
+		if p.stop != nil {
+			close(p.stop)
+		}
+	})
+}
+
+func (p *Pool) checkAll(c *client.Client, path string) {
+	var wg sync.WaitGroup
+	for _, b := range p.Backends {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			target := *b.URL
+			target.Path = joinPath(b.URL.Path, path)
+			resp, err := c.Get(target.String())
+			if err != nil {
+				b.unhealthy.Store(true)
+				return
+			}
+			resp.Body.Close()
+			b.unhealthy.Store(resp.StatusCode < 200 || resp.StatusCode >= 400)
+		}(b)
+	}
+	wg.Wait()
+}
+
+func parseUpstream(upstream string) (*url.URL, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("upstream %q has no host", upstream)
+	}
+	return u, nil
+}
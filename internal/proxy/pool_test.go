@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"MODULE_NAME/internal/request"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubs starts n upstreams that answer with their index and reply 500 on
+// /health while their healthy flag is false.
+func stubs(t *testing.T, n int) ([]*httptest.Server, []*atomic.Bool) {
+	t.Helper()
+	servers := []*httptest.Server{}
+	healthy := []*atomic.Bool{}
+	for i := 0; i < n; i++ {
+		ok := &atomic.Bool{}
+		ok.Store(true)
+		index := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/health" && !ok.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprintf(w, "backend-%d", index)
+		}))
+		t.Cleanup(srv.Close)
+		servers = append(servers, srv)
+		healthy = append(healthy, ok)
+	}
+	return servers, healthy
+}
+
+func newTestPool(t *testing.T, servers []*httptest.Server) *Pool {
+	t.Helper()
+	upstreams := []string{}
+	for _, srv := range servers {
+		upstreams = append(upstreams, srv.URL)
+	}
+	pool, err := NewPool(upstreams...)
+	require.NoError(t, err)
+	return pool
+}
+
+func getRequest(t *testing.T, extraHeaders string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n" + extraHeaders + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestRoundRobin(t *testing.T) {
+	servers, _ := stubs(t, 3)
+	p := NewWithPool(newTestPool(t, servers))
+
+	got := []string{}
+	for i := 0; i < 6; i++ {
+		_, body := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		got = append(got, string(body))
+	}
+	assert.Equal(t, []string{
+		"backend-0", "backend-1", "backend-2",
+		"backend-0", "backend-1", "backend-2",
+	}, got)
+}
+
+func TestLeastConnections(t *testing.T) {
+	servers, _ := stubs(t, 3)
+	pool := newTestPool(t, servers)
+	pool.Strategy = LeastConnections
+	pool.Backends[0].active.Store(4)
+	pool.Backends[1].active.Store(1)
+	pool.Backends[2].active.Store(2)
+
+	req := getRequest(t, "")
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, pool.Backends[1], pool.Pick(req, nil))
+	}
+	pool.Backends[1].active.Store(3)
+	assert.Equal(t, pool.Backends[2], pool.Pick(req, nil))
+}
+
+func TestConsistentHash(t *testing.T) {
+	servers, _ := stubs(t, 4)
+	pool := newTestPool(t, servers)
+	pool.Strategy = ConsistentHash
+	pool.HashHeader = "X-User"
+
+	// Test: Same key, same backend
+	before := map[string]*Backend{}
+	for i := 0; i < 200; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		req := getRequest(t, "X-User: "+user+"\r\n")
+		b := pool.Pick(req, nil)
+		require.NotNil(t, b)
+		assert.Equal(t, b, pool.Pick(req, nil))
+		before[user] = b
+	}
+
+	// Test: Taking one backend out only moves its own keys
+	gone := pool.Backends[2]
+	gone.unhealthy.Store(true)
+	for user, b := range before {
+		after := pool.Pick(getRequest(t, "X-User: "+user+"\r\n"), nil)
+		if b == gone {
+			assert.NotEqual(t, gone, after)
+		} else {
+			assert.Equal(t, b, after, user)
+		}
+	}
+}
+
+func TestActiveHealthChecks(t *testing.T) {
+	servers, healthy := stubs(t, 2)
+	pool := newTestPool(t, servers)
+	healthy[0].Store(false)
+	pool.StartHealthChecks("/health", 10*time.Millisecond, time.Second)
+	defer pool.Stop()
+
+	assert.False(t, pool.Backends[0].Available())
+	assert.True(t, pool.Backends[1].Available())
+	p := NewWithPool(pool)
+	for i := 0; i < 3; i++ {
+		_, body := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, "backend-1", string(body))
+	}
+
+	// Test: Back in rotation once it passes again
+	healthy[0].Store(true)
+	assert.Eventually(t, pool.Backends[0].Available, time.Second, 5*time.Millisecond)
+
+	// Test: Nothing healthy left
+	healthy[0].Store(false)
+	healthy[1].Store(false)
+	assert.Eventually(t, func() bool {
+		return !pool.Backends[0].Available() && !pool.Backends[1].Available()
+	}, time.Second, 5*time.Millisecond)
+	resp, _ := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestPassiveEjectionAndRetry(t *testing.T) {
+	servers, _ := stubs(t, 2)
+	servers[0].Close()
+	pool := newTestPool(t, servers)
+	pool.MaxFails = 2
+	pool.EjectFor = time.Minute
+	p := NewWithPool(pool)
+
+	// Test: Idempotent requests are retried on the other backend
+	for i := 0; i < 4; i++ {
+		resp, body := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "backend-1", string(body))
+	}
+	// Test: The dead backend was ejected after two errors in a row
+	assert.False(t, pool.Backends[0].Available())
+
+	// Test: POST is not retried
+	pool.Backends[0].ejectedUntil.Store(0)
+	pool.next.Store(0)
+	resp, _ := proxyRequest(t, p, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\n\r\n")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+}
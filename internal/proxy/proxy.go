@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"MODULE_NAME/internal/client"
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// hopByHop are the fields that only apply to a single connection and must not
+// be forwarded (RFC 9110 7.6.1). Fields named in Connection are dropped too.
+var hopByHop = []string{
+	"connection",
+	"keep-alive",
+	"proxy-connection",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// Proxy forwards requests to a pool of upstream servers and streams the
+// answer back to the client.
+type Proxy struct {
+	Pool *Pool
+	// Retries is how many other backends an idempotent request is sent to
+	// when the chosen one can't be reached.
+	Retries int
+	// StripPrefix is cut from the request path before it is appended to the
+	// upstream path, e.g. "/httpbin" turns "/httpbin/get" into "/get".
+	StripPrefix string
+	// Via is the pseudonym this proxy adds to the Via header.
+	Via string
+	// Digests selects the trailer digests per route, see DigestRoute.
+	Digests []DigestRoute
+
+	client *client.Client
+}
+
+func New(upstream string) (*Proxy, error) {
+	pool, err := NewPool(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithPool(pool), nil
+}
+
+func NewWithPool(pool *Pool) *Proxy {
+	return &Proxy{
+		Pool:    pool,
+		Retries: 1,
+		Via:     "http-in-go",
+		// every request goes to the same few hosts, and redirects are for
+		// the downstream client to follow
+		client: &client.Client{MaxIdleConnsPerHost: 32, MaxRedirects: -1},
+	}
+}
+
+// ConnStats returns the counters of the upstream connection pool.
+func (p *Proxy) ConnStats() client.PoolStats {
+	return p.client.Stats()
+}
+
+// Handle has the server.Handler signature, so a proxy can be mounted with
+// server.Serve(port, p.Handle) or called from another handler.
+func (p *Proxy) Handle(w *response.Writer, req *request.Request) {
+	body, err := req.ReadBody()
+	if err != nil {
+		writeError(w, response.StatusBadRequest)
+		return
+	}
+
+	tried := map[*Backend]bool{}
+	var resp *client.Response
+	var backend *Backend
+	for attempt := 0; ; attempt++ {
+		backend = p.Pool.Pick(req, tried)
+		if backend == nil && attempt > 0 {
+			// nothing left to retry on, report the last failure
+			writeError(w, response.StatusBadGateway)
+			return
+		}
+		if backend == nil {
+			writeError(w, response.StatusServiceUnavailable)
+			return
+		}
+		tried[backend] = true
+
+		target, err := p.targetURL(backend.URL, req.RequestLine.RequestTarget)
+		if err != nil {
+			writeError(w, response.StatusBadRequest)
+			return
+		}
+		outReq, err := client.NewRequest(req.RequestLine.Method, target.String(), body)
+		if err != nil {
+			writeError(w, response.StatusBadRequest)
+			return
+		}
+		p.copyRequestHeaders(outReq, req)
+
+		backend.active.Add(1)
+		resp, err = p.client.Do(outReq)
+		if err == nil {
+			p.Pool.markSuccess(backend)
+			break
+		}
+		backend.active.Add(-1)
+		p.Pool.markFailure(backend)
+		log.Printf("proxy: error reaching %s: %v", target, err)
+		if !isIdempotent(req.RequestLine.Method) || attempt >= p.Retries {
+			writeError(w, response.StatusBadGateway)
+			return
+		}
+	}
+	defer backend.active.Add(-1)
+	defer resp.Body.Close()
+
+	h := resp.Headers.Clone()
+	removeHopByHop(h)
+	h.Set("Via", fmt.Sprintf("%s %s", strings.TrimPrefix(resp.Proto, "HTTP/"), p.Via))
+	h.SetOVR("Connection", "close")
+
+	if !response.BodyAllowed(req.RequestLine.Method, resp.StatusCode) {
+		w.WriteStatusLine(resp.StatusCode)
+		w.WriteHeaders(h)
+		return
+	}
+
+	path := req.RequestLine.RequestTarget
+	if u, err := url.ParseRequestURI(path); err == nil {
+		path = u.Path
+	}
+	digest, err := newDigestWriter(p.digestsFor(path))
+	if err != nil {
+		log.Printf("proxy: %v", err)
+		writeError(w, response.StatusInternalError)
+		return
+	}
+	// a 206 only carries part of the representation
+	repr := resp.StatusCode != response.StatusPartialContent
+
+	// the body is re-framed as chunked, so the upstream length goes away
+	h.Delete("Content-Length")
+	h.SetOVR("Transfer-Encoding", "chunked")
+	// upstream trailers go on when announced, the digests are the proxy's
+	upstreamTrailers := []string{}
+	for _, name := range resp.Headers.Values("Trailer") {
+		if !digest.ownsTrailer(name) {
+			upstreamTrailers = append(upstreamTrailers, headers.CanonicalKey(name))
+		}
+	}
+	h.SetOVR("Trailer", strings.Join(append(upstreamTrailers, digest.trailerNames(repr)), ", "))
+	w.WriteStatusLine(resp.StatusCode)
+	w.WriteHeaders(h)
+
+	// upstream trailers are only known once the body has been read
+	trailers := func() headers.Headers {
+		trailer := headers.NewHeaders()
+		for _, name := range upstreamTrailers {
+			for _, value := range resp.Trailers.FieldLines(name) {
+				trailer.Set(name, value)
+			}
+		}
+		for key, values := range digest.Trailers(repr) {
+			trailer[key] = values
+		}
+		return trailer
+	}
+	if err := w.WriteChunkedFrom(io.TeeReader(resp.Body, digest), trailers); err != nil {
+		log.Printf("proxy: error streaming upstream body: %v", err)
+	}
+}
+
+// targetURL maps the request target onto the backend URL.
+func (p *Proxy) targetURL(base *url.URL, requestTarget string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(requestTarget)
+	if err != nil {
+		return nil, err
+	}
+	target := *base
+	target.Path = joinPath(base.Path, strings.TrimPrefix(u.Path, p.StripPrefix))
+	target.RawPath = ""
+	target.RawQuery = u.RawQuery
+	return &target, nil
+}
+
+func (p *Proxy) copyRequestHeaders(outReq *client.Request, req *request.Request) {
+	h := outReq.Headers
+	for key, values := range req.Headers {
+		h[key] = slices.Clone(values)
+	}
+	removeHopByHop(h)
+	// Host and the body framing are set by the client from the outgoing URL
+	// and body
+	h.Delete("Host")
+	h.Delete("Content-Length")
+
+	clientIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	if clientIP != "" {
+		if prior, err := req.Headers.Get("X-Forwarded-For"); err == nil {
+			clientIP = prior + ", " + clientIP
+		}
+		h.SetOVR("X-Forwarded-For", clientIP)
+	}
+	h.SetOVR("X-Forwarded-Proto", "http")
+	if host, err := req.Headers.Get("Host"); err == nil {
+		h.SetOVR("X-Forwarded-Host", host)
+	}
+	via := fmt.Sprintf("%s %s", req.RequestLine.HttpVersion, p.Via)
+	if prior, err := req.Headers.Get("Via"); err == nil {
+		via = prior + ", " + via
+	}
+	h.SetOVR("Via", via)
+}
+
+func removeHopByHop(h headers.Headers) {
+	for _, name := range h.Values("Connection") {
+		h.Delete(name)
+	}
+	for _, name := range hopByHop {
+		h.Delete(name)
+	}
+}
+
+func joinPath(base string, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// isIdempotent reports whether a request can safely be sent twice
+// (RFC 9110 9.2.2).
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyRequest runs a raw request through p and parses what it wrote back.
+func proxyRequest(t *testing.T, p *Proxy, raw string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "10.0.0.7:51234"
+
+	out := &bytes.Buffer{}
+	p.Handle(&response.Writer{ResWriter: out}, req)
+
+	method := req.RequestLine.Method
+	resp, err := http.ReadResponse(bufio.NewReader(out), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func TestProxyForwarding(t *testing.T) {
+	var seen *http.Request
+	var seenBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+		seenBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "created!")
+	}))
+	defer upstream.Close()
+
+	p, err := New(upstream.URL + "/api")
+	require.NoError(t, err)
+	p.StripPrefix = "/svc"
+
+	// Test: Method, path, query, headers and body are forwarded
+	resp, body := proxyRequest(t, p, "PUT /svc/items/7?color=red&size=2 HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"X-Custom: 42\r\n"+
+		"X-Forwarded-For: 192.168.1.1\r\n"+
+		"Connection: close, X-Secret\r\n"+
+		"X-Secret: drop me\r\n"+
+		"Proxy-Authorization: Basic Zm9vOmJhcg==\r\n"+
+		"Content-Length: 5\r\n"+
+		"\r\n"+
+		"hello")
+	require.NotNil(t, seen)
+	assert.Equal(t, "PUT", seen.Method)
+	assert.Equal(t, "/api/items/7", seen.URL.Path)
+	assert.Equal(t, "color=red&size=2", seen.URL.RawQuery)
+	assert.Equal(t, "hello", string(seenBody))
+	assert.Equal(t, "42", seen.Header.Get("X-Custom"))
+	assert.Equal(t, "", seen.Header.Get("X-Secret"))
+	assert.Equal(t, "", seen.Header.Get("Proxy-Authorization"))
+	assert.Equal(t, "192.168.1.1, 10.0.0.7", seen.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "http", seen.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "example.com", seen.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "1.1 http-in-go", seen.Header.Get("Via"))
+
+	// Test: Status, headers and body come back as the upstream sent them
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "created!", string(body))
+	assert.Equal(t, "yes", resp.Header.Get("X-Upstream"))
+	assert.Equal(t, "", resp.Header.Get("Keep-Alive"))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2015 07:28:00 GMT", "b=2"}, resp.Header.Values("Set-Cookie"))
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("created!"))), resp.Trailer.Get("X-Content-Sha256"))
+	assert.Equal(t, "8", resp.Trailer.Get("X-Content-Length"))
+}
+
+func TestProxyStatusPassthrough(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/moved":
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	resp, _ := proxyRequest(t, p, "GET /missing HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Test: Redirects are not followed by the proxy
+	resp, _ = proxyRequest(t, p, "GET /moved HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/elsewhere", resp.Header.Get("Location"))
+
+	resp, body := proxyRequest(t, p, "GET /empty HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestProxyUpstreamDown(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+	p, err := New(upstream.URL)
+	require.NoError(t, err)
+
+	resp, _ := proxyRequest(t, p, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	_, err = New("ftp://example.com")
+	require.Error(t, err)
+}
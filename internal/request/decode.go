@@ -0,0 +1,140 @@
+package request
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedEncoding is returned when Options.DecodeBody is set and the
+// body has a Content-Encoding we can't decode. Servers answer it with 415.
+var ErrUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// ErrBodyTooLarge is returned when a decoded body grows past
+// Options.MaxDecodedBodySize. Servers answer it with 413.
+var ErrBodyTooLarge = errors.New("decoded body too large")
+
+// DefaultMaxDecodedBodySize caps decoded bodies when
+// Options.MaxDecodedBodySize is 0. A few KB of gzip can hold gigabytes.
+const DefaultMaxDecodedBodySize = 10 << 20
+
+// newBodyReader returns the reader BodyReader hands out: the body as sent,
+// or with Options.DecodeBody and a Content-Encoding, a decodingReader over
+// it. An empty body has nothing to decode, whatever its coding.
+func (r *Request) newBodyReader() (io.Reader, error) {
+	raw := rawBody{r: r}
+	if !r.options.DecodeBody {
+		return raw, nil
+	}
+	codings := r.Headers.Values("Content-Encoding")
+	length, err := r.contentLength()
+	if err != nil {
+		return nil, err
+	}
+	if len(codings) == 0 || length == 0 {
+		return raw, nil
+	}
+	for _, coding := range codings {
+		switch strings.ToLower(coding) {
+		case "gzip", "x-gzip", "deflate", "identity":
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+		}
+	}
+	limit := r.options.MaxDecodedBodySize
+	if limit == 0 {
+		limit = DefaultMaxDecodedBodySize
+	}
+	return &decodingReader{r: r, raw: raw, codings: codings, limit: limit}, nil
+}
+
+// decodingReader undoes the Content-Encoding of the body as it is read, the
+// codings listed in the order they were applied so the last one comes off
+// first. The decoders are chained on the first read, they read the body
+// right away. Past limit decoded bytes it fails, a zip bomb stops at the
+// cap instead of filling the memory. At the end the request looks like it
+// was sent without the encoding.
+type decodingReader struct {
+	r       *Request
+	raw     io.Reader
+	codings []string
+	limit   int64
+
+	decoded io.Reader
+	n       int64
+	err     error
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.decoded == nil {
+		d.decoded, d.err = d.chain()
+		if d.err != nil {
+			return 0, d.err
+		}
+	}
+	n, err := d.decoded.Read(p)
+	d.n += int64(n)
+	switch {
+	case d.n > d.limit:
+		d.err = fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, d.limit)
+		return 0, d.err
+	case errors.Is(err, io.EOF):
+		// the rest, if the encoded data ended early, isn't a request
+		if _, err := io.Copy(io.Discard, d.raw); err != nil {
+			d.err = err
+			return n, nil
+		}
+		d.r.Headers.Delete("Content-Encoding")
+		d.r.Headers.SetOVR("Content-Length", strconv.FormatInt(d.n, 10))
+		d.err = io.EOF
+	case err != nil:
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			err = fmt.Errorf("invalid encoded body: %w", err)
+		}
+		d.err = err
+	}
+	return n, d.err
+}
+
+func (d *decodingReader) chain() (io.Reader, error) {
+	reader := d.raw
+	for i := len(d.codings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(d.codings[i]) {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(reader)
+		case "deflate":
+			reader, err = newDeflateReader(reader)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s body: %w", d.codings[i], err)
+		}
+	}
+	return reader, nil
+}
+
+// newDeflateReader reads "deflate", which is the zlib format (RFC 9110
+// 8.4.1.2). Some clients send raw deflate instead, the missing zlib header
+// gives them away.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	isZlib := header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+	if isZlib {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
@@ -0,0 +1,169 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, p []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(p)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zlibBytes(t *testing.T, p []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write(p)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func flateBytes(t *testing.T, p []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	require.NoError(t, err)
+	_, err = w.Write(p)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func encodedRequest(coding string, body []byte) string {
+	return fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s",
+		coding, len(body), body)
+}
+
+func TestDecodeBody(t *testing.T) {
+	plain := []byte(strings.Repeat("some upload data\n", 50))
+	opts := Options{DecodeBody: true}
+
+	// Test: gzip, zlib deflate and raw deflate
+	for coding, encoded := range map[string][]byte{
+		"gzip":    gzipBytes(t, plain),
+		"x-gzip":  gzipBytes(t, plain),
+		"deflate": zlibBytes(t, plain),
+		"Deflate": flateBytes(t, plain),
+	} {
+		reader := &chunkReader{data: encodedRequest(coding, encoded), numBytesPerRead: 7}
+		r, err := RequestFromReaderWithOptions(reader, opts)
+		require.NoError(t, err, coding)
+		assert.Equal(t, plain, r.Body, coding)
+		_, err = r.Headers.Get("Content-Encoding")
+		assert.Error(t, err, coding)
+		contentLength, _ := r.Headers.Get("Content-Length")
+		assert.Equal(t, fmt.Sprint(len(plain)), contentLength, coding)
+	}
+
+	// Test: Stacked codings come off in reverse order
+	stacked := gzipBytes(t, zlibBytes(t, plain))
+	r, err := RequestFromReaderWithOptions(&chunkReader{data: encodedRequest("deflate, identity, gzip", stacked), numBytesPerRead: 64}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, plain, r.Body)
+
+	// Test: Without the option the body stays encoded
+	encoded := gzipBytes(t, plain)
+	r, err = RequestFromReader(&chunkReader{data: encodedRequest("gzip", encoded), numBytesPerRead: 64})
+	require.NoError(t, err)
+	assert.Equal(t, encoded, r.Body)
+
+	// Test: Unknown codings are unsupported
+	for _, raw := range []string{
+		encodedRequest("br", []byte("whatever")),
+		encodedRequest("gzip, zstd", gzipBytes(t, plain)),
+	} {
+		_, err = RequestFromReaderWithOptions(&chunkReader{data: raw, numBytesPerRead: 64}, opts)
+		assert.True(t, errors.Is(err, ErrUnsupportedEncoding), raw)
+	}
+
+	// Test: Without a body there's nothing to decode, whatever the coding
+	for _, raw := range []string{
+		encodedRequest("br", nil),
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: compress\r\n\r\n",
+	} {
+		r, err = RequestFromReaderWithOptions(&chunkReader{data: raw, numBytesPerRead: 64}, opts)
+		require.NoError(t, err, raw)
+		assert.Empty(t, r.Body, raw)
+	}
+
+	// Test: Corrupt data is an error of its own
+	_, err = RequestFromReaderWithOptions(&chunkReader{data: encodedRequest("gzip", []byte("not gzip at all")), numBytesPerRead: 64}, opts)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnsupportedEncoding))
+	assert.False(t, errors.Is(err, ErrBodyTooLarge))
+	truncated := gzipBytes(t, plain)
+	_, err = RequestFromReaderWithOptions(&chunkReader{data: encodedRequest("gzip", truncated[:len(truncated)-10]), numBytesPerRead: 64}, opts)
+	require.Error(t, err)
+}
+
+func TestDecodeBodyLimit(t *testing.T) {
+	// Test: A zip bomb stops at the cap, a few KB standing for 64 MB
+	bomb := gzipBytes(t, gzipBytes(t, make([]byte, 64<<20)))
+	assert.Less(t, len(bomb), 64<<10)
+	opts := Options{DecodeBody: true, MaxDecodedBodySize: 1 << 20}
+	_, err := RequestFromReaderWithOptions(&chunkReader{data: encodedRequest("gzip, gzip", bomb), numBytesPerRead: 4096}, opts)
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+
+	// Test: Exactly at the cap is fine
+	opts.MaxDecodedBodySize = 100
+	r, err := RequestFromReaderWithOptions(&chunkReader{data: encodedRequest("gzip", gzipBytes(t, make([]byte, 100))), numBytesPerRead: 64}, opts)
+	require.NoError(t, err)
+	assert.Len(t, r.Body, 100)
+
+	// Test: Also when the body is read after the headers
+	r, err = RequestHeadersFromReaderWithOptions(&chunkReader{data: encodedRequest("gzip", gzipBytes(t, make([]byte, 101))), numBytesPerRead: 64}, opts)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+}
+
+func TestDecodeBodyStream(t *testing.T) {
+	// Test: Decoded bytes come out of BodyReader as the encoded ones arrive
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	var encoded bytes.Buffer
+	zw := gzip.NewWriter(&encoded)
+	zw.Write([]byte("first part,"))
+	zw.Flush()
+	head := len(encoded.Bytes())
+	zw.Write([]byte(" second part"))
+	zw.Close()
+	go func() {
+		fmt.Fprintf(pw, "POST /upload HTTP/1.1\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n", encoded.Len())
+		pw.Write(encoded.Bytes()[:head])
+	}()
+
+	r, err := RequestHeadersFromReaderWithOptions(pr, Options{DecodeBody: true})
+	require.NoError(t, err)
+	body, err := r.BodyReader()
+	require.NoError(t, err)
+	first := make([]byte, len("first part,"))
+	_, err = io.ReadFull(body, first)
+	require.NoError(t, err)
+	assert.Equal(t, "first part,", string(first))
+
+	go pw.Write(encoded.Bytes()[head:])
+	rest, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, " second part", string(rest))
+	contentLength, _ := r.Headers.Get("Content-Length")
+	assert.Equal(t, "23", contentLength)
+	assert.Empty(t, r.Buffered())
+}
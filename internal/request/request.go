@@ -20,12 +20,61 @@ const (
 	ParsingBody
 )
 
+// Request is a parsed request. Body is empty until the body has been read,
+// see RequestHeadersFromReader and ReadBody.
 type Request struct {
 	RequestLine    RequestLine
 	Headers        headers.Headers
 	Body           []byte
 	Status         Status
 	bodyLengthRead int
+
+	// RemoteAddr is the address of the client, filled in by the server
+	RemoteAddr string
+
+	// reader and buf hold the connection and the unparsed bytes so the body
+	// can be read after the headers, see RequestHeadersFromReader
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	// consumed counts the bytes parsed so far, for ParseError
+	consumed       int
+	beforeBodyRead func() error
+	options        Options
+	// body is what BodyReader returns, bodyRead is set once ReadBody has
+	// the whole body in Body
+	body     io.Reader
+	bodyRead bool
+}
+
+// Options tweak how a request is parsed.
+type Options struct {
+	headers.ParseOptions
+	// DecodeBody undoes a gzip or deflate Content-Encoding, stacked ones
+	// included, as the body is read. Other codings fail with
+	// ErrUnsupportedEncoding.
+	DecodeBody bool
+	// MaxDecodedBodySize caps the decoded body, DefaultMaxDecodedBodySize
+	// when 0.
+	MaxDecodedBodySize int64
+}
+
+// ParseError tells where in the stream a request stopped making sense.
+type ParseError struct {
+	// Offset is the number of bytes before the element that failed: the
+	// request line, a field line or the body.
+	Offset int
+	// Status is the state the parser was in.
+	Status Status
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("at byte %d: %v", e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 type RequestLine struct {
@@ -63,12 +112,14 @@ type RequestLine struct {
 //		}
 //	}
 
-func (r *Request) parse(data []byte) (int, error) {
+// parse consumes as much of data as it can, stopping early once the request
+// reaches the stop state.
+func (r *Request) parse(data []byte, stop Status) (int, error) {
 	totalBytesParsed := 0
-	for r.Status != done {
+	for r.Status != done && r.Status != stop {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
-			return 0, err
+			return 0, &ParseError{Offset: r.consumed + totalBytesParsed, Status: r.Status, Err: err}
 		}
 		totalBytesParsed += n
 		if n == 0 {
@@ -94,7 +145,7 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		r.Status = ParsingHeaders
 		return n, nil
 	case ParsingHeaders:
-		n, finished, err := r.Headers.Parse(data)
+		n, finished, err := r.Headers.ParseWithOptions(data, r.options.ParseOptions)
 		if err != nil {
 			return 0, err
 		}
@@ -102,28 +153,6 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			r.Status = ParsingBody
 		}
 		return n, nil
-	case ParsingBody:
-		contLen, err := r.Headers.Get("Content-Length")
-		if err != nil {
-			r.Status = done
-			return len(data), nil
-		}
-		conLenInt, err := strconv.Atoi(contLen)
-		if err != nil {
-			return 0, err
-		}
-		// /
-
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > conLenInt {
-			return 0, fmt.Errorf("Content-Length too large")
-		}
-		if r.bodyLengthRead == conLenInt {
-			r.Status = done
-		}
-		return len(data), nil
-
 	case done:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
@@ -134,47 +163,231 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 const bufferSize = 8
 const crlf = "\r\n"
 
+// RequestFromReader reads and parses a whole request, body included.
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithOptions(reader, Options{})
+}
+
+func RequestFromReaderWithOptions(reader io.Reader, opts Options) (*Request, error) {
+	request, err := RequestHeadersFromReaderWithOptions(reader, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := request.ReadBody(); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// RequestHeadersFromReader parses the request line and the headers and leaves
+// the body on the reader. Call ReadBody or BodyReader to get it.
+func RequestHeadersFromReader(reader io.Reader) (*Request, error) {
+	return RequestHeadersFromReaderWithOptions(reader, Options{})
+}
+
+func RequestHeadersFromReaderWithOptions(reader io.Reader, opts Options) (*Request, error) {
+	request := newRequest(reader, opts)
+	if err := request.readUntil(ParsingBody); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// BeforeBodyRead registers fn to run once, right before the body has to be
+// pulled off the reader. The server uses it to send 100 Continue.
+func (r *Request) BeforeBodyRead(fn func() error) {
+	r.beforeBodyRead = fn
+}
+
+// ReadBody reads the rest of the body into Body and returns it. It is a
+// no-op for requests that were already read in full.
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.bodyRead {
+		return r.Body, nil
+	}
+	reader, err := r.BodyReader()
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+	r.bodyRead = true
+	return r.Body, nil
+}
+
+// BodyReader returns the body as it comes off the reader, decoded on the
+// way with Options.DecodeBody, for handlers that consume it as it arrives
+// instead of holding it whole. What it reads isn't kept in Body. Codings
+// that can't be decoded fail here, before anything is read.
+func (r *Request) BodyReader() (io.Reader, error) {
+	if r.bodyRead {
+		return bytes.NewReader(r.Body), nil
+	}
+	if r.body == nil {
+		body, err := r.newBodyReader()
+		if err != nil {
+			return nil, err
+		}
+		r.body = body
+	}
+	return r.body, nil
+}
+
+// contentLength is the length of the body, 0 when there's no
+// Content-Length: whatever follows is the next request.
+func (r *Request) contentLength() (int, error) {
+	value, err := r.Headers.Get("Content-Length")
+	if err != nil {
+		return 0, nil
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || length < 0 {
+		return 0, &ParseError{Offset: r.consumed, Status: ParsingBody, Err: fmt.Errorf("invalid Content-Length: %q", value)}
+	}
+	return length, nil
+}
+
+// rawBody reads the body as it was sent, what's buffered first and then
+// straight from the reader, never past the end of the body.
+type rawBody struct {
+	r *Request
+}
+
+func (b rawBody) Read(p []byte) (int, error) {
+	r := b.r
+	if r.Status == done {
+		return 0, io.EOF
+	}
+	length, err := r.contentLength()
+	if err != nil {
+		return 0, err
+	}
+	if r.bodyLengthRead == length {
+		r.Status = done
+		return 0, io.EOF
+	}
+	p = p[:min(len(p), length-r.bodyLengthRead)]
+	var n int
+	if r.readToIndex > 0 {
+		n = copy(p, r.buf[:r.readToIndex])
+		copy(r.buf, r.buf[n:r.readToIndex])
+		r.readToIndex -= n
+	} else {
+		if r.beforeBodyRead != nil {
+			fn := r.beforeBodyRead
+			r.beforeBodyRead = nil
+			if err := fn(); err != nil {
+				return 0, err
+			}
+		}
+		n, err = r.reader.Read(p)
+	}
+	r.bodyLengthRead += n
+	r.consumed += n
+	if r.bodyLengthRead == length {
+		r.Status = done
+	}
+	if errors.Is(err, io.EOF) {
+		if n > 0 || r.Status == done {
+			return n, nil
+		}
+		return 0, &ParseError{
+			Offset: r.consumed,
+			Status: ParsingBody,
+			Err:    fmt.Errorf("incomplete request, in state: %d: %w", ParsingBody, io.ErrUnexpectedEOF),
+		}
+	}
+	return n, err
+}
 
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
+// Buffered returns the bytes read past the end of the request, the start of
+// the next one on a keep-alive connection.
+func (r *Request) Buffered() []byte {
+	return r.buf[:r.readToIndex]
+}
+
+// Write serializes the request line, the headers and the body. The caller is
+// responsible for framing headers such as Content-Length.
+func (r *Request) Write(w io.Writer) error {
+	if !headers.IsToken(r.RequestLine.Method) {
+		return fmt.Errorf("invalid method: %q", r.RequestLine.Method)
+	}
+	if r.RequestLine.RequestTarget == "" || strings.ContainsAny(r.RequestLine.RequestTarget, " \t\r\n") {
+		return fmt.Errorf("invalid request target: %q", r.RequestLine.RequestTarget)
+	}
+	_, err := fmt.Fprintf(w, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, r.RequestLine.HttpVersion)
+	if err != nil {
+		return err
+	}
+	err = r.Headers.Write(w)
+	if err != nil {
+		return err
+	}
+	if len(r.Body) > 0 {
+		_, err = w.Write(r.Body)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	request := &Request{
+func newRequest(reader io.Reader, opts Options) *Request {
+	return &Request{
 		RequestLine: RequestLine{},
 		Headers:     headers.NewHeaders(),
 		Body:        []byte{},
 		Status:      initialized,
+		reader:      reader,
+		buf:         make([]byte, bufferSize),
+		options:     opts,
 	}
+}
+
+// readUntil parses what is already buffered and keeps reading until the
+// request reaches the stop state or is done. The body is read by rawBody.
+func (r *Request) readUntil(stop Status) error {
+	for {
+		numBytesParsed, err := r.parse(r.buf[:r.readToIndex], stop)
+		if err != nil {
+			return err
+		}
+		copy(r.buf, r.buf[numBytesParsed:r.readToIndex])
+		r.readToIndex -= numBytesParsed
+		r.consumed += numBytesParsed
 
-	for request.Status != done {
+		if r.Status == done || r.Status == stop {
+			return nil
+		}
 
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+		if r.readToIndex >= len(r.buf) {
+			newBuf := make([]byte, len(r.buf)*2)
+			copy(newBuf, r.buf)
+			r.buf = newBuf
 		}
-		numBytesRead, err := reader.Read(buf[readToIndex:])
+		numBytesRead, err := r.reader.Read(r.buf[r.readToIndex:])
+		r.readToIndex += numBytesRead
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				if request.Status != done {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", request.Status, numBytesRead)
+				if numBytesRead > 0 {
+					continue
+				}
+				// the connection ended between two requests
+				if r.Status == initialized && r.consumed == 0 && r.readToIndex == 0 {
+					return io.EOF
+				}
+				return &ParseError{
+					Offset: r.consumed,
+					Status: r.Status,
+					Err:    fmt.Errorf("incomplete request, in state: %d: %w", r.Status, io.ErrUnexpectedEOF),
 				}
-				break
 			}
-			return nil, err
-		}
-		readToIndex += numBytesRead
-		numBytesParsed, err := request.parse(buf[:readToIndex])
-
-		if err != nil {
-			return nil, err
+			return err
 		}
-
-		copy(buf, buf[numBytesParsed:])
-		readToIndex -= numBytesParsed
 	}
-
-	return request, nil
 }
 
 func parseRequestLine(request []byte) (*RequestLine, int, error) {
@@ -1,6 +1,8 @@
 package request
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"testing"
 
@@ -59,9 +61,9 @@ func TestHeaderParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers["host"])
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers["user-agent"])
+	assert.Equal(t, []string{"*/*"}, r.Headers["accept"])
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -176,6 +178,94 @@ func TestBodyParse(t *testing.T) {
 	assert.Equal(t, "", string(r.Body))
 }
 
+func TestLazyBodyParse(t *testing.T) {
+	// Test: Headers only, body left for ReadBody
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Expect: 100-continue\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestHeadersFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "POST", r.RequestLine.Method)
+	value, _ := r.Headers.Get("Expect")
+	assert.Equal(t, "100-continue", value)
+	assert.Equal(t, "", string(r.Body))
+
+	calls := 0
+	r.BeforeBodyRead(func() error {
+		calls++
+		return nil
+	})
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, 1, calls)
+
+	// Reading again returns the same body without running the hook
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, 1, calls)
+
+	// Test: Hook is skipped when there is no body to wait for
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Expect: 100-continue\r\n" +
+			"Content-Length: 0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestHeadersFromReader(reader)
+	require.NoError(t, err)
+	calls = 0
+	r.BeforeBodyRead(func() error {
+		calls++
+		return nil
+	})
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, 0, calls)
+
+	// Test: Body shorter than reported content length
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial content",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestHeadersFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+}
+
+func TestObsFoldParse(t *testing.T) {
+	data := "GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"X-Folded: one\r\n" +
+		"\ttwo\r\n" +
+		"\r\n"
+
+	// Test: Rejected by default
+	_, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 3})
+	require.Error(t, err)
+
+	// Test: Unfolded when enabled
+	opts := Options{}
+	opts.UnfoldObsFold = true
+	r, err := RequestFromReaderWithOptions(&chunkReader{data: data, numBytesPerRead: 3}, opts)
+	require.NoError(t, err)
+	value, _ := r.Headers.Get("X-Folded")
+	assert.Equal(t, "one two", value)
+}
+
 // Read reads up to len(p) or numBytesPerRead bytes from the string per call
 // its useful for simulating reading a variable number of bytes per chunk from a network connection
 func (cr *chunkReader) Read(p []byte) (n int, err error) {
@@ -191,3 +281,50 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 
 	return n, nil
 }
+
+func TestKeepAliveStream(t *testing.T) {
+	data := "POST /one HTTP/1.1\r\nContent-Length: 5\r\n\r\nfirst" +
+		"GET /two HTTP/1.1\r\nHost: x\r\n\r\n" +
+		"PUT /three HTTP/1.1\r\nContent-Length: 3\r\n\r\nend"
+	var reader io.Reader = &chunkReader{data: data, numBytesPerRead: 7}
+	targets := []string{}
+	bodies := []string{}
+	for {
+		r, err := RequestFromReader(reader)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		targets = append(targets, r.RequestLine.RequestTarget)
+		bodies = append(bodies, string(r.Body))
+		reader = io.MultiReader(bytes.NewReader(bytes.Clone(r.Buffered())), reader)
+	}
+	assert.Equal(t, []string{"/one", "/two", "/three"}, targets)
+	assert.Equal(t, []string{"first", "", "end"}, bodies)
+}
+
+func TestParseErrorOffset(t *testing.T) {
+	// Test: Bad field line
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: x\r\nBad Header: y\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	_, err := RequestFromReader(reader)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 25, parseErr.Offset)
+	assert.Equal(t, ParsingHeaders, parseErr.Status)
+
+	// Test: Bad request line
+	reader = &chunkReader{data: "get / HTTP/1.1\r\n\r\n", numBytesPerRead: 4}
+	_, err = RequestFromReader(reader)
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 0, parseErr.Offset)
+
+	// Test: Cut short in the body
+	reader = &chunkReader{data: "POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\nabc", numBytesPerRead: 4}
+	_, err = RequestFromReader(reader)
+	require.ErrorAs(t, err, &parseErr)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, ParsingBody, parseErr.Status)
+}
@@ -0,0 +1,408 @@
+package response
+
+import (
+	"MODULE_NAME/internal/headers"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type parserState int
+
+const (
+	parsingStatusLine parserState = iota
+	parsingHeaders
+	parsingBody
+	parsingChunkSize
+	parsingChunkData
+	parsingChunkEnd
+	parsingTrailers
+	parsed
+)
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// Interim is a 1xx response received before the final one.
+type Interim struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+}
+
+// Response is a parsed response, the read side counterpart of Writer.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	Body       []byte
+	Trailers   headers.Headers
+	Interim    []Interim
+
+	state parserState
+	// method of the request this answers, HEAD responses have no body
+	method string
+	// remaining is what's left of the Content-Length or of the current
+	// chunk, -1 while reading until the connection closes
+	remaining int64
+	// closeDelimited is set when the body ends with the connection
+	closeDelimited bool
+	onChunk        func(size int64, extensions string)
+	// streaming hands each piece of body to BodyReader as soon as it's
+	// parsed, rather than parsing all that is buffered
+	streaming bool
+
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+}
+
+// ResponseFromReader reads and parses a whole response, body included.
+// method is the method of the request it answers.
+func ResponseFromReader(reader io.Reader, method string) (*Response, error) {
+	response := newResponse(reader, method)
+	if err := response.readUntil(false); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// ResponseHeadersFromReader parses the status line and headers, skipping
+// interim responses, and leaves the body on the reader. Read the body with
+// BodyReader.
+func ResponseHeadersFromReader(reader io.Reader, method string) (*Response, error) {
+	response := newResponse(reader, method)
+	if err := response.readUntil(true); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func newResponse(reader io.Reader, method string) *Response {
+	return &Response{
+		Headers:  headers.NewHeaders(),
+		Body:     []byte{},
+		Trailers: headers.NewHeaders(),
+		state:    parsingStatusLine,
+		method:   method,
+		reader:   reader,
+		buf:      make([]byte, bufferSize),
+	}
+}
+
+// Done reports whether the whole response, trailers included, was parsed.
+func (r *Response) Done() bool {
+	return r.state == parsed
+}
+
+// Buffered returns bytes read past the end of the response, e.g. the start
+// of a pipelined response.
+func (r *Response) Buffered() []byte {
+	return r.buf[:r.readToIndex]
+}
+
+// OnChunk registers fn to run for every chunk header of a chunked body,
+// the last chunk included, as it is parsed. extensions is what followed ';'.
+func (r *Response) OnChunk(fn func(size int64, extensions string)) {
+	r.onChunk = fn
+}
+
+// Reusable reports whether the connection can carry another response: this
+// one was parsed to the end without waiting for the connection to close,
+// nothing past it was read and the server didn't ask to close.
+func (r *Response) Reusable() bool {
+	if r.state != parsed || r.closeDelimited || r.readToIndex > 0 {
+		return false
+	}
+	if r.StatusLine.StatusCode == StatusSwitchingProtocols {
+		return false
+	}
+	// HTTP/1.0 closes unless told otherwise
+	keepAlive := r.StatusLine.HttpVersion == "1.1"
+	for _, option := range r.Headers.Values("Connection") {
+		switch strings.ToLower(option) {
+		case "close":
+			return false
+		case "keep-alive":
+			keepAlive = true
+		}
+	}
+	return keepAlive
+}
+
+// BodyReader streams the body instead of collecting it in Body. Trailers
+// are available once it returned io.EOF.
+func (r *Response) BodyReader() io.Reader {
+	r.streaming = true
+	return &bodyReader{r: r}
+}
+
+type bodyReader struct {
+	r *Response
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.r
+	for len(r.Body) == 0 {
+		if r.state == parsed {
+			return 0, io.EOF
+		}
+		if err := r.readMore(false); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.Body)
+	r.Body = r.Body[n:]
+	return n, nil
+}
+
+const bufferSize = 1024
+const crlf = "\r\n"
+
+// readUntil parses what is already buffered and keeps reading until the
+// response is done, or only until the end of the headers.
+func (r *Response) readUntil(headersOnly bool) error {
+	for !r.stopAt(headersOnly) {
+		if err := r.readMore(headersOnly); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Response) stopAt(headersOnly bool) bool {
+	// all the body states come after parsingBody
+	return r.state == parsed || (headersOnly && r.state >= parsingBody)
+}
+
+// readMore parses the buffered bytes and, if that made no progress, reads
+// more from the reader.
+func (r *Response) readMore(headersOnly bool) error {
+	numBytesParsed, err := r.parse(r.buf[:r.readToIndex], headersOnly)
+	if err != nil {
+		return err
+	}
+	copy(r.buf, r.buf[numBytesParsed:r.readToIndex])
+	r.readToIndex -= numBytesParsed
+	if numBytesParsed > 0 || r.state == parsed {
+		return nil
+	}
+
+	if r.readToIndex >= len(r.buf) {
+		newBuf := make([]byte, len(r.buf)*2)
+		copy(newBuf, r.buf)
+		r.buf = newBuf
+	}
+	numBytesRead, err := r.reader.Read(r.buf[r.readToIndex:])
+	r.readToIndex += numBytesRead
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+		if numBytesRead > 0 {
+			return nil
+		}
+		// a body without length ends with the connection
+		if r.state == parsingBody && r.remaining < 0 {
+			r.state = parsed
+			return nil
+		}
+		return fmt.Errorf("incomplete response, in state: %d: %w", r.state, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+func (r *Response) parse(data []byte, headersOnly bool) (int, error) {
+	totalBytesParsed := 0
+	for !r.stopAt(headersOnly) {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += n
+		if n == 0 || (r.streaming && len(r.Body) > 0) {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.state {
+	case parsingStatusLine:
+		crlfIndex := bytes.Index(data, []byte(crlf))
+		if crlfIndex == -1 {
+			return 0, nil
+		}
+		statusLine, err := statusLineFromString(string(data[:crlfIndex]))
+		if err != nil {
+			return 0, err
+		}
+		r.StatusLine = *statusLine
+		r.state = parsingHeaders
+		return crlfIndex + 2, nil
+	case parsingHeaders:
+		n, finished, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if finished {
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	case parsingBody:
+		if len(data) == 0 {
+			return 0, nil
+		}
+		take := int64(len(data))
+		if r.remaining >= 0 && take > r.remaining {
+			take = r.remaining
+		}
+		r.Body = append(r.Body, data[:take]...)
+		if r.remaining >= 0 {
+			r.remaining -= take
+			if r.remaining == 0 {
+				r.state = parsed
+			}
+		}
+		return int(take), nil
+	case parsingChunkSize:
+		crlfIndex := bytes.Index(data, []byte(crlf))
+		if crlfIndex == -1 {
+			return 0, nil
+		}
+		line := string(data[:crlfIndex])
+		// chunk extensions after ';' don't affect the body, onChunk gets them
+		sizeText, extensions, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeText), 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk size: %q", line)
+		}
+		if r.onChunk != nil {
+			r.onChunk(size, extensions)
+		}
+		r.remaining = size
+		r.state = parsingChunkData
+		if size == 0 {
+			r.state = parsingTrailers
+		}
+		return crlfIndex + 2, nil
+	case parsingChunkData:
+		take := min(int64(len(data)), r.remaining)
+		r.Body = append(r.Body, data[:take]...)
+		r.remaining -= take
+		if r.remaining == 0 {
+			r.state = parsingChunkEnd
+		}
+		return int(take), nil
+	case parsingChunkEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if string(data[:2]) != crlf {
+			return 0, errors.New("missing CRLF after chunk data")
+		}
+		r.state = parsingChunkSize
+		return 2, nil
+	case parsingTrailers:
+		n, finished, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if finished {
+			r.state = parsed
+		}
+		return n, nil
+	case parsed:
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	default:
+		return 0, fmt.Errorf("unknown state")
+	}
+}
+
+// startBody runs once the headers are complete. Interim responses are put
+// aside and parsing starts over, otherwise the framing decides how the body
+// ends (RFC 9112 6.3).
+func (r *Response) startBody() error {
+	code := r.StatusLine.StatusCode
+	if code >= 100 && code < 200 && code != StatusSwitchingProtocols {
+		r.Interim = append(r.Interim, Interim{StatusLine: r.StatusLine, Headers: r.Headers})
+		r.StatusLine = StatusLine{}
+		r.Headers = headers.NewHeaders()
+		r.state = parsingStatusLine
+		return nil
+	}
+	if !BodyAllowed(r.method, code) {
+		r.state = parsed
+		return nil
+	}
+	if te, err := r.Headers.Get("Transfer-Encoding"); err == nil {
+		codings := headers.ParseList(te)
+		if len(codings) > 0 && strings.EqualFold(codings[len(codings)-1], "chunked") {
+			r.state = parsingChunkSize
+			return nil
+		}
+		// any other final coding is delimited by the connection closing
+		r.remaining = -1
+		r.closeDelimited = true
+		r.state = parsingBody
+		return nil
+	}
+	if cl, err := r.Headers.Get("Content-Length"); err == nil {
+		n, err := strconv.ParseInt(strings.TrimSpace(cl), 10, 64)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid Content-Length: %q", cl)
+		}
+		r.remaining = n
+		r.state = parsingBody
+		if n == 0 {
+			r.state = parsed
+		}
+		return nil
+	}
+	r.remaining = -1
+	r.closeDelimited = true
+	r.state = parsingBody
+	return nil
+}
+
+// BodyAllowed reports whether a response to method with the status code can
+// carry content: never for HEAD, 1xx, 204 and 304 (RFC 9110 6.4.1).
+func BodyAllowed(method string, code StatusCode) bool {
+	if method == "HEAD" {
+		return false
+	}
+	return code >= 200 && code != StatusNoContent && code != StatusNotModified
+}
+
+func statusLineFromString(line string) (*StatusLine, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("malformed status line: %q", line)
+	}
+	versionParts := strings.Split(parts[0], "/")
+	if len(versionParts) != 2 || versionParts[0] != "HTTP" {
+		return nil, fmt.Errorf("malformed http version: %q", parts[0])
+	}
+	if versionParts[1] != "1.1" && versionParts[1] != "1.0" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", versionParts[1])
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 3 || code < 100 {
+		return nil, fmt.Errorf("invalid status code: %q", parts[1])
+	}
+	statusLine := &StatusLine{
+		HttpVersion: versionParts[1],
+		StatusCode:  StatusCode(code),
+	}
+	if len(parts) == 3 {
+		statusLine.ReasonPhrase = parts[2]
+	}
+	return statusLine, nil
+}
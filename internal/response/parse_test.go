@@ -0,0 +1,256 @@
+package response
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestStatusLineParse(t *testing.T) {
+	// Test: Good status line
+	reader := &chunkReader{
+		data:            "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusNotFound, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+
+	// Test: Empty reason phrase
+	reader = &chunkReader{
+		data:            "HTTP/1.0 599 \r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(599), r.StatusLine.StatusCode)
+	assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+
+	// Test: Invalid status lines
+	for _, data := range []string{
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1\r\n\r\n",
+		"ICY 200 OK\r\n\r\n",
+	} {
+		_, err = ResponseFromReader(&chunkReader{data: data, numBytesPerRead: 4}, "GET")
+		require.Error(t, err, data)
+	}
+}
+
+func TestResponseBodyParse(t *testing.T) {
+	// Test: Content-Length body, extra bytes stay buffered
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 13\r\n\r\nhello world!\nHTTP/1.1",
+		numBytesPerRead: 5,
+	}
+	r, err := ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.True(t, r.Done())
+
+	// Test: Body shorter than Content-Length
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 20\r\n\r\npartial",
+		numBytesPerRead: 5,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Chunked body with extensions and trailers
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum\r\n\r\n" +
+			"5;name=value\r\nhello\r\n" +
+			"7\r\n, world\r\n" +
+			"0\r\n" +
+			"X-Sum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", string(r.Body))
+	value, _ := r.Trailers.Get("X-Sum")
+	assert.Equal(t, "abc", value)
+
+	// Test: Bad chunk framing
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloXX0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader, "GET")
+	require.Error(t, err)
+
+	// Test: No framing, read until the connection closes
+	reader = &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nall of it",
+		numBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "all of it", string(r.Body))
+}
+
+func TestBodylessResponses(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		data   string
+	}{
+		{"GET", "HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n"},
+		{"GET", "HTTP/1.1 304 Not Modified\r\nContent-Length: 5\r\n\r\n"},
+		{"HEAD", "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n"},
+		{"HEAD", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"},
+	} {
+		// the reader ends right after the headers, reading a body would fail
+		r, err := ResponseFromReader(&chunkReader{data: tc.data, numBytesPerRead: 3}, tc.method)
+		require.NoError(t, err, tc.data)
+		assert.Empty(t, r.Body)
+		value, _ := r.Headers.Get("Content-Length")
+		if value != "" {
+			assert.Equal(t, "5", value)
+		}
+	}
+}
+
+func TestInterimResponsesParse(t *testing.T) {
+	reader := &chunkReader{
+		data: "HTTP/1.1 100 Continue\r\n\r\n" +
+			"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n" +
+			"HTTP/1.1 201 Created\r\nContent-Length: 2\r\n\r\nok",
+		numBytesPerRead: 7,
+	}
+	r, err := ResponseFromReader(reader, "POST")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCreated, r.StatusLine.StatusCode)
+	assert.Equal(t, "ok", string(r.Body))
+	require.Len(t, r.Interim, 2)
+	assert.Equal(t, StatusContinue, r.Interim[0].StatusLine.StatusCode)
+	assert.Equal(t, StatusEarlyHints, r.Interim[1].StatusLine.StatusCode)
+	value, _ := r.Interim[1].Headers.Get("Link")
+	assert.Equal(t, "</style.css>; rel=preload", value)
+	_, err = r.Headers.Get("Link")
+	require.Error(t, err)
+
+	// Test: 101 is final, the connection belongs to the new protocol
+	reader = &chunkReader{
+		data:            "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n\x81\x02hi",
+		numBytesPerRead: 7,
+	}
+	r, err = ResponseFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusSwitchingProtocols, r.StatusLine.StatusCode)
+	assert.Empty(t, r.Body)
+}
+
+func TestStreamingBodyParse(t *testing.T) {
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"3\r\nabc\r\n3\r\ndef\r\n0\r\nX-Done: yes\r\n\r\n",
+		numBytesPerRead: 2,
+	}
+	r, err := ResponseHeadersFromReader(reader, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, StatusOK, r.StatusLine.StatusCode)
+	assert.False(t, r.Done())
+	sizes := []int64{}
+	r.OnChunk(func(size int64, extensions string) {
+		sizes = append(sizes, size)
+	})
+
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(body))
+	assert.Equal(t, []int64{3, 3, 0}, sizes)
+	assert.True(t, r.Done())
+	value, _ := r.Trailers.Get("X-Done")
+	assert.Equal(t, "yes", value)
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	// what Writer produces, ResponseFromReader reads back
+	buf := &chunkBuffer{}
+	w := &Writer{ResWriter: buf}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("round"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("trip"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := GetDefaultHeaders(0)
+	require.NoError(t, w.WriteTrailers(trailers))
+
+	r, err := ResponseFromReader(&chunkReader{data: buf.String(), numBytesPerRead: 3}, "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "roundtrip", string(r.Body))
+	value, _ := r.Trailers.Get("Content-Type")
+	assert.Equal(t, "text/plain", value)
+}
+
+type chunkBuffer struct {
+	data []byte
+}
+
+func (b *chunkBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *chunkBuffer) String() string {
+	return string(b.data)
+}
+
+func TestResponseReusable(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		reusable bool
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", true},
+		{"HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok", false},
+		{"HTTP/1.1 200 OK\r\n\r\nuntil close", false},
+		{"HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok", false},
+		{"HTTP/1.0 200 OK\r\nConnection: keep-alive\r\nContent-Length: 2\r\n\r\nok", true},
+		{"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nokHTTP/1.1", false},
+	} {
+		r, err := ResponseFromReader(&chunkReader{data: tc.data, numBytesPerRead: 64}, "GET")
+		require.NoError(t, err, tc.data)
+		assert.Equal(t, tc.reusable, r.Reusable(), tc.data)
+	}
+}
@@ -2,21 +2,183 @@ package response
 
 import (
 	"MODULE_NAME/internal/headers"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
 )
 
 type StatusCode int
 
 const (
-	StatusOK            StatusCode = 200
-	StatusBadRequest    StatusCode = 400
-	StatusInternalError StatusCode = 500
+	StatusContinue           StatusCode = 100
+	StatusSwitchingProtocols StatusCode = 101
+	StatusEarlyHints         StatusCode = 103
+
+	StatusOK                   StatusCode = 200
+	StatusCreated              StatusCode = 201
+	StatusAccepted             StatusCode = 202
+	StatusNonAuthoritativeInfo StatusCode = 203
+	StatusNoContent            StatusCode = 204
+	StatusResetContent         StatusCode = 205
+	StatusPartialContent       StatusCode = 206
+
+	StatusMultipleChoices   StatusCode = 300
+	StatusMovedPermanently  StatusCode = 301
+	StatusFound             StatusCode = 302
+	StatusSeeOther          StatusCode = 303
+	StatusNotModified       StatusCode = 304
+	StatusTemporaryRedirect StatusCode = 307
+	StatusPermanentRedirect StatusCode = 308
+
+	StatusBadRequest                  StatusCode = 400
+	StatusUnauthorized                StatusCode = 401
+	StatusForbidden                   StatusCode = 403
+	StatusNotFound                    StatusCode = 404
+	StatusMethodNotAllowed            StatusCode = 405
+	StatusNotAcceptable               StatusCode = 406
+	StatusProxyAuthRequired           StatusCode = 407
+	StatusRequestTimeout              StatusCode = 408
+	StatusConflict                    StatusCode = 409
+	StatusGone                        StatusCode = 410
+	StatusLengthRequired              StatusCode = 411
+	StatusPreconditionFailed          StatusCode = 412
+	StatusContentTooLarge             StatusCode = 413
+	StatusURITooLong                  StatusCode = 414
+	StatusUnsupportedMediaType        StatusCode = 415
+	StatusRangeNotSatisfiable         StatusCode = 416
+	StatusExpectationFailed           StatusCode = 417
+	StatusMisdirectedRequest          StatusCode = 421
+	StatusUnprocessableContent        StatusCode = 422
+	StatusUpgradeRequired             StatusCode = 426
+	StatusPreconditionRequired        StatusCode = 428
+	StatusTooManyRequests             StatusCode = 429
+	StatusRequestHeaderFieldsTooLarge StatusCode = 431
+
+	StatusInternalError           StatusCode = 500
+	StatusNotImplemented          StatusCode = 501
+	StatusBadGateway              StatusCode = 502
+	StatusServiceUnavailable      StatusCode = 503
+	StatusGatewayTimeout          StatusCode = 504
+	StatusHTTPVersionNotSupported StatusCode = 505
 )
 
+var statusText = map[StatusCode]string{
+	StatusContinue:                    "Continue",
+	StatusSwitchingProtocols:          "Switching Protocols",
+	StatusEarlyHints:                  "Early Hints",
+	StatusOK:                          "OK",
+	StatusCreated:                     "Created",
+	StatusAccepted:                    "Accepted",
+	StatusNonAuthoritativeInfo:        "Non-Authoritative Information",
+	StatusNoContent:                   "No Content",
+	StatusResetContent:                "Reset Content",
+	StatusPartialContent:              "Partial Content",
+	StatusMultipleChoices:             "Multiple Choices",
+	StatusMovedPermanently:            "Moved Permanently",
+	StatusFound:                       "Found",
+	StatusSeeOther:                    "See Other",
+	StatusNotModified:                 "Not Modified",
+	StatusTemporaryRedirect:           "Temporary Redirect",
+	StatusPermanentRedirect:           "Permanent Redirect",
+	StatusBadRequest:                  "Bad Request",
+	StatusUnauthorized:                "Unauthorized",
+	StatusForbidden:                   "Forbidden",
+	StatusNotFound:                    "Not Found",
+	StatusMethodNotAllowed:            "Method Not Allowed",
+	StatusNotAcceptable:               "Not Acceptable",
+	StatusProxyAuthRequired:           "Proxy Authentication Required",
+	StatusRequestTimeout:              "Request Timeout",
+	StatusConflict:                    "Conflict",
+	StatusGone:                        "Gone",
+	StatusLengthRequired:              "Length Required",
+	StatusPreconditionFailed:          "Precondition Failed",
+	StatusContentTooLarge:             "Content Too Large",
+	StatusURITooLong:                  "URI Too Long",
+	StatusUnsupportedMediaType:        "Unsupported Media Type",
+	StatusRangeNotSatisfiable:         "Range Not Satisfiable",
+	StatusExpectationFailed:           "Expectation Failed",
+	StatusMisdirectedRequest:          "Misdirected Request",
+	StatusUnprocessableContent:        "Unprocessable Content",
+	StatusUpgradeRequired:             "Upgrade Required",
+	StatusPreconditionRequired:        "Precondition Required",
+	StatusTooManyRequests:             "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	StatusInternalError:               "Internal Server Error",
+	StatusNotImplemented:              "Not Implemented",
+	StatusBadGateway:                  "Bad Gateway",
+	StatusServiceUnavailable:          "Service Unavailable",
+	StatusGatewayTimeout:              "Gateway Timeout",
+	StatusHTTPVersionNotSupported:     "HTTP Version Not Supported",
+}
+
 type Writer struct {
 	ResWriter io.Writer
+
+	// Casing overrides the casing of field names for peers that match them
+	// case-sensitively, lower-cased name to the form to write, e.g.
+	// "x-legacy-id" to "x-legacy-ID". See headers.Headers.WriteWithCasing.
+	Casing map[string]string
+
+	// wroteStatus is set once the final (non 1xx) status line is out
+	wroteStatus bool
+
+	// conn and buffered are what Hijack hands over, see AllowHijack
+	conn     net.Conn
+	buffered func() []byte
+	hijacked bool
+}
+
+var (
+	// ErrNotHijackable is returned by Hijack when the writer doesn't write
+	// to a connection of its own, e.g. inside a middleware.
+	ErrNotHijackable = errors.New("connection can't be hijacked")
+	// ErrHijacked is returned by Hijack the second time, and by every
+	// write after it.
+	ErrHijacked = errors.New("connection was hijacked")
+)
+
+// AllowHijack lets Hijack hand over conn, the connection ResWriter writes
+// to. buffered returns the bytes read off conn that the request didn't
+// consume. The server calls it before running the handler.
+func (w *Writer) AllowHijack(conn net.Conn, buffered func() []byte) {
+	w.conn = conn
+	w.buffered = buffered
+}
+
+// Hijack takes the connection over from the server, for protocols that
+// aren't request/response: WebSocket, CONNECT tunnels and the like. The
+// returned bytes were read off the connection already and come before
+// anything read from it. From then on the server doesn't touch the
+// connection and doesn't close it, the caller does; writes through w fail
+// with ErrHijacked.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.conn == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	w.hijacked = true
+	w.ResWriter = hijackedWriter{}
+	var buffered []byte
+	if w.buffered != nil {
+		buffered = bytes.Clone(w.buffered())
+	}
+	return w.conn, buffered, nil
+}
+
+// Hijacked reports whether Hijack took the connection.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
+
+type hijackedWriter struct{}
+
+func (hijackedWriter) Write(p []byte) (int, error) {
+	return 0, ErrHijacked
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
@@ -24,11 +186,43 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if err != nil {
 		return err
 	}
+	if statusCode >= 200 {
+		w.wroteStatus = true
+	}
 	return nil
 }
+
+// WriteContinue sends the interim "100 Continue" response a client waits for
+// after sending "Expect: 100-continue". It does nothing once the final status
+// line has been written, so a handler that already rejected the request with
+// 413 or 417 never invites the body.
+func (w *Writer) WriteContinue() error {
+	if w.wroteStatus {
+		return nil
+	}
+	return w.WriteInformational(StatusContinue, headers.NewHeaders())
+}
+
+// WriteInformational sends an interim 1xx response with its own headers. It
+// can be called any number of times before the final status line, e.g. to
+// send 103 Early Hints with "Link: </style.css>; rel=preload" while the page
+// is still being built.
+func (w *Writer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	if statusCode < 100 || statusCode > 199 {
+		return fmt.Errorf("not an informational status code: %d", statusCode)
+	}
+	if w.wroteStatus {
+		return fmt.Errorf("informational response after final status line")
+	}
+	err := WriteStatusLine(w.ResWriter, statusCode)
+	if err != nil {
+		return err
+	}
+	return h.WriteWithCasing(w.ResWriter, w.Casing)
+}
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 
-	err := WriteHeaders(w.ResWriter, headers)
+	err := headers.WriteWithCasing(w.ResWriter, w.Casing)
 	if err != nil {
 		return err
 	}
@@ -38,11 +232,11 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 	if len(p) <= 0 {
 		return 0, fmt.Errorf("Empty body write")
 	}
-	_, err := w.ResWriter.Write(p)
+	n, err := w.ResWriter.Write(p)
 	if err != nil {
-		fmt.Errorf(err.Error())
+		return n, err
 	}
-	return len(p), nil
+	return n, nil
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
@@ -79,41 +273,74 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	headerString := ""
-	for key, value := range h {
-		headerString += fmt.Sprintf("%s: %s\r\n", key, value)
+	return h.WriteWithCasing(w.ResWriter, w.Casing)
+}
+
+// BodyWriter adapts the body writes to io.Writer, for io.Copy and
+// compressors: each Write goes out with WriteBody, or as one chunk with
+// WriteChunkedBody when chunked. Empty writes are dropped, an empty chunk
+// would end the body.
+func (w *Writer) BodyWriter(chunked bool) io.Writer {
+	return bodyWriter{w: w, chunked: chunked}
+}
+
+// WriteChunkedFrom copies body as chunks, ends the body and writes the
+// trailers. trailers is only called once body is done, relayed and computed
+// trailers aren't known before; nil sends none. A failed copy leaves the
+// body without its last chunk, so the client can tell it was cut short.
+func (w *Writer) WriteChunkedFrom(body io.Reader, trailers func() headers.Headers) error {
+	if _, err := io.Copy(w.BodyWriter(true), body); err != nil {
+		return err
 	}
-	headerString += "\r\n"
-	_, err := w.ResWriter.Write([]byte(headerString))
-	if err != nil {
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
 		return err
 	}
+	var h headers.Headers
+	if trailers != nil {
+		h = trailers()
+	}
+	return w.WriteTrailers(h)
+}
+
+type bodyWriter struct {
+	w       *Writer
+	chunked bool
+}
+
+func (b bodyWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !b.chunked {
+		return b.w.WriteBody(p)
+	}
+	if _, err := b.w.WriteChunkedBody(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush pushes what was written so far to the client when ResWriter
+// buffers it, i.e. has a Flush method like bufio.Writer. Writes to a
+// net.Conn go out right away, there it does nothing. Streaming handlers
+// call it after each piece the client should see now.
+func (w *Writer) Flush() error {
+	if f, ok := w.ResWriter.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
 	return nil
 }
+
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	switch statusCode {
-	case StatusOK:
-		_, err := w.Write([]byte("HTTP/1.1 200 OK\r\n"))
-		if err != nil {
-			return err
-		}
-	case StatusBadRequest:
-		_, err := w.Write([]byte("HTTP/1.1 400 Bad Request\r\n"))
-		if err != nil {
-			return err
-		}
-	case StatusInternalError:
-		_, err := w.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n"))
-		if err != nil {
-			return err
-		}
-	default:
-		_, err := w.Write([]byte(""))
-		if err != nil {
-			return err
-		}
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("invalid status code: %d", statusCode)
+	}
+	// the reason phrase is optional, unknown codes go out without one
+	reason := statusText[statusCode]
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", statusCode, reason)
+	if err != nil {
+		return err
 	}
-
 	return nil
 }
 
@@ -126,15 +353,8 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	return newHeader
 }
 
-func WriteHeaders(w io.Writer, headers headers.Headers) error {
-	headerString := ""
-	for key, value := range headers {
-		headerString += fmt.Sprintf("%s: %s\r\n", key, value)
-	}
-	headerString += "\r\n"
-	_, err := w.Write([]byte(headerString))
-	if err != nil {
-		return err
-	}
-	return nil
+// WriteHeaders serializes the field lines followed by the empty line, see
+// headers.Headers.Write.
+func WriteHeaders(w io.Writer, h headers.Headers) error {
+	return h.Write(w)
 }
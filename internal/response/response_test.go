@@ -0,0 +1,173 @@
+package response
+
+import (
+	"MODULE_NAME/internal/headers"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInformationalResponses(t *testing.T) {
+	// Test: Early hints followed by the final response
+	buf := &bytes.Buffer{}
+	w := &Writer{ResWriter: buf}
+	h := headers.NewHeaders()
+	h.Set("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, h))
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, h))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: Not a 1xx status
+	w = &Writer{ResWriter: &bytes.Buffer{}}
+	require.Error(t, w.WriteInformational(StatusOK, headers.NewHeaders()))
+
+	// Test: Interim response after the final status line
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.Error(t, w.WriteInformational(StatusEarlyHints, headers.NewHeaders()))
+
+	// Test: 100 Continue is skipped once the final status line is out
+	buf = &bytes.Buffer{}
+	w = &Writer{ResWriter: buf}
+	require.NoError(t, w.WriteContinue())
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", buf.String())
+	buf.Reset()
+	require.NoError(t, w.WriteStatusLine(StatusExpectationFailed))
+	require.NoError(t, w.WriteContinue())
+	assert.Equal(t, "HTTP/1.1 417 Expectation Failed\r\n", buf.String())
+}
+
+func TestBodyWriter(t *testing.T) {
+	// Test: Plain body, empty writes dropped
+	buf := &bytes.Buffer{}
+	w := &Writer{ResWriter: buf}
+	_, err := io.Copy(w.BodyWriter(false), strings.NewReader("hello"))
+	require.NoError(t, err)
+	n, err := w.BodyWriter(false).Write(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, "hello", buf.String())
+
+	// Test: Chunked body, an empty write doesn't end it
+	buf = &bytes.Buffer{}
+	w = &Writer{ResWriter: buf}
+	body := w.BodyWriter(true)
+	n, err = body.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	_, err = body.Write(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "5\r\nhello\r\n", buf.String())
+
+	// Test: Relayed chunked body, trailers asked for once it's done
+	buf = &bytes.Buffer{}
+	w = &Writer{ResWriter: buf}
+	read := false
+	trailers := func() headers.Headers {
+		read = true
+		h := headers.NewHeaders()
+		h.Set("X-Checksum", "abc")
+		return h
+	}
+	src := io.MultiReader(strings.NewReader("hello"), readerFunc(func(p []byte) (int, error) {
+		assert.False(t, read)
+		return 0, io.EOF
+	}))
+	require.NoError(t, w.WriteChunkedFrom(src, trailers))
+	assert.Equal(t, "5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n", buf.String())
+
+	// Test: A failed copy leaves the body without its last chunk
+	buf = &bytes.Buffer{}
+	w = &Writer{ResWriter: buf}
+	src = io.MultiReader(strings.NewReader("hello"), readerFunc(func(p []byte) (int, error) {
+		return 0, io.ErrUnexpectedEOF
+	}))
+	require.ErrorIs(t, w.WriteChunkedFrom(src, nil), io.ErrUnexpectedEOF)
+	assert.Equal(t, "5\r\nhello\r\n", buf.String())
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestWriteHeadersValidation(t *testing.T) {
+	// Test: Value with CRLF is refused and nothing is written
+	buf := &bytes.Buffer{}
+	h := headers.NewHeaders()
+	h.Set("Location", "/next\r\nSet-Cookie: session=stolen")
+	require.Error(t, WriteHeaders(buf, h))
+	assert.Equal(t, 0, buf.Len())
+
+	// Test: Invalid field name
+	h = headers.NewHeaders()
+	h.Set("Bad Name", "value")
+	require.Error(t, WriteHeaders(buf, h))
+
+	// Test: Trailers go through the same checks
+	w := &Writer{ResWriter: buf}
+	h = headers.NewHeaders()
+	h.Set("X-Digest", "abc\x00")
+	require.Error(t, w.WriteTrailers(h))
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestWriteHeadersCasing(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := headers.NewHeaders()
+	h.Set("transfer-encoding", "chunked")
+	require.NoError(t, WriteHeaders(buf, h))
+	assert.Equal(t, "Transfer-Encoding: chunked\r\n\r\n", buf.String())
+
+	// Test: The writer's overrides apply to its headers and trailers only
+	buf.Reset()
+	h = headers.NewHeaders()
+	h.Set("X-Legacy-ID", "7")
+	w := &Writer{ResWriter: buf, Casing: map[string]string{"x-legacy-id": "x-legacy-ID"}}
+	require.NoError(t, w.WriteHeaders(h))
+	require.NoError(t, w.WriteTrailers(h))
+	assert.Equal(t, "x-legacy-ID: 7\r\n\r\nx-legacy-ID: 7\r\n\r\n", buf.String())
+	buf.Reset()
+	require.NoError(t, WriteHeaders(buf, h))
+	assert.Equal(t, "X-Legacy-Id: 7\r\n\r\n", buf.String())
+
+	// Test: An override that isn't the same name can't go on the wire
+	for _, bad := range []string{"X\r\nInjected: 1", "X-Other-ID", "x legacy id"} {
+		buf.Reset()
+		w = &Writer{ResWriter: buf, Casing: map[string]string{"x-legacy-id": bad}}
+		require.Error(t, w.WriteHeaders(h), bad)
+		assert.Equal(t, 0, buf.Len(), bad)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	// Test: A writer of its own can't be hijacked
+	w := &Writer{ResWriter: &bytes.Buffer{}}
+	_, _, err := w.Hijack()
+	assert.ErrorIs(t, err, ErrNotHijackable)
+	assert.False(t, w.Hijacked())
+
+	// Test: The connection comes with the bytes read ahead, once
+	server, client := net.Pipe()
+	defer client.Close()
+	w = &Writer{ResWriter: server}
+	w.AllowHijack(server, func() []byte { return []byte("ahead") })
+	conn, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.Equal(t, "ahead", string(buffered))
+	assert.True(t, w.Hijacked())
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ErrHijacked)
+
+	// Test: Writes through the writer fail afterwards
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrHijacked)
+	_, err = w.WriteBody([]byte("x"))
+	assert.ErrorIs(t, err, ErrHijacked)
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// CompressOptions configure CompressWithOptions.
+type CompressOptions struct {
+	// MinSize is the smallest body worth compressing, 1024 bytes when 0.
+	// Smaller ones would barely shrink, or even grow, for the CPU spent.
+	MinSize int
+	// Level is the gzip/zlib compression level, gzip.DefaultCompression
+	// when 0.
+	Level int
+}
+
+const defaultMinSize = 1024
+
+// incompressible are the media types that are compressed already, or that
+// must reach the client as they are written.
+var incompressible = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/pdf",
+	"application/octet-stream",
+	"text/event-stream",
+}
+
+// Compress wraps next so its responses are compressed with gzip or deflate
+// when the client accepts it, see CompressWithOptions.
+func Compress(next Handler) Handler {
+	return CompressWithOptions(next, CompressOptions{})
+}
+
+// CompressWithOptions wraps next so its responses are compressed with the
+// coding the request's Accept-Encoding prefers. Bodies smaller than
+// MinSize, media that is compressed already, partial content and responses
+// that already have a Content-Encoding go out as they are. Compressed
+// bodies lose their Content-Length and are sent chunked, their ETag gets
+// the coding appended so caches can tell both apart. next runs as usual:
+// what it writes is parsed back and rewritten.
+func CompressWithOptions(next Handler, opts CompressOptions) Handler {
+	if opts.MinSize == 0 {
+		opts.MinSize = defaultMinSize
+	}
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	return func(w *response.Writer, req *request.Request) {
+		coding := negotiateEncoding(req.Headers)
+		// the validators the client has are those of a compressed response,
+		// next only knows the uncompressed ones. Only a response in coding
+		// could have had them, tags like "v1-gzip" are the application's
+		// own otherwise.
+		revalidating := false
+		for _, key := range []string{"If-Match", "If-None-Match", "If-Range"} {
+			if value, err := req.Headers.Get(key); err == nil && coding != "" {
+				stripped := stripCodingFromETags(value, coding)
+				revalidating = revalidating || (stripped != value && key == "If-None-Match")
+				req.Headers.SetOVR(key, stripped)
+			}
+		}
+
+		err := RelayResponse(req.RequestLine.Method, func(w *response.Writer) { next(w, req) }, func(resp *response.Response) error {
+			return relayCompressed(w, req, resp, coding, revalidating, opts)
+		})
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			log.Printf("compress: %v", err)
+		}
+	}
+}
+
+// relayCompressed writes the handler's response to w, compressed with
+// coding when that's worth it. revalidating is set when the client's
+// If-None-Match named a compressed response.
+func relayCompressed(w *response.Writer, req *request.Request, resp *response.Response, coding string, revalidating bool, opts CompressOptions) error {
+	method := req.RequestLine.Method
+	for _, interim := range resp.Interim {
+		w.WriteInformational(interim.StatusLine.StatusCode, interim.Headers)
+	}
+
+	h := resp.Headers
+	code := resp.StatusLine.StatusCode
+	// a HEAD answers with the headers of the GET, compressed ones included
+	if !response.BodyAllowed(method, code) && (method != "HEAD" || !response.BodyAllowed("GET", code)) {
+		// the 304 confirms the tag the client has
+		if etag, err := h.Get("ETag"); err == nil && code == response.StatusNotModified && revalidating && coding != "" {
+			h.SetOVR("ETag", addCodingToETag(etag, coding))
+			addVary(h, "Accept-Encoding")
+		}
+		w.WriteStatusLine(code)
+		return w.WriteHeaders(h)
+	}
+	var body io.Reader = resp.BodyReader()
+	if !compressible(resp) {
+		return relayPlain(w, req, resp, body)
+	}
+	addVary(h, "Accept-Encoding")
+	if coding == "" {
+		return relayPlain(w, req, resp, body)
+	}
+
+	// a short body isn't worth it, peek at the start to find out
+	if length, err := h.Get("Content-Length"); err == nil {
+		if n, err := strconv.Atoi(length); err == nil && n < opts.MinSize {
+			return relayPlain(w, req, resp, body)
+		}
+	}
+	var start []byte
+	if method != "HEAD" {
+		start = make([]byte, opts.MinSize)
+		n, err := io.ReadFull(body, start)
+		start = start[:n]
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			return relayPlain(w, req, resp, bytes.NewReader(start))
+		}
+	}
+
+	h.Delete("Content-Length")
+	h.SetOVR("Content-Encoding", coding)
+	if etag, err := h.Get("ETag"); err == nil {
+		h.SetOVR("ETag", addCodingToETag(etag, coding))
+	}
+	h.SetOVR("Transfer-Encoding", "chunked")
+	w.WriteStatusLine(code)
+	if err := w.WriteHeaders(h); err != nil || method == "HEAD" {
+		return err
+	}
+
+	out := w.BodyWriter(true)
+	var compressor io.WriteCloser
+	var err error
+	if coding == "gzip" {
+		compressor, err = gzip.NewWriterLevel(out, opts.Level)
+	} else {
+		compressor, err = zlib.NewWriterLevel(out, opts.Level)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := compressor.Write(start); err != nil {
+		return err
+	}
+	if _, err := io.Copy(compressor, body); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return w.WriteTrailers(resp.Trailers)
+}
+
+// relayPlain writes the response with the framing the handler chose.
+func relayPlain(w *response.Writer, req *request.Request, resp *response.Response, body io.Reader) error {
+	w.WriteStatusLine(resp.StatusLine.StatusCode)
+	if err := w.WriteHeaders(resp.Headers); err != nil || req.RequestLine.Method == "HEAD" {
+		return err
+	}
+	te, _ := resp.Headers.Get("Transfer-Encoding")
+	chunked := strings.EqualFold(te, "chunked")
+	if chunked {
+		return w.WriteChunkedFrom(body, func() headers.Headers { return resp.Trailers })
+	}
+	_, err := io.Copy(w.BodyWriter(false), body)
+	return err
+}
+
+// compressible reports whether compressing the response is an option at
+// all, whatever the client accepts.
+func compressible(resp *response.Response) bool {
+	h := resp.Headers
+	if _, err := h.Get("Content-Encoding"); err == nil {
+		return false
+	}
+	// a range of the compressed bytes isn't a range of the file
+	if resp.StatusLine.StatusCode == response.StatusPartialContent {
+		return false
+	}
+	if _, err := h.Get("Content-Range"); err == nil {
+		return false
+	}
+	cc, err := h.CacheControl()
+	if err == nil && cc.Has("no-transform") {
+		return false
+	}
+	if announcesDigest(h) {
+		return false
+	}
+	mediaType, _, err := h.ContentType()
+	if err != nil {
+		// unknown content, better left alone
+		return false
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, prefix := range incompressible {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// announcesDigest reports whether the response carries digests of its body,
+// in its headers or announced as trailers: the proxy's Content-Digest,
+// Repr-Digest and X-Content-* fields. They are computed over the bytes as
+// they are, a compressed body would fail every check.
+func announcesDigest(h headers.Headers) bool {
+	for _, key := range []string{"Content-Digest", "Repr-Digest"} {
+		if _, err := h.Get(key); err == nil {
+			return true
+		}
+	}
+	for _, name := range h.Values("Trailer") {
+		name = strings.ToLower(name)
+		if name == "content-digest" || name == "repr-digest" || strings.HasPrefix(name, "x-content-") {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding, or ""
+// when the client wants neither, or gives the identity more weight (RFC
+// 9110 12.5.3). Without the field nothing is compressed.
+func negotiateEncoding(h headers.Headers) string {
+	value, err := h.Get("Accept-Encoding")
+	if err != nil {
+		return ""
+	}
+	entries, err := headers.ParseAccept(value)
+	if err != nil {
+		return ""
+	}
+	weights := map[string]float64{}
+	star := -1.0
+	for _, entry := range entries {
+		if entry.Value == "*" {
+			star = entry.Q
+			continue
+		}
+		if entry.Value == "x-gzip" {
+			// an old alias of gzip
+			entry.Value = "gzip"
+		}
+		if _, seen := weights[entry.Value]; !seen {
+			weights[entry.Value] = entry.Q
+		}
+	}
+	weight := func(coding string) float64 {
+		if q, ok := weights[coding]; ok {
+			return q
+		}
+		if star >= 0 {
+			return star
+		}
+		// identity is still acceptable, it just doesn't outweigh the
+		// codings the client listed
+		return 0
+	}
+
+	best, bestQ := "", 0.0
+	// in our order of preference, the first wins a tie
+	for _, coding := range []string{"gzip", "deflate"} {
+		if q := weight(coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	if best == "" || bestQ < weight("identity") {
+		return ""
+	}
+	return best
+}
+
+func addVary(h headers.Headers, name string) {
+	for _, existing := range h.Values("Vary") {
+		if existing == "*" || strings.EqualFold(existing, name) {
+			return
+		}
+	}
+	h.Set("Vary", name)
+}
+
+// addCodingToETag turns `"abc"` into `"abc-gzip"`, weak tags staying weak.
+func addCodingToETag(etag string, coding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+}
+
+// stripCodingFromETags undoes addCodingToETag for every tag of a list.
+func stripCodingFromETags(value string, coding string) string {
+	return strings.ReplaceAll(value, "-"+coding+`"`, `"`)
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gunzip(t *testing.T, p []byte) string {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// staticHandler answers with body and the given headers, chunked when
+// there is no Content-Length.
+func staticHandler(body string, fields map[string]string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		v := Validators{ETag: `"v1"`}
+		h := response.GetDefaultHeaders(len(body))
+		v.Set(h)
+		for key, value := range fields {
+			if value == "" {
+				h.Delete(key)
+				continue
+			}
+			h.SetOVR(key, value)
+		}
+		if CheckPreconditions(w, req, v, h) {
+			return
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		if req.RequestLine.Method == "HEAD" {
+			return
+		}
+		if _, err := h.Get("Content-Length"); err == nil {
+			w.WriteBody([]byte(body))
+			return
+		}
+		for len(body) > 0 {
+			n := min(len(body), 100)
+			w.WriteChunkedBody([]byte(body[:n]))
+			body = body[n:]
+		}
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "abc")
+		w.WriteTrailers(trailers)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	page := strings.Repeat("<p>hello, compressed world</p>\n", 200)
+	handler := Compress(staticHandler(page, map[string]string{"Content-Type": "text/html"}))
+
+	// Test: gzip when accepted, chunked without Content-Length
+	resp, body := serveRaw(t, handler, get("/", "Accept-Encoding: gzip, deflate\r\n"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `"v1-gzip"`, resp.Header.Get("ETag"))
+	assert.Less(t, len(body), len(page)/4)
+	assert.Equal(t, page, gunzip(t, body))
+
+	// Test: deflate when it weighs more
+	resp, body = serveRaw(t, handler, get("/", "Accept-Encoding: gzip;q=0.5, deflate\r\n"))
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(data))
+
+	// Test: Nothing accepted, the body goes out as is but still varies
+	for _, accept := range []string{"", "Accept-Encoding: br\r\n", "Accept-Encoding: gzip;q=0\r\n",
+		"Accept-Encoding: gzip;q=0.5, identity\r\n"} {
+		resp, body = serveRaw(t, handler, get("/", accept))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"), accept)
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"), accept)
+		assert.Equal(t, `"v1"`, resp.Header.Get("ETag"), accept)
+		assert.Equal(t, page, string(body), accept)
+	}
+
+	// Test: HEAD has the headers of the compressed GET
+	resp, body = serveRaw(t, handler, "HEAD / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, body)
+
+	// Test: Revalidating the compressed tag is a 304 with that tag
+	resp, _ = serveRaw(t, handler, get("/", "Accept-Encoding: gzip\r\nIf-None-Match: \"v1-gzip\"\r\n"))
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, `"v1-gzip"`, resp.Header.Get("ETag"))
+
+	// Test: Tags are left alone unless they carry the negotiated coding,
+	// the application's own may end the same way
+	var seen string
+	recording := Compress(func(w *response.Writer, req *request.Request) {
+		seen, _ = req.Headers.Get("If-None-Match")
+		staticHandler(page, map[string]string{"Content-Type": "text/html"})(w, req)
+	})
+	for _, accept := range []string{"", "Accept-Encoding: deflate\r\n"} {
+		resp, _ = serveRaw(t, recording, get("/", accept+"If-None-Match: \"v1-gzip\"\r\n"))
+		assert.Equal(t, `"v1-gzip"`, seen, accept)
+		assert.Equal(t, 200, resp.StatusCode, accept)
+	}
+	serveRaw(t, recording, get("/", "Accept-Encoding: gzip\r\nIf-None-Match: \"v1-gzip\"\r\n"))
+	assert.Equal(t, `"v1"`, seen)
+}
+
+func TestCompressSkips(t *testing.T) {
+	large := strings.Repeat("x", 4096)
+	gzipped := "gzip, deflate"
+
+	// Test: Small bodies, known or chunked, aren't worth it
+	for _, fields := range []map[string]string{
+		{"Content-Type": "text/plain"},
+		{"Content-Type": "text/plain", "Content-Length": "", "Transfer-Encoding": "chunked"},
+	} {
+		handler := Compress(staticHandler("tiny", fields))
+		resp, body := serveRaw(t, handler, get("/", "Accept-Encoding: "+gzipped+"\r\n"))
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "tiny", string(body))
+	}
+
+	// Test: Compressed media, unknown types, no-transform and existing
+	// codings are left alone, without Vary
+	for _, fields := range []map[string]string{
+		{"Content-Type": "image/png"},
+		{"Content-Type": "video/mp4"},
+		{"Content-Type": "text/event-stream"},
+		{"Content-Type": ""},
+		{"Content-Type": "text/plain", "Cache-Control": "no-transform"},
+		{"Content-Type": "text/plain", "Content-Encoding": "br"},
+	} {
+		handler := Compress(staticHandler(large, fields))
+		resp, body := serveRaw(t, handler, get("/", "Accept-Encoding: "+gzipped+"\r\n"))
+		assert.Equal(t, fields["Content-Encoding"], resp.Header.Get("Content-Encoding"), fields)
+		assert.Empty(t, resp.Header.Get("Vary"), fields)
+		assert.Equal(t, large, string(body), fields)
+	}
+
+	// Test: SVG is an image but compresses well
+	handler := Compress(staticHandler(large, map[string]string{"Content-Type": "image/svg+xml"}))
+	resp, _ := serveRaw(t, handler, get("/", "Accept-Encoding: gzip\r\n"))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	// Test: Partial content stays as it is
+	root := writeTree(t, map[string]string{"big.txt": large})
+	resp, body := serveRaw(t, Compress(FileServer(root)), get("/big.txt", "Accept-Encoding: gzip\r\nRange: bytes=0-1999\r\n"))
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, large[:2000], string(body))
+}
+
+func TestCompressFraming(t *testing.T) {
+	large := strings.Repeat("streamed data ", 500)
+	handler := Compress(staticHandler(large, map[string]string{
+		"Content-Type":      "text/plain",
+		"Content-Length":    "",
+		"Transfer-Encoding": "chunked",
+		"Trailer":           "X-Checksum",
+	}))
+
+	// Test: A chunked body of unknown length is compressed, trailers kept
+	resp, body := serveRaw(t, handler, get("/", "Accept-Encoding: gzip\r\n"))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, large, gunzip(t, body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+
+	// Test: Digests of the body, like the proxy's, keep it uncompressed so
+	// they still match
+	sum := sha256.Sum256([]byte(large))
+	digested := Compress(func(w *response.Writer, req *request.Request) {
+		h := headers.NewHeaders()
+		h.Set("Content-Type", "text/plain")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "Content-Digest, X-Content-Sha256")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte(large))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		trailers.Set("X-Content-Sha256", hex.EncodeToString(sum[:]))
+		w.WriteTrailers(trailers)
+	})
+	resp, body = serveRaw(t, digested, get("/", "Accept-Encoding: gzip\r\n"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, large, string(body))
+	bodySum := sha256.Sum256(body)
+	assert.Equal(t, hex.EncodeToString(bodySum[:]), resp.Trailer.Get("X-Content-Sha256"))
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(bodySum[:])+":", resp.Trailer.Get("Content-Digest"))
+
+	// Test: A handler that writes nothing stays silent
+	silent := Compress(func(w *response.Writer, req *request.Request) {})
+	out := &bytes.Buffer{}
+	req, err := request.RequestFromReader(strings.NewReader(get("/")))
+	require.NoError(t, err)
+	silent(&response.Writer{ResWriter: out}, req)
+	assert.Empty(t, out.String())
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                              "",
+		"gzip":                          "gzip",
+		"x-gzip":                        "gzip",
+		"deflate":                       "deflate",
+		"deflate, gzip":                 "gzip",
+		"gzip;q=0.8, deflate;q=0.9":     "deflate",
+		"br, zstd":                      "",
+		"*":                             "gzip",
+		"*;q=0.5, gzip;q=0":             "deflate",
+		"gzip;q=0.5, identity":          "",
+		"gzip, identity;q=0.5":          "gzip",
+		"identity":                      "",
+		"gzip;q=invalid":                "",
+		"GZIP":                          "gzip",
+		"*;q=0":                         "",
+		"br;q=1, gzip;q=0.1, *;q=0.001": "gzip",
+	}
+	for value, want := range tests {
+		h := headers.NewHeaders()
+		if value != "" {
+			h.Set("Accept-Encoding", value)
+		}
+		assert.Equal(t, want, negotiateEncoding(h), value)
+	}
+}
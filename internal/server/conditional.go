@@ -0,0 +1,177 @@
+package server
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Validators identify the representation a response carries, so clients
+// can make their requests conditional on it (RFC 9110 8.8). Either may be
+// empty.
+type Validators struct {
+	// ETag is a quoted entity-tag, W/ prefixed when weak.
+	ETag         string
+	LastModified time.Time
+}
+
+// ETag quotes opaque into an entity-tag. A weak tag says the content is
+// equivalent but not byte for byte the same, it can't be used for ranges.
+func ETag(opaque string, weak bool) string {
+	tag := `"` + opaque + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// BytesETag is an entity-tag derived from the content itself.
+func BytesETag(p []byte, weak bool) string {
+	sum := sha256.Sum256(p)
+	return ETag(base64.RawURLEncoding.EncodeToString(sum[:18]), weak)
+}
+
+// FileETag is an entity-tag derived from the size and modification time of
+// a file, cheap enough to compute on every request.
+func FileETag(info fs.FileInfo, weak bool) string {
+	return ETag(fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()), weak)
+}
+
+// Set adds ETag and Last-Modified to h.
+func (v Validators) Set(h headers.Headers) {
+	if v.ETag != "" {
+		h.SetOVR("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.SetOVR("Last-Modified", headers.FormatTime(v.LastModified))
+	}
+}
+
+// CheckPreconditions evaluates the conditional fields of req against v in
+// the order of RFC 9110 13.2.2. When one fails it writes the 304 or 412 and
+// returns true, the handler is done. h are the headers the response would
+// have had, the 304 carries them but for those describing the content.
+// If-Range is left to IfRange since it only decides whether a Range
+// applies.
+func CheckPreconditions(w *response.Writer, req *request.Request, v Validators, h headers.Headers) bool {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+	modTime := v.LastModified.Truncate(time.Second)
+
+	if value, err := req.Headers.Get("If-Match"); err == nil {
+		if !matchETag(value, v.ETag, true) {
+			writePreconditionFailed(w)
+			return true
+		}
+	} else if since, ok := conditionTime(req, "If-Unmodified-Since", v); ok {
+		if modTime.After(since) {
+			writePreconditionFailed(w)
+			return true
+		}
+	}
+
+	if value, err := req.Headers.Get("If-None-Match"); err == nil {
+		if matchETag(value, v.ETag, false) {
+			if safe {
+				writeNotModified(w, v, h)
+			} else {
+				writePreconditionFailed(w)
+			}
+			return true
+		}
+	} else if since, ok := conditionTime(req, "If-Modified-Since", v); ok && safe {
+		if !modTime.After(since) {
+			writeNotModified(w, v, h)
+			return true
+		}
+	}
+	return false
+}
+
+// IfRange reports whether the Range of req still applies, i.e. there is no
+// If-Range or it names the current representation (RFC 9110 13.1.5). Only
+// a strong match counts, a date has to be Last-Modified exactly.
+func (v Validators) IfRange(req *request.Request) bool {
+	value, err := req.Headers.Get("If-Range")
+	if err != nil {
+		return true
+	}
+	value = strings.Trim(value, " \t")
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return matchETag(value, v.ETag, true)
+	}
+	t, err := headers.ParseTime(value)
+	if err != nil || v.LastModified.IsZero() {
+		return false
+	}
+	return t.Equal(v.LastModified.Truncate(time.Second))
+}
+
+// conditionTime returns the date of a date condition, which is ignored
+// when it isn't a single valid HTTP-date or there is no Last-Modified.
+func conditionTime(req *request.Request, key string, v Validators) (time.Time, bool) {
+	if v.LastModified.IsZero() {
+		return time.Time{}, false
+	}
+	t, err := req.Headers.Time(key)
+	return t, err == nil
+}
+
+// matchETag compares the current tag with an If-Match or If-None-Match
+// list, "*" matching any. Strong comparison needs both tags strong, weak
+// comparison only looks at the opaque part (RFC 9110 8.8.3.2).
+func matchETag(list string, current string, strong bool) bool {
+	if strings.Trim(list, " \t") == "*" {
+		return true
+	}
+	if current == "" {
+		return false
+	}
+	currentWeak, currentOpaque := splitETag(current)
+	for _, element := range headers.ParseList(list) {
+		weak, opaque := splitETag(element)
+		if opaque != currentOpaque {
+			continue
+		}
+		if !strong || (!weak && !currentWeak) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitETag(tag string) (bool, string) {
+	weak := strings.HasPrefix(tag, "W/")
+	return weak, strings.TrimPrefix(tag, "W/")
+}
+
+// contentFields describe the content of a response, a 304 has none.
+var contentFields = []string{"Content-Length", "Content-Type", "Content-Encoding", "Content-Language", "Content-Range", "Transfer-Encoding", "Trailer"}
+
+// writeNotModified sends a 304 with the fields the 200 would have had, h
+// and the validators, Date, Vary and Cache-Control among them as RFC 9110
+// 15.4.5 wants. The content fields are left out, a Content-Length would be
+// the length of the file.
+func writeNotModified(w *response.Writer, v Validators, h headers.Headers) {
+	h = h.Clone()
+	for _, key := range contentFields {
+		h.Delete(key)
+	}
+	if _, err := h.Get("Date"); err != nil {
+		h.Set("Date", headers.FormatTime(time.Now()))
+	}
+	h.SetOVR("Connection", "close")
+	v.Set(h)
+	w.WriteStatusLine(response.StatusNotModified)
+	w.WriteHeaders(h)
+}
+
+func writePreconditionFailed(w *response.Writer) {
+	writeError(w, response.StatusPreconditionFailed)
+}
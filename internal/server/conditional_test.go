@@ -0,0 +1,131 @@
+package server
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETags(t *testing.T) {
+	// Test: Strong and weak tags
+	assert.Equal(t, `"abc"`, ETag("abc", false))
+	assert.Equal(t, `W/"abc"`, ETag("abc", true))
+
+	// Test: Content tags change with the content
+	assert.Equal(t, BytesETag([]byte("hello"), false), BytesETag([]byte("hello"), false))
+	assert.NotEqual(t, BytesETag([]byte("hello"), false), BytesETag([]byte("hellO"), false))
+	assert.True(t, strings.HasPrefix(BytesETag([]byte("hello"), true), `W/"`))
+
+	// Test: File tags change with the size and modification time
+	p := filepath.Join(t.TempDir(), "f")
+	require.NoError(t, os.WriteFile(p, []byte("one"), 0o644))
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	before := FileETag(info, false)
+	require.NoError(t, os.Chtimes(p, time.Time{}, info.ModTime().Add(time.Second)))
+	info, err = os.Stat(p)
+	require.NoError(t, err)
+	assert.NotEqual(t, before, FileETag(info, false))
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	v := Validators{ETag: `"v2"`, LastModified: modTime}
+	handler := func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(2)
+		h.Set("Vary", "Accept")
+		h.Set("Cache-Control", "max-age=60")
+		v.Set(h)
+		if CheckPreconditions(w, req, v, h) {
+			return
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("ok"))
+	}
+	before := headers.FormatTime(modTime.Add(-time.Hour))
+	at := headers.FormatTime(modTime)
+	after := headers.FormatTime(modTime.Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		method string
+		fields string
+		status int
+	}{
+		{"no conditions", "GET", "", 200},
+		{"If-None-Match hit", "GET", "If-None-Match: \"v1\", \"v2\"\r\n", 304},
+		{"If-None-Match weak hit", "GET", "If-None-Match: W/\"v2\"\r\n", 304},
+		{"If-None-Match miss", "GET", "If-None-Match: \"v1\"\r\n", 200},
+		{"If-None-Match star", "HEAD", "If-None-Match: *\r\n", 304},
+		{"If-None-Match on a POST", "POST", "If-None-Match: \"v2\"\r\n", 412},
+		{"If-Modified-Since not modified", "GET", "If-Modified-Since: " + at + "\r\n", 304},
+		{"If-Modified-Since modified", "GET", "If-Modified-Since: " + before + "\r\n", 200},
+		{"If-Modified-Since invalid date", "GET", "If-Modified-Since: yesterday\r\n", 200},
+		{"If-Modified-Since ignored on a POST", "POST", "If-Modified-Since: " + after + "\r\n", 200},
+		{"If-None-Match wins over If-Modified-Since", "GET",
+			"If-None-Match: \"v1\"\r\nIf-Modified-Since: " + after + "\r\n", 200},
+		{"If-Match hit", "PUT", "If-Match: \"v2\"\r\n", 200},
+		{"If-Match miss", "PUT", "If-Match: \"v1\"\r\n", 412},
+		{"If-Match weak never matches", "PUT", "If-Match: W/\"v2\"\r\n", 412},
+		{"If-Match star", "PUT", "If-Match: *\r\n", 200},
+		{"If-Unmodified-Since ok", "PUT", "If-Unmodified-Since: " + at + "\r\n", 200},
+		{"If-Unmodified-Since modified", "PUT", "If-Unmodified-Since: " + before + "\r\n", 412},
+		{"If-Match wins over If-Unmodified-Since", "PUT",
+			"If-Match: \"v2\"\r\nIf-Unmodified-Since: " + before + "\r\n", 200},
+		{"412 comes before 304", "GET",
+			"If-Match: \"v1\"\r\nIf-None-Match: \"v2\"\r\n", 412},
+	}
+	for _, tt := range tests {
+		// Test: Each condition alone and in precedence
+		raw := tt.method + " /doc HTTP/1.1\r\nHost: localhost\r\n" + tt.fields + "\r\n"
+		resp, body := serveRaw(t, handler, raw)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+		if tt.status == 304 {
+			assert.Empty(t, body, tt.name)
+			assert.Equal(t, `"v2"`, resp.Header.Get("ETag"), tt.name)
+			assert.Equal(t, at, resp.Header.Get("Last-Modified"), tt.name)
+			assert.Empty(t, resp.Header.Get("Content-Length"), tt.name)
+			assert.Empty(t, resp.Header.Get("Content-Type"), tt.name)
+			// Test: The 304 keeps the fields that aren't about the content
+			assert.Equal(t, "Accept", resp.Header.Get("Vary"), tt.name)
+			assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"), tt.name)
+			assert.NotEmpty(t, resp.Header.Get("Date"), tt.name)
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	strong := Validators{ETag: `"v2"`, LastModified: modTime}
+	weak := Validators{ETag: `W/"v2"`, LastModified: modTime}
+	ifRange := func(v Validators, value string) bool {
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+		if value != "" {
+			raw += "If-Range: " + value + "\r\n"
+		}
+		req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+		return v.IfRange(req)
+	}
+
+	// Test: Without If-Range the Range applies
+	assert.True(t, ifRange(strong, ""))
+	// Test: Entity-tags need a strong match
+	assert.True(t, ifRange(strong, `"v2"`))
+	assert.False(t, ifRange(strong, `"v1"`))
+	assert.False(t, ifRange(strong, `W/"v2"`))
+	assert.False(t, ifRange(weak, `"v2"`))
+	// Test: Dates have to be Last-Modified exactly
+	assert.True(t, ifRange(strong, headers.FormatTime(modTime)))
+	assert.False(t, ifRange(strong, headers.FormatTime(modTime.Add(time.Second))))
+	assert.False(t, ifRange(strong, "garbage"))
+}
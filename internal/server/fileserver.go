@@ -0,0 +1,517 @@
+package server
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FileServerOptions configure FileServerWithOptions.
+type FileServerOptions struct {
+	// StripPrefix is cut from the request path before it is looked up under
+	// the root, e.g. "/assets" serves "/assets/app.js" from "<root>/app.js".
+	StripPrefix string
+	// IndexFile is served for a directory that has one, "index.html" when
+	// empty.
+	IndexFile string
+	// Listings shows the content of directories without an index file, as
+	// HTML or, when the client asks for it, JSON. Without it they are 404.
+	Listings bool
+}
+
+// FileServer serves the files under root for GET and HEAD.
+func FileServer(root string) Handler {
+	return FileServerWithOptions(root, FileServerOptions{})
+}
+
+// FileServerWithOptions is FileServer with a prefix, listings and another
+// index file. The request path can't leave root: it is cleaned, and the
+// files are opened through an os.Root so symlinks pointing outside fail
+// too. Names starting with a dot, e.g. .git or .env, are never served.
+func FileServerWithOptions(root string, opts FileServerOptions) Handler {
+	if opts.IndexFile == "" {
+		opts.IndexFile = "index.html"
+	}
+	fsrv := &fileServer{root: root, options: opts}
+	return fsrv.handle
+}
+
+type fileServer struct {
+	root    string
+	options FileServerOptions
+}
+
+func (fsrv *fileServer) handle(w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		h := response.GetDefaultHeaders(0)
+		h.Set("Allow", "GET, HEAD")
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.WriteHeaders(h)
+		return
+	}
+	u, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+	if err != nil || strings.ContainsRune(u.Path, 0) {
+		writeError(w, response.StatusBadRequest)
+		return
+	}
+	name, ok := fsrv.name(u.Path)
+	if !ok {
+		writeError(w, response.StatusNotFound)
+		return
+	}
+
+	root, err := os.OpenRoot(fsrv.root)
+	if err != nil {
+		log.Printf("fileserver: %v", err)
+		writeError(w, response.StatusInternalError)
+		return
+	}
+	defer root.Close()
+
+	f, info, err := open(root, name)
+	if err != nil {
+		writeError(w, errorStatus(err))
+		return
+	}
+	defer f.Close()
+
+	if info.IsDir() {
+		// relative links in the page only work below a trailing slash
+		if !strings.HasSuffix(u.Path, "/") {
+			location := u.EscapedPath() + "/"
+			if u.RawQuery != "" {
+				location += "?" + u.RawQuery
+			}
+			h := response.GetDefaultHeaders(0)
+			h.Set("Location", location)
+			w.WriteStatusLine(response.StatusMovedPermanently)
+			w.WriteHeaders(h)
+			return
+		}
+		index, indexInfo, err := open(root, path.Join(name, fsrv.options.IndexFile))
+		if err == nil && !indexInfo.IsDir() {
+			defer index.Close()
+			serveContent(w, req, index, indexInfo)
+			return
+		}
+		if err == nil {
+			index.Close()
+		}
+		if !fsrv.options.Listings {
+			writeError(w, response.StatusNotFound)
+			return
+		}
+		fsrv.serveListing(w, req, f, u)
+		return
+	}
+	serveContent(w, req, f, info)
+}
+
+// name maps the request path to a name under the root, "." for the root
+// itself. ok is false when the path is outside the prefix or hidden.
+func (fsrv *fileServer) name(p string) (string, bool) {
+	if prefix := strings.TrimSuffix(fsrv.options.StripPrefix, "/"); prefix != "" {
+		if p != prefix && !strings.HasPrefix(p, prefix+"/") {
+			return "", false
+		}
+		p = strings.TrimPrefix(p, prefix)
+	}
+	// cleaning a rooted path drops every ".." that would climb above it
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		return ".", true
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// errOutsideRoot is for names that resolve outside the root, through a
+// symlink: os.Root refuses to open them.
+var errOutsideRoot = errors.New("path escapes the root")
+
+func open(root *os.Root, name string) (*os.File, fs.FileInfo, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) {
+			err = checkOutsideRoot(root.Name(), name, err)
+		}
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// checkOutsideRoot tells why os.Root failed to open name: errOutsideRoot
+// when its symlinks lead outside dir, the error resolving them when they
+// lead nowhere, else err as it is.
+func checkOutsideRoot(dir string, name string, err error) error {
+	base, baseErr := filepath.EvalSymlinks(dir)
+	if baseErr != nil {
+		return err
+	}
+	resolved, resolveErr := filepath.EvalSymlinks(filepath.Join(dir, filepath.FromSlash(name)))
+	if resolveErr != nil {
+		return resolveErr
+	}
+	rel, relErr := filepath.Rel(base, resolved)
+	if relErr != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return &fs.PathError{Op: "open", Path: name, Err: errOutsideRoot}
+	}
+	return err
+}
+
+func errorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, errOutsideRoot):
+		return response.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return response.StatusForbidden
+	}
+	log.Printf("fileserver: %v", err)
+	return response.StatusInternalError
+}
+
+// serveContent streams f, the Content-Type coming from the extension or
+// else from the first bytes. A GET with a Range gets only those bytes, as a
+// 206 and, for several ranges, as multipart/byteranges.
+func serveContent(w *response.Writer, req *request.Request, f *os.File, info fs.FileInfo) {
+	contentType, err := detectType(f, info.Name())
+	if err != nil {
+		log.Printf("fileserver: %v", err)
+		writeError(w, response.StatusInternalError)
+		return
+	}
+	validators := Validators{ETag: FileETag(info, false), LastModified: info.ModTime()}
+	size := info.Size()
+	h := response.GetDefaultHeaders(0)
+	h.SetOVR("Content-Type", contentType)
+	h.Set("Accept-Ranges", "bytes")
+	validators.Set(h)
+	if CheckPreconditions(w, req, validators, h) {
+		return
+	}
+
+	var ranges []headers.ByteRange
+	// Range only applies to GET (RFC 9110 14.2), and not after the file
+	// changed since the client got the first part
+	if v, err := req.Headers.Get("Range"); err == nil && req.RequestLine.Method == "GET" && validators.IfRange(req) {
+		ranges, err = headers.ParseRange(v, size)
+		if errors.Is(err, headers.ErrRangeNotSatisfiable) {
+			h.SetOVR("Content-Range", headers.UnsatisfiedRange(size))
+			w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+			w.WriteHeaders(h)
+			return
+		}
+		// any other error and the field is ignored, the whole file goes out
+	}
+
+	switch len(ranges) {
+	case 0:
+		h.SetOVR("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		if req.RequestLine.Method == "HEAD" {
+			return
+		}
+		streamFile(w, io.NewSectionReader(f, 0, size), info.Name())
+	case 1:
+		h.SetOVR("Content-Length", strconv.FormatInt(ranges[0].Length, 10))
+		h.SetOVR("Content-Range", ranges[0].ContentRange(size))
+		w.WriteStatusLine(response.StatusPartialContent)
+		w.WriteHeaders(h)
+		streamFile(w, io.NewSectionReader(f, ranges[0].Start, ranges[0].Length), info.Name())
+	default:
+		serveMultipart(w, h, f, info, contentType, ranges)
+	}
+}
+
+// serveMultipart sends several ranges as multipart/byteranges
+// (RFC 9110 14.6), each part with its own Content-Range.
+func serveMultipart(w *response.Writer, h headers.Headers, f *os.File, info fs.FileInfo, contentType string, ranges []headers.ByteRange) {
+	boundary := rand.Text()
+	size := info.Size()
+	partHeaders := make([]string, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		delimiter := "\r\n--" + boundary
+		if i == 0 {
+			delimiter = delimiter[2:]
+		}
+		partHeaders[i] = fmt.Sprintf("%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			delimiter, contentType, r.ContentRange(size))
+		length += int64(len(partHeaders[i])) + r.Length
+	}
+	closing := "\r\n--" + boundary + "--\r\n"
+	length += int64(len(closing))
+
+	h.SetOVR("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.SetOVR("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteStatusLine(response.StatusPartialContent)
+	w.WriteHeaders(h)
+	for i, r := range ranges {
+		if _, err := w.WriteBody([]byte(partHeaders[i])); err != nil {
+			return
+		}
+		if !streamFile(w, io.NewSectionReader(f, r.Start, r.Length), info.Name()) {
+			return
+		}
+	}
+	w.WriteBody([]byte(closing))
+}
+
+// streamFile copies a part of a file to the body and reports whether all
+// of it went out.
+func streamFile(w *response.Writer, section *io.SectionReader, name string) bool {
+	_, err := io.Copy(w.BodyWriter(false), section)
+	if err != nil {
+		// the status line is out, all we can do is cut the body short
+		log.Printf("fileserver: error streaming %s: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// contentTypes are the types the system MIME tables may not know.
+var contentTypes = map[string]string{
+	".txt":   "text/plain; charset=utf-8",
+	".md":    "text/markdown; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mp3":   "audio/mpeg",
+	".ogg":   "audio/ogg",
+	".wav":   "audio/wav",
+	".ico":   "image/vnd.microsoft.icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".zip":   "application/zip",
+	".gz":    "application/gzip",
+}
+
+// detectType returns the Content-Type of f and leaves its offset at the
+// start.
+func detectType(f *os.File, name string) (string, error) {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := contentTypes[ext]; ok {
+		return t, nil
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t, nil
+	}
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return sniff(buf[:n]), nil
+}
+
+// sniffLen is how much of a file sniff looks at.
+const sniffLen = 512
+
+// signatures are the magic numbers sniff knows, checked in order.
+var signatures = []struct {
+	offset      int
+	prefix      string
+	contentType string
+}{
+	{0, "\x89PNG\r\n\x1a\n", "image/png"},
+	{0, "\xff\xd8\xff", "image/jpeg"},
+	{0, "GIF87a", "image/gif"},
+	{0, "GIF89a", "image/gif"},
+	{0, "%PDF-", "application/pdf"},
+	{0, "PK\x03\x04", "application/zip"},
+	{0, "\x1f\x8b\x08", "application/gzip"},
+	{0, "\x1a\x45\xdf\xa3", "video/webm"},
+	{0, "OggS\x00", "application/ogg"},
+	{0, "ID3", "audio/mpeg"},
+	{0, "\x00asm", "application/wasm"},
+	{4, "ftyp", "video/mp4"},
+}
+
+// sniff guesses the type of p from magic numbers and markup, otherwise it
+// is text when it is UTF-8 without control bytes.
+func sniff(p []byte) string {
+	for _, sig := range signatures {
+		if len(p) >= sig.offset+len(sig.prefix) && string(p[sig.offset:sig.offset+len(sig.prefix)]) == sig.prefix {
+			return sig.contentType
+		}
+	}
+	text := strings.ToLower(strings.TrimLeft(string(p), " \t\r\n\ufeff"))
+	for _, tag := range []string{"<!doctype html", "<html", "<head", "<body", "<script", "<div", "<p>"} {
+		if strings.HasPrefix(text, tag) {
+			return "text/html; charset=utf-8"
+		}
+	}
+	if strings.HasPrefix(text, "<?xml") {
+		return "text/xml; charset=utf-8"
+	}
+	if isText(p) {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+func isText(p []byte) bool {
+	for len(p) > 0 {
+		// a character may be cut at the end of the sample
+		if !utf8.FullRune(p) {
+			return true
+		}
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		if (r < 0x20 && r != '\r' && r != '\n' && r != '\t' && r != '\f') || r == 0x7f {
+			return false
+		}
+		p = p[size:]
+	}
+	return true
+}
+
+// listEntry is one line of a directory listing.
+type listEntry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func (fsrv *fileServer) serveListing(w *response.Writer, req *request.Request, dir *os.File, u *url.URL) {
+	dirEntries, err := dir.ReadDir(-1)
+	if err != nil {
+		writeError(w, errorStatus(err))
+		return
+	}
+	// File.ReadDir is in directory order
+	sort.Slice(dirEntries, func(i, j int) bool { return dirEntries[i].Name() < dirEntries[j].Name() })
+	entries := []listEntry{}
+	for _, de := range dirEntries {
+		if strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entry := listEntry{Name: de.Name(), Dir: de.IsDir(), ModTime: info.ModTime().UTC()}
+		if !entry.Dir {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+
+	var body []byte
+	contentType := "text/html; charset=utf-8"
+	if wantsJSON(req, u) {
+		contentType = "application/json"
+		body, err = json.Marshal(struct {
+			Path    string      `json:"path"`
+			Entries []listEntry `json:"entries"`
+		}{u.Path, entries})
+		if err != nil {
+			writeError(w, response.StatusInternalError)
+			return
+		}
+	} else {
+		body = listingHTML(u.Path, entries)
+	}
+
+	// weak since the listing is only equivalent when the times change
+	validators := Validators{ETag: BytesETag(body, true)}
+	h := response.GetDefaultHeaders(len(body))
+	h.SetOVR("Content-Type", contentType)
+	h.Set("Vary", "Accept")
+	validators.Set(h)
+	if CheckPreconditions(w, req, validators, h) {
+		return
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(h)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(body)
+	}
+}
+
+// wantsJSON picks the listing format: ?format=json, or an Accept that
+// prefers application/json to HTML.
+func wantsJSON(req *request.Request, u *url.URL) bool {
+	if format := u.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	accept, err := req.Headers.Accept()
+	if err != nil {
+		return false
+	}
+	for _, entry := range accept {
+		if entry.Q == 0 {
+			continue
+		}
+		switch entry.Value {
+		case "application/json":
+			return true
+		case "text/html", "text/*", "*/*":
+			return false
+		}
+	}
+	return false
+}
+
+func listingHTML(dirPath string, entries []listEntry) []byte {
+	var sb strings.Builder
+	title := html.EscapeString(dirPath)
+	fmt.Fprintf(&sb, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	if dirPath != "/" {
+		sb.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+	for _, e := range entries {
+		name := e.Name
+		if e.Dir {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		// a name with a colon would read as a scheme
+		if strings.Contains(strings.SplitN(href, "/", 2)[0], ":") {
+			href = "./" + href
+		}
+		size := ""
+		if !e.Dir {
+			size = " " + strconv.FormatInt(e.Size, 10) + " bytes"
+		}
+		fmt.Fprintf(&sb, "<li><a href=\"%s\">%s</a>%s, %s</li>\n", html.EscapeString(href), html.EscapeString(name),
+			size, headers.FormatTime(e.ModTime))
+	}
+	sb.WriteString("</ul>\n</body>\n</html>\n")
+	return []byte(sb.String())
+}
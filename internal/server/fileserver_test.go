@@ -0,0 +1,338 @@
+package server
+
+import (
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveRaw runs a raw request through handler and parses what it wrote back.
+func serveRaw(t *testing.T, handler Handler, raw string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	handler(&response.Writer{ResWriter: out}, req)
+
+	resp, err := http.ReadResponse(bufio.NewReader(out), &http.Request{Method: req.RequestLine.Method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+func get(target string, extra ...string) string {
+	return "GET " + target + " HTTP/1.1\r\nHost: localhost\r\n" + strings.Join(extra, "") + "\r\n"
+}
+
+// writeTree creates files, a name ending in "/" is a directory.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			require.NoError(t, os.MkdirAll(p, 0o755))
+			continue
+		}
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	return root
+}
+
+func TestFileServer(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"hello.txt":       "hello world",
+		"site/index.html": "<h1>home</h1>",
+		"data/blob":       "\x89PNG\r\n\x1a\n....",
+		"data/notes":      "plain notes",
+		"data/page":       "<!DOCTYPE html><p>hi</p>",
+		".env":            "SECRET=1",
+		"empty/":          "",
+	})
+	handler := FileServer(root)
+
+	// Test: A file is streamed with its length and a type from the extension
+	resp, body := serveRaw(t, handler, get("/hello.txt"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "11", resp.Header.Get("Content-Length"))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Test: Without an extension the type is sniffed from the content
+	resp, _ = serveRaw(t, handler, get("/data/blob"))
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	resp, _ = serveRaw(t, handler, get("/data/notes"))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	resp, _ = serveRaw(t, handler, get("/data/page"))
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	// Test: A directory serves its index.html, after a redirect to the slash
+	resp, _ = serveRaw(t, handler, get("/site?x=1"))
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/site/?x=1", resp.Header.Get("Location"))
+	resp, body = serveRaw(t, handler, get("/site/"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "<h1>home</h1>", string(body))
+
+	// Test: HEAD has the headers without the body
+	resp, body = serveRaw(t, handler, "HEAD /hello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "11", resp.Header.Get("Content-Length"))
+	assert.Empty(t, body)
+
+	// Test: Other methods are refused
+	resp, _ = serveRaw(t, handler, "DELETE /hello.txt HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+
+	// Test: Missing files, hidden files and unlisted directories are 404,
+	// "/data/.." is the root which has no index
+	for _, target := range []string{"/missing", "/.env", "/empty/", "/data/../"} {
+		resp, _ = serveRaw(t, handler, get(target))
+		assert.Equal(t, 404, resp.StatusCode, target)
+	}
+}
+
+func TestFileServerTraversal(t *testing.T) {
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("top secret"), 0o644))
+	root := writeTree(t, map[string]string{"public.txt": "public"})
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "link")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "gone"), filepath.Join(root, "dangling")))
+	handler := FileServer(root)
+
+	// Test: Dot segments, encoded or not, can't climb out of the root
+	for _, target := range []string{
+		"/../" + filepath.Base(outside) + "/secret",
+		"/%2e%2e/%2e%2e/etc/passwd",
+		"/..%2f..%2fetc/passwd",
+		"/a/../../public.txt",
+	} {
+		resp, body := serveRaw(t, handler, get(target))
+		assert.NotContains(t, string(body), "secret", target)
+		if target == "/a/../../public.txt" {
+			assert.Equal(t, "public", string(body))
+			continue
+		}
+		assert.Equal(t, 404, resp.StatusCode, target)
+	}
+
+	// Test: A symlink pointing outside the root is not followed
+	resp, body := serveRaw(t, handler, get("/link"))
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.NotContains(t, string(body), "top secret")
+	r, err := os.OpenRoot(root)
+	require.NoError(t, err)
+	defer r.Close()
+	_, _, err = open(r, "link")
+	assert.ErrorIs(t, err, errOutsideRoot)
+
+	// Test: Nor is one pointing outside to nothing
+	resp, _ = serveRaw(t, handler, get("/dangling"))
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: A NUL byte is a bad request
+	resp, _ = serveRaw(t, handler, get("/public.txt%00.html"))
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestFileServerListings(t *testing.T) {
+	root := writeTree(t, map[string]string{
+		"files/a.txt":     "aaa",
+		"files/<b>.txt":   "b",
+		"files/sub/c.txt": "c",
+		"files/.hidden":   "h",
+	})
+	handler := FileServerWithOptions(root, FileServerOptions{StripPrefix: "/static", Listings: true})
+
+	// Test: A directory without index.html is listed as HTML, escaped
+	resp, body := serveRaw(t, handler, get("/static/files/"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+	assert.Contains(t, string(body), `<a href="a.txt">a.txt</a> 3 bytes`)
+	assert.Contains(t, string(body), `<a href="%3Cb%3E.txt">&lt;b&gt;.txt</a>`)
+	assert.Contains(t, string(body), `<a href="sub/">sub/</a>`)
+	assert.Contains(t, string(body), `<a href="../">../</a>`)
+	assert.NotContains(t, string(body), ".hidden")
+
+	// Test: JSON is served when asked for with ?format=json or Accept
+	for _, raw := range []string{
+		get("/static/files/?format=json"),
+		get("/static/files/", "Accept: text/html;q=0.5, application/json\r\n"),
+	} {
+		resp, body = serveRaw(t, handler, raw)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var listing struct {
+			Path    string
+			Entries []struct {
+				Name string
+				Dir  bool
+				Size int64
+			}
+		}
+		require.NoError(t, json.Unmarshal(body, &listing))
+		assert.Equal(t, "/static/files/", listing.Path)
+		require.Len(t, listing.Entries, 3)
+		assert.Equal(t, "<b>.txt", listing.Entries[0].Name)
+		assert.Equal(t, "a.txt", listing.Entries[1].Name)
+		assert.Equal(t, int64(3), listing.Entries[1].Size)
+		assert.True(t, listing.Entries[2].Dir)
+	}
+
+	// Test: Paths outside the prefix are not served
+	resp, _ = serveRaw(t, handler, get("/files/a.txt"))
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, _ = serveRaw(t, handler, get("/staticfiles/a.txt"))
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, body = serveRaw(t, handler, get("/static/files/sub/c.txt"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "c", string(body))
+}
+
+func TestFileServerRanges(t *testing.T) {
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	root := writeTree(t, map[string]string{"video.mp4": content})
+	handler := FileServer(root)
+
+	// Test: Every file response advertises byte ranges
+	resp, body := serveRaw(t, handler, get("/video.mp4"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+	assert.Equal(t, content, string(body))
+
+	// Test: A single range is a 206 with Content-Range
+	resp, body = serveRaw(t, handler, get("/video.mp4", "Range: bytes=10-15\r\n"))
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 10-15/36", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "6", resp.Header.Get("Content-Length"))
+	assert.Equal(t, "video/mp4", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "abcdef", string(body))
+
+	// Test: Suffix and open ended ranges
+	_, body = serveRaw(t, handler, get("/video.mp4", "Range: bytes=-3\r\n"))
+	assert.Equal(t, "xyz", string(body))
+	resp, body = serveRaw(t, handler, get("/video.mp4", "Range: bytes=30-\r\n"))
+	assert.Equal(t, "bytes 30-35/36", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "uvwxyz", string(body))
+
+	// Test: Several ranges come as multipart/byteranges
+	resp, body = serveRaw(t, handler, get("/video.mp4", "Range: bytes=0-1, 10-11, -2\r\n"))
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var parts, contentRanges []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "video/mp4", part.Header.Get("Content-Type"))
+		parts = append(parts, string(data))
+		contentRanges = append(contentRanges, part.Header.Get("Content-Range"))
+	}
+	assert.Equal(t, []string{"01", "ab", "yz"}, parts)
+	assert.Equal(t, []string{"bytes 0-1/36", "bytes 10-11/36", "bytes 34-35/36"}, contentRanges)
+
+	// Test: A range past the end is a 416 with the size
+	resp, body = serveRaw(t, handler, get("/video.mp4", "Range: bytes=100-200\r\n"))
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */36", resp.Header.Get("Content-Range"))
+	assert.Empty(t, body)
+
+	// Test: Invalid ranges, other units and HEAD get the whole file
+	for _, raw := range []string{
+		get("/video.mp4", "Range: bytes=5-2\r\n"),
+		get("/video.mp4", "Range: items=0-1\r\n"),
+		get("/video.mp4", "Range: bytes=0-35, 0-35\r\n"),
+	} {
+		resp, body = serveRaw(t, handler, raw)
+		assert.Equal(t, 200, resp.StatusCode, raw)
+		assert.Equal(t, content, string(body), raw)
+	}
+	resp, _ = serveRaw(t, handler, "HEAD /video.mp4 HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-1\r\n\r\n")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "36", resp.Header.Get("Content-Length"))
+}
+
+func TestFileServerConditional(t *testing.T) {
+	root := writeTree(t, map[string]string{"video.mp4": "0123456789", "dir/a.txt": "a"})
+	handler := FileServerWithOptions(root, FileServerOptions{Listings: true})
+
+	// Test: Files carry a strong ETag and Last-Modified
+	resp, _ := serveRaw(t, handler, get("/video.mp4"))
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	assert.True(t, strings.HasPrefix(etag, `"`))
+	assert.NotEmpty(t, lastModified)
+
+	// Test: Revalidating with either validator is a 304
+	resp, body := serveRaw(t, handler, get("/video.mp4", "If-None-Match: "+etag+"\r\n"))
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Empty(t, body)
+	resp, _ = serveRaw(t, handler, get("/video.mp4", "If-Modified-Since: "+lastModified+"\r\n"))
+	assert.Equal(t, 304, resp.StatusCode)
+
+	// Test: If-Range with the current ETag keeps the Range, a stale one sends
+	// the whole file
+	resp, body = serveRaw(t, handler, get("/video.mp4", "Range: bytes=0-1\r\nIf-Range: "+etag+"\r\n"))
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "01", string(body))
+	resp, body = serveRaw(t, handler, get("/video.mp4", "Range: bytes=0-1\r\nIf-Range: \"old\"\r\n"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "0123456789", string(body))
+
+	// Test: A file that changed fails If-Match
+	resp, _ = serveRaw(t, handler, get("/video.mp4", "If-Match: \"old\"\r\n"))
+	assert.Equal(t, 412, resp.StatusCode)
+
+	// Test: Listings get a weak ETag from their content
+	resp, _ = serveRaw(t, handler, get("/dir/"))
+	etag = resp.Header.Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `W/"`))
+	resp, _ = serveRaw(t, handler, get("/dir/", "If-None-Match: "+etag+"\r\n"))
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+}
+
+func TestSniff(t *testing.T) {
+	tests := map[string]string{
+		"GIF89a...":                "image/gif",
+		"%PDF-1.7":                 "application/pdf",
+		"\x00\x00\x00\x20ftypisom": "video/mp4",
+		"  <html><body>":           "text/html; charset=utf-8",
+		"<?xml version=\"1.0\"?>":  "text/xml; charset=utf-8",
+		"héllo wörld":              "text/plain; charset=utf-8",
+		// a character cut at the end of the sample is still text
+		"abc\xc3":      "text/plain; charset=utf-8",
+		"\x00\x01\x02": "application/octet-stream",
+		"abc\xff\xfe":  "application/octet-stream",
+		"":             "text/plain; charset=utf-8",
+	}
+	for input, want := range tests {
+		assert.Equal(t, want, sniff([]byte(input)), "%q", input)
+	}
+}
@@ -3,10 +3,12 @@ package server
 import (
 	"MODULE_NAME/internal/request"
 	"MODULE_NAME/internal/response"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync/atomic"
 )
 
@@ -15,9 +17,23 @@ type ServerStatus int
 type Server struct {
 	listener net.Listener
 	handler  Handler
+	options  Options
 	closed   atomic.Bool
 }
 
+// Options configure how the server treats incoming requests.
+type Options struct {
+	Request request.Options
+	// DeferBody leaves the body on the connection until the handler calls
+	// req.ReadBody, req.Body is empty until then, or streams it with
+	// req.BodyReader. With "Expect: 100-continue" the client is only asked
+	// for the body at that point, so the handler can still turn it down
+	// with 413 or 417. Otherwise the server reads the body before the
+	// handler runs, sending 100 Continue first when the client waits for
+	// it.
+	DeferBody bool
+}
+
 // type HandlerError struct {
 // 	StatusCode response.StatusCode
 // 	Message    string
@@ -31,8 +47,34 @@ type Server struct {
 // 	w.Write([]byte(he.Message))
 // }
 
+// Handler answers a request. req.Body has been read, unless the server runs
+// with Options.DeferBody.
 type Handler func(w *response.Writer, req *request.Request)
 
+// RelayResponse runs handle in a goroutine and passes relay the response it
+// writes, parsed as it comes: the head first, the body and trailers from
+// resp.BodyReader. It's for those that send a handler's response on
+// differently, compressed or in HTTP/2 frames. Once relay returns, writes
+// left in handle fail instead of blocking it. RelayResponse returns after
+// handle, with relay's error; io.EOF or io.ErrUnexpectedEOF mean handle
+// wrote no complete response.
+func RelayResponse(method string, handle func(w *response.Writer), relay func(resp *response.Response) error) error {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer pw.Close()
+		handle(&response.Writer{ResWriter: pw})
+	}()
+	resp, err := response.ResponseHeadersFromReader(pr, method)
+	if err == nil {
+		err = relay(resp)
+	}
+	pr.CloseWithError(errors.New("response abandoned"))
+	<-done
+	return err
+}
+
 const (
 	Listening ServerStatus = iota
 	Closed
@@ -63,40 +105,106 @@ func (s *Server) listen() {
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	request, err := request.RequestFromReader(conn)
-	if err != nil {
-		responseWrite := &response.Writer{
-			ResWriter: conn,
+	responseWriter := &response.Writer{
+		ResWriter: conn,
+	}
+	// a hijacked connection belongs to the handler, even after it returned
+	defer func() {
+		if !responseWriter.Hijacked() {
+			conn.Close()
 		}
-		responseWrite.WriteStatusLine(400)
-		header := response.GetDefaultHeaders(0)
-		responseWrite.WriteHeaders(header)
+	}()
+	request, err := request.RequestHeadersFromReaderWithOptions(conn, s.options.Request)
+	if err != nil {
+		writeError(responseWriter, response.StatusBadRequest)
 		return
 	}
-	responseStr := &response.Writer{
-		ResWriter: conn,
+	request.RemoteAddr = conn.RemoteAddr().String()
+	responseWriter.AllowHijack(conn, request.Buffered)
+
+	// With "Expect: 100-continue" the client holds the body back until we
+	// answer: reading the body sends 100 Continue, writing a final status
+	// first skips it.
+	expect, err := request.Headers.Get("Expect")
+	if err == nil {
+		if !strings.EqualFold(expect, "100-continue") {
+			writeError(responseWriter, response.StatusExpectationFailed)
+			return
+		}
+		request.BeforeBodyRead(responseWriter.WriteContinue)
+	}
+	// a deferred body is read by the handler, but codings it can't
+	// decode are known already
+	if s.options.DeferBody {
+		_, err = request.BodyReader()
+	} else {
+		_, err = request.ReadBody()
+	}
+	if err != nil {
+		writeBodyError(responseWriter, err)
+		return
 	}
 
-	s.handler(responseStr, request)
+	s.handler(responseWriter, request)
 
 }
+
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	w.WriteStatusLine(statusCode)
+	header := response.GetDefaultHeaders(0)
+	w.WriteHeaders(header)
+}
+
+// writeBodyError answers a body that couldn't be read. With
+// Options.Request.DecodeBody that includes codings we don't know, the 415
+// lists the ones we do (RFC 9110 15.5.16).
+func writeBodyError(w *response.Writer, err error) {
+	switch {
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		w.WriteStatusLine(response.StatusUnsupportedMediaType)
+		header := response.GetDefaultHeaders(0)
+		header.Set("Accept-Encoding", "gzip, deflate")
+		w.WriteHeaders(header)
+	case errors.Is(err, request.ErrBodyTooLarge):
+		writeError(w, response.StatusContentTooLarge)
+	default:
+		writeError(w, response.StatusBadRequest)
+	}
+}
+
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithOptions(port, handler, Options{})
+}
+
+func ServeWithOptions(port int, handler Handler, opts Options) (*Server, error) {
 	portString := fmt.Sprintf(":%d", port)
 	listener, err := net.Listen("tcp", portString)
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating server")
 	}
-	server := &Server{
-		listener: listener,
-		handler:  handler,
-	}
-	server.closed.Store(false)
+	server := New(handler, opts)
+	server.listener = listener
 	go server.listen()
 	return server, nil
 }
 
+// New returns a server that doesn't listen, its connections come from
+// ServeConn. It's for listeners that pick the protocol of each connection
+// before handing the HTTP/1.1 ones over.
+func New(handler Handler, opts Options) *Server {
+	return &Server{
+		handler: handler,
+		options: opts,
+	}
+}
+
+// ServeConn serves the request on conn and closes it, unless the handler
+// hijacked it. It returns once the handler did.
+func (s *Server) ServeConn(conn net.Conn) {
+	s.handle(conn)
+}
+
 func successWriter(w io.Writer, buf []byte) {
 	response.WriteStatusLine(w, 200)
 	headers := response.GetDefaultHeaders(len(buf))
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns both ends of a loopback connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	server := <-accepted
+	require.NotNil(t, server)
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return server, client
+}
+
+func TestHijack(t *testing.T) {
+	handlerDone := make(chan struct{})
+	s := &Server{handler: func(w *response.Writer, req *request.Request) {
+		conn, buffered, err := w.Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		// the connection outlives the handler
+		go func() {
+			<-handlerDone
+			defer conn.Close()
+			conn.Write([]byte("took over, ahead: " + string(buffered) + "\n"))
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte("echo: " + line))
+		}()
+	}}
+	server, client := tcpPair(t)
+	go func() {
+		s.handle(server)
+		close(handlerDone)
+	}()
+
+	// Test: Bytes sent along with the request reach the new owner
+	client.Write([]byte("GET /raw HTTP/1.1\r\nHost: localhost\r\n\r\nearly"))
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "took over, ahead: early\n", line)
+
+	// Test: The server neither answered nor closed the connection
+	client.Write([]byte("still open\n"))
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: still open\n", line)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestHandleCloses(t *testing.T) {
+	s := &Server{handler: func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(2)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte("ok"))
+	}}
+	server, client := tcpPair(t)
+	go s.handle(server)
+
+	// Test: Without a hijack the connection is closed after the response
+	client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestExpectContinue(t *testing.T) {
+	upload := func(w *response.Writer, req *request.Request) {
+		body := []byte("got " + string(req.Body))
+		if len(req.Body) == 0 {
+			w.WriteStatusLine(response.StatusContentTooLarge)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+			return
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	head := "POST /upload HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n"
+
+	// Test: The body is asked for and read before the handler runs, which
+	// finds it in req.Body
+	s := &Server{handler: upload}
+	server, client := tcpPair(t)
+	go s.handle(server)
+	client.Write([]byte(head))
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusContinue, resp.StatusCode)
+	client.Write([]byte("hello"))
+	resp, err = http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "got hello", string(body))
+
+	// Test: With DeferBody the handler can turn the body down unseen
+	s = &Server{handler: upload, options: Options{DeferBody: true}}
+	server, client = tcpPair(t)
+	go s.handle(server)
+	client.Write([]byte(head))
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err = http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
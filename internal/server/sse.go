@@ -0,0 +1,272 @@
+package server
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is one message of a Server-Sent Events stream (HTML Living
+// Standard 9.2). Only Data is required.
+type Event struct {
+	// Event is the type the client listens for, "message" when empty.
+	Event string
+	// ID becomes the client's last event ID, sent back as Last-Event-ID
+	// when it reconnects.
+	ID string
+	// Data is the payload, split into one "data:" line per line.
+	Data string
+	// Retry changes how long the client waits before reconnecting.
+	Retry time.Duration
+}
+
+// EventStreamOptions configure NewEventStream.
+type EventStreamOptions struct {
+	// Heartbeat is how often a comment goes out when no event did, 15
+	// seconds when 0 and never when negative. It keeps proxies from timing
+	// out an idle stream and notices a client that went away.
+	Heartbeat time.Duration
+	// Retry is sent to the client at the start when set, see Event.Retry.
+	Retry time.Duration
+}
+
+const defaultHeartbeat = 15 * time.Second
+
+// ErrStreamClosed is returned by EventStream writes after Close, or once
+// the client went away.
+var ErrStreamClosed = errors.New("event stream closed")
+
+// EventStream writes Server-Sent Events to a client. Send and Comment are
+// safe to call from several goroutines. A failed write means the client
+// disconnected: the stream is over, Done is closed and every later write
+// fails.
+type EventStream struct {
+	w           *response.Writer
+	lastEventID string
+	heartbeat   time.Duration
+
+	mu       sync.Mutex
+	err      error
+	lastSent time.Time
+	done     chan struct{}
+	stop     chan struct{}
+	closed   bool
+}
+
+// NewEventStream answers req with the headers of an event stream and
+// starts the heartbeat. The handler then sends events until Done is closed
+// or it has nothing more to say, and calls Close either way:
+//
+//	stream, err := server.NewEventStream(w, req, server.EventStreamOptions{})
+//	if err != nil {
+//		return
+//	}
+//	defer stream.Close()
+//	for {
+//		select {
+//		case <-stream.Done():
+//			return
+//		case update := <-updates:
+//			stream.Send(server.Event{Event: "update", Data: update})
+//		}
+//	}
+func NewEventStream(w *response.Writer, req *request.Request, opts EventStreamOptions) (*EventStream, error) {
+	s := &EventStream{
+		w:         w,
+		heartbeat: opts.Heartbeat,
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+		lastSent:  time.Now(),
+	}
+	if s.heartbeat == 0 {
+		s.heartbeat = defaultHeartbeat
+	}
+	if id, err := req.Headers.Get("Last-Event-ID"); err == nil && !strings.ContainsRune(id, 0) {
+		s.lastEventID = id
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	// every reconnect has to reach us, and buffering proxies hold events back
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Connection", "close")
+	if err := w.WriteStatusLine(response.StatusOK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	if opts.Retry > 0 {
+		if err := s.write(fmt.Appendf(nil, "retry: %d\n\n", opts.Retry.Milliseconds())); err != nil {
+			return nil, err
+		}
+	} else if err := s.flush(); err != nil {
+		return nil, err
+	}
+	if s.heartbeat > 0 {
+		go s.keepAlive()
+	}
+	return s, nil
+}
+
+// LastEventID is the ID of the last event the client saw before it
+// reconnected, "" on the first connection. Events after it are the ones
+// it missed.
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed when the stream is over: the client went away or Close
+// was called.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err tells why the stream is over, nil while it isn't.
+func (s *EventStream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Send writes e and flushes it to the client.
+func (s *EventStream) Send(e Event) error {
+	p, err := e.encode()
+	if err != nil {
+		return err
+	}
+	return s.write(p)
+}
+
+// Comment writes a comment line, which clients ignore. The heartbeat is
+// one.
+func (s *EventStream) Comment(text string) error {
+	var buf bytes.Buffer
+	for _, line := range splitLines(text) {
+		buf.WriteString(":")
+		if line != "" {
+			buf.WriteString(" " + line)
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	return s.write(buf.Bytes())
+}
+
+// Close stops the heartbeat and ends the response. A client that's still
+// there reconnects after its retry delay unless it's told otherwise, e.g.
+// with a 204 on the next request.
+func (s *EventStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	if s.err != nil {
+		return nil
+	}
+	s.end(ErrStreamClosed)
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	if err := s.w.WriteTrailers(headers.NewHeaders()); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// write sends p as one chunk, so an event isn't split across writes.
+func (s *EventStream) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := s.w.WriteChunkedBody(p); err != nil {
+		s.end(fmt.Errorf("%w: %w", ErrStreamClosed, err))
+		return s.err
+	}
+	if err := s.w.Flush(); err != nil {
+		s.end(fmt.Errorf("%w: %w", ErrStreamClosed, err))
+		return s.err
+	}
+	s.lastSent = time.Now()
+	return nil
+}
+
+func (s *EventStream) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+// end marks the stream as over, s.mu is held.
+func (s *EventStream) end(err error) {
+	s.err = err
+	close(s.done)
+}
+
+// keepAlive writes a comment whenever nothing went out for a heartbeat.
+func (s *EventStream) keepAlive() {
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			idle := now.Sub(s.lastSent) >= s.heartbeat
+			s.mu.Unlock()
+			if idle {
+				s.Comment("heartbeat")
+			}
+		}
+	}
+}
+
+// encode frames e, refusing fields that would break out of their line.
+func (e Event) encode() ([]byte, error) {
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("invalid event type: %q", e.Event)
+	}
+	// the client ignores IDs with a NUL
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("invalid event id: %q", e.ID)
+	}
+	var buf bytes.Buffer
+	if e.Event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", e.Event)
+	}
+	if e.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", e.ID)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&buf, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	for _, line := range splitLines(e.Data) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// splitLines splits on CRLF, LF and a lone CR, the line ends of the event
+// stream format.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
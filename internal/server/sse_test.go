@@ -0,0 +1,155 @@
+package server
+
+import (
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventEncode(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Data: "hello"}, "data: hello\n\n"},
+		{Event{Event: "update", ID: "7", Data: "x"}, "event: update\nid: 7\ndata: x\n\n"},
+		{Event{Data: "one\ntwo\r\nthree\rfour"}, "data: one\ndata: two\ndata: three\ndata: four\n\n"},
+		{Event{Data: "  indented\n"}, "data:   indented\ndata: \n\n"},
+		{Event{Data: ""}, "data: \n\n"},
+		{Event{Retry: 2500 * time.Millisecond, Data: "x"}, "retry: 2500\ndata: x\n\n"},
+	}
+	for _, tt := range tests {
+		// Test: Fields and multi-line data
+		p, err := tt.event.encode()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, string(p))
+	}
+
+	// Test: Fields that would start a new line are refused
+	for _, e := range []Event{{Event: "a\nb"}, {ID: "1\r2"}, {ID: "1\x002"}} {
+		_, err := e.encode()
+		assert.Error(t, err, e)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	var lastEventID string
+	handler := func(w *response.Writer, req *request.Request) {
+		stream, err := NewEventStream(w, req, EventStreamOptions{Retry: 3 * time.Second, Heartbeat: -1})
+		require.NoError(t, err)
+		defer stream.Close()
+		lastEventID = stream.LastEventID()
+		require.NoError(t, stream.Send(Event{ID: "42", Data: "first"}))
+		require.NoError(t, stream.Comment("note"))
+		require.NoError(t, stream.Send(Event{Event: "done", ID: "43", Data: "a\nb"}))
+	}
+
+	// Test: Headers, events and the Last-Event-ID of a reconnect
+	resp, body := serveRaw(t, handler, get("/events", "Last-Event-ID: 41\r\n"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "41", lastEventID)
+	assert.Equal(t, "retry: 3000\n\n"+
+		"id: 42\ndata: first\n\n"+
+		": note\n\n"+
+		"event: done\nid: 43\ndata: a\ndata: b\n\n", string(body))
+
+	// Test: Not compressed by the middleware
+	resp, _ = serveRaw(t, Compress(handler), get("/events", "Accept-Encoding: gzip\r\n"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+}
+
+// streamOverPipe starts a stream whose client reads from the returned pipe.
+func streamOverPipe(t *testing.T, opts EventStreamOptions) (*EventStream, *io.PipeReader) {
+	t.Helper()
+	pr, pw := io.Pipe()
+	req, err := request.RequestFromReader(strings.NewReader(get("/events")))
+	require.NoError(t, err)
+	ready := make(chan *EventStream)
+	go func() {
+		stream, err := NewEventStream(&response.Writer{ResWriter: pw}, req, opts)
+		if err != nil {
+			close(ready)
+			return
+		}
+		ready <- stream
+	}()
+	// the headers block until they are read
+	client := bufio.NewReader(pr)
+	for {
+		line, err := client.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	stream := <-ready
+	require.NotNil(t, stream)
+	go io.Copy(io.Discard, client)
+	return stream, pr
+}
+
+func TestEventStreamHeartbeat(t *testing.T) {
+	pr, pw := io.Pipe()
+	req, err := request.RequestFromReader(strings.NewReader(get("/events")))
+	require.NoError(t, err)
+	go func() {
+		stream, err := NewEventStream(&response.Writer{ResWriter: pw}, req, EventStreamOptions{Heartbeat: 10 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		<-stream.Done()
+	}()
+
+	// Test: An idle stream sends comments
+	client := bufio.NewReader(pr)
+	deadline := time.After(5 * time.Second)
+	for found := false; !found; {
+		select {
+		case <-deadline:
+			t.Fatal("no heartbeat")
+		default:
+		}
+		line, err := client.ReadString('\n')
+		require.NoError(t, err)
+		found = line == ": heartbeat\n"
+	}
+	pr.Close()
+}
+
+func TestEventStreamDisconnect(t *testing.T) {
+	// Test: A client going away ends the stream through the heartbeat
+	stream, pr := streamOverPipe(t, EventStreamOptions{Heartbeat: 10 * time.Millisecond})
+	pr.Close()
+	select {
+	case <-stream.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnect not noticed")
+	}
+	assert.True(t, errors.Is(stream.Err(), ErrStreamClosed))
+	assert.True(t, errors.Is(stream.Send(Event{Data: "late"}), ErrStreamClosed))
+	assert.NoError(t, stream.Close())
+
+	// Test: Without a heartbeat the next send notices it
+	stream, pr = streamOverPipe(t, EventStreamOptions{Heartbeat: -1})
+	require.NoError(t, stream.Send(Event{Data: "seen"}))
+	pr.Close()
+	assert.Error(t, stream.Send(Event{Data: "lost"}))
+	<-stream.Done()
+
+	// Test: Close ends the stream, later sends fail
+	stream, _ = streamOverPipe(t, EventStreamOptions{})
+	require.NoError(t, stream.Close())
+	<-stream.Done()
+	assert.True(t, errors.Is(stream.Send(Event{Data: "after"}), ErrStreamClosed))
+}
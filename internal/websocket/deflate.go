@@ -0,0 +1,146 @@
+package websocket
+
+import (
+	"MODULE_NAME/internal/headers"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// minCompressSize is the smallest message worth compressing, smaller ones
+// barely shrink or even grow.
+const minCompressSize = 64
+
+// deflateResponse is the only permessage-deflate configuration we agree to:
+// no context takeover either way, so every message stands on its own.
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail ends a message's deflate data: the sync flush marker the
+// sender stripped (RFC 7692 7.2.2) and an empty final block.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// compress deflates p as one message. c.wmu is held.
+func (c *Conn) compress(p []byte) []byte {
+	var buf bytes.Buffer
+	if c.fw == nil {
+		c.fw, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	} else {
+		c.fw.Reset(&buf)
+	}
+	c.fw.Write(p)
+	c.fw.Flush()
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4])
+}
+
+// inflate undoes compress, stopping at Options.MaxMessageSize.
+func (c *Conn) inflate(p []byte) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail)))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, c.opts.MaxMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid compressed message: %v", ErrProtocol, err)
+	}
+	if int64(len(out)) > c.opts.MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	return out, nil
+}
+
+// extension is one element of Sec-WebSocket-Extensions, its parameters
+// with "" for those without a value.
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+func parseExtensions(h headers.Headers) ([]extension, error) {
+	extensions := []extension{}
+	for _, element := range h.Values("Sec-WebSocket-Extensions") {
+		parts := strings.Split(element, ";")
+		e := extension{name: strings.ToLower(strings.TrimSpace(parts[0])), params: map[string]string{}}
+		if !headers.IsToken(e.name) {
+			return nil, fmt.Errorf("invalid extension: %q", element)
+		}
+		for _, part := range parts[1:] {
+			name, value, hasValue := strings.Cut(strings.TrimSpace(part), "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, dup := e.params[name]; dup || !headers.IsToken(name) {
+				return nil, fmt.Errorf("invalid extension parameter: %q", part)
+			}
+			if hasValue {
+				var err error
+				if value, err = headers.Unquote(strings.TrimSpace(value)); err != nil {
+					return nil, fmt.Errorf("invalid extension parameter: %q", part)
+				}
+			}
+			e.params[name] = value
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions, nil
+}
+
+// acceptDeflate reports whether the server can accept one of the client's
+// permessage-deflate offers (RFC 7692 7.1). compress/flate always uses the
+// full window, so offers limiting ours are declined; we answer every
+// accepted offer with deflateResponse.
+func acceptDeflate(offers []extension) bool {
+	for _, offer := range offers {
+		if offer.name != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for name, value := range offer.params {
+			switch name {
+			case "server_no_context_takeover", "client_no_context_takeover":
+				ok = ok && value == ""
+			case "server_max_window_bits":
+				ok = ok && value == "15"
+			case "client_max_window_bits":
+				// a hint that the client can take a smaller window, ours
+				// reads any
+				ok = ok && (value == "" || validWindowBits(value))
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDeflateResponse validates the server's answer to the offer a client
+// made with deflateResponse.
+func checkDeflateResponse(e extension) error {
+	if _, ok := e.params["server_no_context_takeover"]; !ok {
+		return fmt.Errorf("permessage-deflate without server_no_context_takeover")
+	}
+	for name, value := range e.params {
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+		case "server_max_window_bits":
+			if !validWindowBits(value) {
+				return fmt.Errorf("invalid server_max_window_bits: %q", value)
+			}
+		case "client_max_window_bits":
+			if value != "15" {
+				return fmt.Errorf("unsupported client_max_window_bits: %q", value)
+			}
+		default:
+			return fmt.Errorf("unknown permessage-deflate parameter: %q", name)
+		}
+	}
+	return nil
+}
+
+func validWindowBits(v string) bool {
+	switch v {
+	case "8", "9", "10", "11", "12", "13", "14", "15":
+		return true
+	}
+	return false
+}
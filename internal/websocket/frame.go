@@ -0,0 +1,180 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+const maxControlPayload = 125
+
+// frame is a frame as received, its payload unmasked.
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame reads the next frame (RFC 6455 5.2). A data frame longer than
+// limit is refused before its payload is read.
+func (c *Conn) readFrame(limit int64) (frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		fin:    head[0]&0x80 != 0,
+		rsv1:   head[0]&0x40 != 0,
+		opcode: head[0] & 0x0f,
+	}
+	if head[0]&0x30 != 0 {
+		return f, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	// clients mask every frame, servers none (RFC 6455 5.1)
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		if c.client {
+			return f, fmt.Errorf("%w: masked frame from the server", ErrProtocol)
+		}
+		return f, fmt.Errorf("%w: unmasked frame from the client", ErrProtocol)
+	}
+
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, unexpectedEOF(err)
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+		if length < 126 {
+			return f, fmt.Errorf("%w: length not in its shortest form", ErrProtocol)
+		}
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, unexpectedEOF(err)
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 {
+			return f, fmt.Errorf("%w: length with the most significant bit set", ErrProtocol)
+		}
+		if n <= 0xffff {
+			return f, fmt.Errorf("%w: length not in its shortest form", ErrProtocol)
+		}
+		length = int64(n)
+	}
+
+	switch f.opcode {
+	case opClose, opPing, opPong:
+		if !f.fin {
+			return f, fmt.Errorf("%w: fragmented control frame", ErrProtocol)
+		}
+		if length > maxControlPayload {
+			return f, fmt.Errorf("%w: control frame payload of %d bytes", ErrProtocol, length)
+		}
+		if f.rsv1 {
+			return f, fmt.Errorf("%w: compressed control frame", ErrProtocol)
+		}
+	case opText, opBinary:
+		if f.rsv1 && !c.deflate {
+			return f, fmt.Errorf("%w: RSV1 set without permessage-deflate", ErrProtocol)
+		}
+		if length > limit {
+			return f, ErrMessageTooLarge
+		}
+	case opContinuation:
+		// only the first frame of a message says it's compressed
+		if f.rsv1 {
+			return f, fmt.Errorf("%w: RSV1 set on a continuation frame", ErrProtocol)
+		}
+		if length > limit {
+			return f, ErrMessageTooLarge
+		}
+	default:
+		return f, fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, f.opcode)
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return f, unexpectedEOF(err)
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, unexpectedEOF(err)
+	}
+	if masked {
+		mask(key, f.payload)
+	}
+	return f, nil
+}
+
+// writeFrame writes one frame, masked when c is a client. c.wmu is held.
+func (c *Conn) writeFrame(fin bool, rsv1 bool, opcode byte, payload []byte) error {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, b0)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if !c.client {
+		buf = append(buf, payload...)
+		_, err := c.rwc.Write(buf)
+		return err
+	}
+	var key [4]byte
+	rand.Read(key[:])
+	buf = append(buf, key[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	mask(key, buf[start:])
+	_, err := c.rwc.Write(buf)
+	return err
+}
+
+// mask XORs p with the masking key, which also unmasks it.
+func mask(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i&3]
+	}
+}
+
+// unexpectedEOF turns the end of the connection half way through a frame
+// into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
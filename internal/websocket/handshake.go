@@ -0,0 +1,277 @@
+package websocket
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrBadHandshake is returned when the opening handshake fails, by Upgrade
+// after it answered the request with an error.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// keyGUID is appended to Sec-WebSocket-Key to compute the accept value
+// (RFC 6455 1.3).
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// IsUpgrade reports whether req asks for a WebSocket connection.
+func IsUpgrade(req *request.Request) bool {
+	return hasToken(req.Headers, "Connection", "upgrade") && hasToken(req.Headers, "Upgrade", "websocket")
+}
+
+// Upgrade completes the opening handshake of req with a 101 and returns the
+// connection, hijacked from the server (see response.Writer.Hijack): it
+// stays open after the handler returned, until Close. A request that isn't
+// a valid handshake is answered with the matching error status and
+// ErrBadHandshake.
+func Upgrade(w *response.Writer, req *request.Request, opts Options) (*Conn, error) {
+	h := req.Headers
+	if req.RequestLine.Method != "GET" {
+		extra := headers.NewHeaders()
+		extra.Set("Allow", "GET")
+		return nil, reject(w, response.StatusMethodNotAllowed, extra, "not a GET")
+	}
+	if !IsUpgrade(req) {
+		extra := headers.NewHeaders()
+		extra.Set("Upgrade", "websocket")
+		extra.Set("Connection", "Upgrade")
+		return nil, reject(w, response.StatusUpgradeRequired, extra, "not an upgrade to websocket")
+	}
+	if version, _ := h.Get("Sec-WebSocket-Version"); version != "13" {
+		extra := headers.NewHeaders()
+		extra.Set("Sec-WebSocket-Version", "13")
+		return nil, reject(w, response.StatusUpgradeRequired, extra, fmt.Sprintf("unsupported version %q", version))
+	}
+	key, _ := h.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, reject(w, response.StatusBadRequest, nil, fmt.Sprintf("invalid Sec-WebSocket-Key %q", key))
+	}
+	origin, _ := h.Get("Origin")
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		host, _ := h.Get("Host")
+		checkOrigin = func(origin string) bool { return sameHost(origin, host) }
+	}
+	if !checkOrigin(origin) {
+		return nil, reject(w, response.StatusForbidden, nil, fmt.Sprintf("origin %q not allowed", origin))
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, reject(w, response.StatusInternalError, nil, err.Error())
+	}
+
+	out := headers.NewHeaders()
+	out.Set("Upgrade", "websocket")
+	out.Set("Connection", "Upgrade")
+	out.Set("Sec-WebSocket-Accept", acceptKey(key))
+	offered := h.Values("Sec-WebSocket-Protocol")
+	subprotocol := ""
+	for _, p := range opts.Subprotocols {
+		if slices.Contains(offered, p) {
+			subprotocol = p
+			out.Set("Sec-WebSocket-Protocol", p)
+			break
+		}
+	}
+	deflate := false
+	if opts.Compression {
+		// a malformed offer is declined, not a failed handshake
+		if offers, err := parseExtensions(h); err == nil && acceptDeflate(offers) {
+			deflate = true
+			out.Set("Sec-WebSocket-Extensions", deflateResponse)
+		}
+	}
+	// w fails once hijacked, the 101 goes straight to the connection
+	hw := &response.Writer{ResWriter: conn}
+	if err := hw.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := hw.WriteHeaders(out); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the client waits for the 101 before sending frames, but whatever was
+	// read ahead comes first
+	c := newConn(conn, io.MultiReader(bytes.NewReader(buffered), conn), false, opts)
+	c.subprotocol = subprotocol
+	c.deflate = deflate
+	return c, nil
+}
+
+// reject answers a failed handshake with code and returns the error
+// Upgrade returns.
+func reject(w *response.Writer, code response.StatusCode, extra headers.Headers, reason string) error {
+	body := []byte(reason + "\n")
+	h := response.GetDefaultHeaders(len(body))
+	for key, values := range extra {
+		h[key] = slices.Clone(values)
+	}
+	w.WriteStatusLine(code)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+	return fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+}
+
+// sameHost reports whether origin is a URL for host, or missing. Clients
+// other than browsers don't send one.
+func sameHost(origin string, host string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+func hasToken(h headers.Headers, key string, token string) bool {
+	for _, v := range h.Values(key) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial opens a WebSocket connection to a ws:// or wss:// URL. h holds
+// extra handshake fields such as Origin or Authorization, it may be nil.
+// ctx bounds the dial and the handshake.
+func Dial(ctx context.Context, rawURL string, h headers.Headers, opts Options) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	c, err := clientHandshake(ctx, conn, u, h, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func clientHandshake(ctx context.Context, conn net.Conn, u *url.URL, extra headers.Headers, opts Options) (*Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req := &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: u.RequestURI(), HttpVersion: "1.1"},
+		Headers:     extra.Clone(),
+	}
+	req.Headers.SetOVR("Host", u.Host)
+	req.Headers.SetOVR("Upgrade", "websocket")
+	req.Headers.SetOVR("Connection", "Upgrade")
+	req.Headers.SetOVR("Sec-WebSocket-Key", key)
+	req.Headers.SetOVR("Sec-WebSocket-Version", "13")
+	if len(opts.Subprotocols) > 0 {
+		req.Headers.SetOVR("Sec-WebSocket-Protocol", strings.Join(opts.Subprotocols, ", "))
+	}
+	if opts.Compression {
+		req.Headers.SetOVR("Sec-WebSocket-Extensions", deflateResponse)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	// read the head alone, frames may follow it right away
+	br := bufio.NewReader(conn)
+	var head bytes.Buffer
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadHandshake, unexpectedEOF(err))
+		}
+		head.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+		if head.Len() > 64<<10 {
+			return nil, fmt.Errorf("%w: response head too large", ErrBadHandshake)
+		}
+	}
+	resp, err := response.ResponseHeadersFromReader(&head, "GET")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHandshake, err)
+	}
+	if resp.StatusLine.StatusCode != response.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: status %d", ErrBadHandshake, resp.StatusLine.StatusCode)
+	}
+	if !hasToken(resp.Headers, "Upgrade", "websocket") || !hasToken(resp.Headers, "Connection", "upgrade") {
+		return nil, fmt.Errorf("%w: not an upgrade to websocket", ErrBadHandshake)
+	}
+	if accept, _ := resp.Headers.Get("Sec-WebSocket-Accept"); accept != acceptKey(key) {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Accept %q", ErrBadHandshake, accept)
+	}
+
+	c := newConn(conn, br, true, opts)
+	if p, err := resp.Headers.Get("Sec-WebSocket-Protocol"); err == nil {
+		if !slices.Contains(opts.Subprotocols, p) {
+			return nil, fmt.Errorf("%w: subprotocol %q wasn't offered", ErrBadHandshake, p)
+		}
+		c.subprotocol = p
+	}
+	extensions, err := parseExtensions(resp.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadHandshake, err)
+	}
+	for _, e := range extensions {
+		if e.name != "permessage-deflate" || !opts.Compression || c.deflate {
+			return nil, fmt.Errorf("%w: extension %q wasn't offered", ErrBadHandshake, e.name)
+		}
+		if err := checkDeflateResponse(e); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadHandshake, err)
+		}
+		c.deflate = true
+	}
+	return c, nil
+}
@@ -0,0 +1,399 @@
+// Package websocket implements the WebSocket protocol (RFC 6455): the
+// opening handshake on both ends, framing, fragmentation, ping/pong, the
+// closing handshake and the permessage-deflate extension (RFC 7692).
+package websocket
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the kind of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = opText
+	BinaryMessage MessageType = opBinary
+)
+
+// Close codes (RFC 6455 7.4.1). CloseNoStatus and CloseAbnormal are never
+// sent, they stand for a close frame without a code and a connection that
+// dropped without one.
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatus           = 1005
+	CloseAbnormal           = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseTooLarge           = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+var (
+	// ErrClosed is returned by writes once a close frame was sent.
+	ErrClosed = errors.New("websocket: connection closed")
+	// ErrProtocol is returned when the peer breaks the protocol, the
+	// connection is closed with 1002.
+	ErrProtocol = errors.New("websocket: protocol error")
+	// ErrMessageTooLarge is returned when a message grows past
+	// Options.MaxMessageSize, the connection is closed with 1009.
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+	// ErrInvalidUTF8 is returned for text that isn't UTF-8, received
+	// messages close the connection with 1007.
+	ErrInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with %d: %s", e.Code, e.Reason)
+}
+
+// Options configure both ends of a connection, fields only one end uses
+// say so.
+type Options struct {
+	// MaxMessageSize caps a received message, after decompression, 1 MB
+	// when 0.
+	MaxMessageSize int64
+	// FragmentSize splits written messages into frames of at most this
+	// many bytes, 0 sends every message as one frame.
+	FragmentSize int
+	// Compression negotiates permessage-deflate. Every message is
+	// compressed on its own, without context takeover.
+	Compression bool
+	// Subprotocols are offered by a client, and are the ones a server
+	// accepts in its order of preference.
+	Subprotocols []string
+	// CheckOrigin decides whether a server accepts the Origin of the
+	// handshake, "" when there is none. When nil only requests without an
+	// Origin or from the same host are accepted, so other sites can't use
+	// a visitor's cookies to open a connection.
+	CheckOrigin func(origin string) bool
+	// CloseTimeout is how long Close waits for the peer to answer the
+	// close frame, 5 seconds when 0.
+	CloseTimeout time.Duration
+}
+
+const (
+	defaultMaxMessageSize = 1 << 20
+	defaultCloseTimeout   = 5 * time.Second
+)
+
+// Conn is a WebSocket connection. One goroutine may read while others
+// write: writes are serialized, ReadMessage is not safe for concurrent
+// use.
+type Conn struct {
+	rwc         io.ReadWriteCloser
+	br          *bufio.Reader
+	client      bool
+	opts        Options
+	deflate     bool
+	subprotocol string
+
+	wmu       sync.Mutex
+	closeSent bool
+	fw        *flate.Writer
+
+	// the read side, owned by whoever set reading
+	reading  atomic.Bool
+	readErr  error
+	readDone chan struct{}
+	doneOnce sync.Once
+}
+
+func newConn(rwc io.ReadWriteCloser, r io.Reader, client bool, opts Options) *Conn {
+	if opts.MaxMessageSize == 0 {
+		opts.MaxMessageSize = defaultMaxMessageSize
+	}
+	if opts.CloseTimeout == 0 {
+		opts.CloseTimeout = defaultCloseTimeout
+	}
+	return &Conn{
+		rwc:      rwc,
+		br:       bufio.NewReader(r),
+		client:   client,
+		opts:     opts,
+		readDone: make(chan struct{}),
+	}
+}
+
+// Subprotocol is the subprotocol the handshake settled on, "" for none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compressed reports whether permessage-deflate was negotiated.
+func (c *Conn) Compressed() bool {
+	return c.deflate
+}
+
+// ReadMessage returns the next data message, its fragments put together.
+// Pings are answered on the way, pongs skipped. Once the peer closed the
+// connection it returns a *CloseError, after any other error the
+// connection is gone as well; either way every later call returns the
+// same error.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if !c.reading.CompareAndSwap(false, true) {
+		return 0, nil, errors.New("websocket: concurrent ReadMessage")
+	}
+	defer c.reading.Store(false)
+	return c.read()
+}
+
+func (c *Conn) read() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, p, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		c.doneOnce.Do(func() { close(c.readDone) })
+	}
+	return typ, p, err
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		typ        MessageType
+		compressed bool
+		message    = []byte{}
+	)
+	for {
+		f, err := c.readFrame(c.opts.MaxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch f.opcode {
+		case opPing:
+			if err := c.writeControl(opPong, f.payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.closeReceived(f.payload)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: new message inside a fragmented one", ErrProtocol))
+			}
+			typ = MessageType(f.opcode)
+			compressed = f.rsv1
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: continuation without a message", ErrProtocol))
+			}
+		}
+		message = append(message, f.payload...)
+		if f.fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		if message, err = c.inflate(message); err != nil {
+			return 0, nil, c.fail(err)
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(message) {
+		return 0, nil, c.fail(ErrInvalidUTF8)
+	}
+	return typ, message, nil
+}
+
+// closeReceived answers the peer's close frame with the same code and
+// drops the connection, the closing handshake is complete.
+func (c *Conn) closeReceived(payload []byte) error {
+	ce, err := parseClose(payload)
+	if err != nil {
+		return c.fail(err)
+	}
+	c.writeClose(ce.Code, "")
+	c.rwc.Close()
+	return ce
+}
+
+// fail closes the connection after an error on the read side, with the
+// close code the error stands for.
+func (c *Conn) fail(err error) error {
+	code := 0
+	switch {
+	case errors.Is(err, ErrMessageTooLarge):
+		code = CloseTooLarge
+	case errors.Is(err, ErrInvalidUTF8):
+		code = CloseInvalidPayload
+	case errors.Is(err, ErrProtocol):
+		code = CloseProtocolError
+	case errors.Is(err, io.EOF):
+		err = fmt.Errorf("websocket: connection dropped without a close frame: %w", io.ErrUnexpectedEOF)
+	}
+	if code != 0 {
+		c.writeClose(code, "")
+	}
+	c.rwc.Close()
+	return err
+}
+
+// WriteMessage sends p as one message, compressed when that was
+// negotiated and split into Options.FragmentSize frames.
+func (c *Conn) WriteMessage(typ MessageType, p []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	if typ == TextMessage && !utf8.Valid(p) {
+		return ErrInvalidUTF8
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	compressed := c.deflate && len(p) >= minCompressSize
+	if compressed {
+		p = c.compress(p)
+	}
+	size := c.opts.FragmentSize
+	if size <= 0 {
+		size = len(p)
+	}
+	opcode := byte(typ)
+	for first := true; ; first = false {
+		n := min(size, len(p))
+		fin := n == len(p)
+		if err := c.writeFrame(fin, compressed && first, opcode, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+		opcode = opContinuation
+		if fin {
+			return nil
+		}
+	}
+}
+
+// Ping sends a ping, the peer answers with a pong carrying data.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// Close starts the closing handshake: it sends a close frame with code and
+// reason, waits up to Options.CloseTimeout for the peer's answer and drops
+// the connection. Messages still arriving meanwhile are discarded, unless
+// another goroutine is in ReadMessage and gets them.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeClose(code, reason)
+	if err != nil && !errors.Is(err, ErrClosed) {
+		c.rwc.Close()
+		return err
+	}
+	if c.reading.CompareAndSwap(false, true) {
+		// nobody is reading, wait for the answer here
+		if d, ok := c.rwc.(interface{ SetReadDeadline(time.Time) error }); ok {
+			d.SetReadDeadline(time.Now().Add(c.opts.CloseTimeout))
+		}
+		for {
+			if _, _, err := c.read(); err != nil {
+				break
+			}
+		}
+		c.reading.Store(false)
+	} else {
+		select {
+		case <-c.readDone:
+		case <-time.After(c.opts.CloseTimeout):
+		}
+	}
+	c.rwc.Close()
+	return nil
+}
+
+func (c *Conn) writeControl(opcode byte, payload []byte) error {
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("websocket: control frame payload over %d bytes", maxControlPayload)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrame(true, false, opcode, payload)
+}
+
+// writeClose sends the close frame, once. CloseNoStatus sends one without
+// a code.
+func (c *Conn) writeClose(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	c.closeSent = true
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, truncateUTF8(reason, maxControlPayload-2)...)
+	}
+	return c.writeFrame(true, false, opClose, payload)
+}
+
+// parseClose reads the code and reason of a close frame (RFC 6455 5.5.1).
+func parseClose(payload []byte) (*CloseError, error) {
+	if len(payload) == 0 {
+		return &CloseError{Code: CloseNoStatus}, nil
+	}
+	if len(payload) == 1 {
+		return nil, fmt.Errorf("%w: close frame with a 1 byte payload", ErrProtocol)
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return nil, fmt.Errorf("%w: invalid close code %d", ErrProtocol, code)
+	}
+	reason := payload[2:]
+	if !utf8.Valid(reason) {
+		return nil, ErrInvalidUTF8
+	}
+	return &CloseError{Code: code, Reason: string(reason)}, nil
+}
+
+// validCloseCode reports whether code may appear in a close frame: the
+// defined ones and those of the registry, or 3000-4999 for applications.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,385 @@
+package websocket
+
+import (
+	"MODULE_NAME/internal/headers"
+	"MODULE_NAME/internal/request"
+	"MODULE_NAME/internal/response"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve accepts connections the way server.Serve does and hands them to
+// handler, returning the ws:// URL.
+func serve(t *testing.T, handler func(w *response.Writer, req *request.Request)) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				req, err := request.RequestFromReader(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				w := &response.Writer{ResWriter: conn}
+				w.AllowHijack(conn, req.Buffered)
+				handler(w, req)
+				if !w.Hijacked() {
+					conn.Close()
+				}
+			}()
+		}
+	}()
+	return "ws://" + listener.Addr().String() + "/chat"
+}
+
+// echo upgrades with opts and sends every message back, reporting how the
+// connection ended.
+func echo(opts Options, ended chan<- error) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req, opts)
+		if err != nil {
+			return
+		}
+		for {
+			typ, p, err := c.ReadMessage()
+			if err != nil {
+				if ended != nil {
+					ended <- err
+				}
+				return
+			}
+			if err := c.WriteMessage(typ, p); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func dial(t *testing.T, url string, opts Options) *Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, url, nil, opts)
+	require.NoError(t, err)
+	return c
+}
+
+// tcpPair returns both ends of a loopback connection, unlike net.Pipe
+// writes don't wait for the reader.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	server := <-accepted
+	require.NotNil(t, server)
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return server, client
+}
+
+// rawFrame builds a frame byte by byte, masked with a fixed key when masked.
+func rawFrame(b0 byte, payload []byte, masked bool) []byte {
+	buf := []byte{b0}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if !masked {
+		return append(buf, payload...)
+	}
+	key := [4]byte{1, 2, 3, 4}
+	buf = append(buf, key[:]...)
+	start := len(buf)
+	buf = append(buf, payload...)
+	mask(key, buf[start:])
+	return buf
+}
+
+// readRawFrame reads an unmasked frame from the server.
+func readRawFrame(t *testing.T, r io.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	_, err := io.ReadFull(r, head[:])
+	require.NoError(t, err)
+	require.Zero(t, head[1]&0x80, "masked server frame")
+	n := int(head[1] & 0x7f)
+	require.Less(t, n, 126)
+	payload := make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return head[0], payload
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Test: The example of RFC 6455 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	handshake := "Host: localhost\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	tests := []struct {
+		name   string
+		raw    string
+		status int
+		field  string
+	}{
+		{"not a GET", "POST /chat HTTP/1.1\r\n" + handshake + "\r\n", 405, "Allow"},
+		{"no upgrade", "GET /chat HTTP/1.1\r\nHost: localhost\r\n\r\n", 426, "Upgrade"},
+		{"old version", "GET /chat HTTP/1.1\r\n" + strings.Replace(handshake, "Version: 13", "Version: 8", 1) + "\r\n", 426, "Sec-WebSocket-Version"},
+		{"short key", "GET /chat HTTP/1.1\r\n" + strings.Replace(handshake, "dGhlIHNhbXBsZSBub25jZQ==", "c2hvcnQ=", 1) + "\r\n", 400, ""},
+		{"other origin", "GET /chat HTTP/1.1\r\n" + handshake + "Origin: https://evil.example\r\n\r\n", 403, ""},
+		{"not a connection", "GET /chat HTTP/1.1\r\n" + handshake + "Origin: http://localhost\r\n\r\n", 500, ""},
+	}
+	for _, tt := range tests {
+		// Test: Each invalid handshake gets its status
+		req, err := request.RequestFromReader(strings.NewReader(tt.raw))
+		require.NoError(t, err, tt.name)
+		out := &bytes.Buffer{}
+		_, err = Upgrade(&response.Writer{ResWriter: out}, req, Options{})
+		assert.True(t, errors.Is(err, ErrBadHandshake), tt.name)
+		resp, err := http.ReadResponse(bufio.NewReader(out), nil)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.status, resp.StatusCode, tt.name)
+		if tt.field != "" {
+			assert.NotEmpty(t, resp.Header.Get(tt.field), tt.name)
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	ended := make(chan error, 1)
+	url := serve(t, echo(Options{Subprotocols: []string{"v2.chat", "v1.chat"}}, ended))
+
+	// Test: Handshake with subprotocol negotiation
+	c := dial(t, url, Options{Subprotocols: []string{"v1.chat", "v2.chat"}})
+	assert.Equal(t, "v2.chat", c.Subprotocol())
+	assert.False(t, c.Compressed())
+
+	// Test: Text and binary messages of every length encoding
+	for _, size := range []int{0, 5, 125, 126, 65535, 65536, 200000} {
+		payload := bytes.Repeat([]byte{0xfe}, size)
+		require.NoError(t, c.WriteMessage(BinaryMessage, payload))
+		typ, p, err := c.ReadMessage()
+		require.NoError(t, err, size)
+		assert.Equal(t, BinaryMessage, typ)
+		assert.Equal(t, payload, p, size)
+	}
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("héllo")))
+	typ, p, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, typ)
+	assert.Equal(t, "héllo", string(p))
+	assert.True(t, errors.Is(c.WriteMessage(TextMessage, []byte{0xff}), ErrInvalidUTF8))
+
+	// Test: Pings are answered without getting in the way
+	require.NoError(t, c.Ping([]byte("are you there")))
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("after the ping")))
+	_, p, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "after the ping", string(p))
+
+	// Test: The closing handshake reaches the other end
+	require.NoError(t, c.Close(CloseNormal, "done"))
+	select {
+	case err := <-ended:
+		var ce *CloseError
+		require.True(t, errors.As(err, &ce))
+		assert.Equal(t, CloseNormal, ce.Code)
+		assert.Equal(t, "done", ce.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't see the close")
+	}
+	assert.True(t, errors.Is(c.WriteMessage(TextMessage, []byte("late")), ErrClosed))
+}
+
+func TestConnServerClose(t *testing.T) {
+	url := serve(t, func(w *response.Writer, req *request.Request) {
+		c, err := Upgrade(w, req, Options{})
+		if err != nil {
+			return
+		}
+		c.WriteMessage(TextMessage, []byte("welcome"))
+		c.Close(4000, "shutting down")
+	})
+	c := dial(t, url, Options{})
+
+	// Test: A message sent right after the handshake isn't lost
+	_, p, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "welcome", string(p))
+
+	// Test: The server's close code and reason
+	_, _, err = c.ReadMessage()
+	var ce *CloseError
+	require.True(t, errors.As(err, &ce))
+	assert.Equal(t, 4000, ce.Code)
+	assert.Equal(t, "shutting down", ce.Reason)
+	_, _, again := c.ReadMessage()
+	assert.Equal(t, err, again)
+}
+
+func TestFragmentation(t *testing.T) {
+	server, client := tcpPair(t)
+	c := newConn(server, server, false, Options{FragmentSize: 4})
+
+	// Test: Fragments with a ping in between make one message
+	client.Write(rawFrame(opText, []byte("hel"), true))
+	client.Write(rawFrame(0x80|opPing, []byte("p"), true))
+	client.Write(rawFrame(opContinuation, []byte("lo "), true))
+	client.Write(rawFrame(0x80|opContinuation, []byte("world"), true))
+	typ, p, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, typ)
+	assert.Equal(t, "hello world", string(p))
+	b0, payload := readRawFrame(t, client)
+	assert.Equal(t, byte(0x80|opPong), b0)
+	assert.Equal(t, "p", string(payload))
+
+	// Test: Written messages are split by FragmentSize
+	require.NoError(t, c.WriteMessage(BinaryMessage, []byte("0123456789")))
+	var frames []string
+	for {
+		b0, payload := readRawFrame(t, client)
+		frames = append(frames, string(payload))
+		if b0&0x80 != 0 {
+			assert.Equal(t, byte(opContinuation), b0&0x0f)
+			break
+		}
+	}
+	assert.Equal(t, []string{"0123", "4567", "89"}, frames)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 200)
+	tests := []struct {
+		name   string
+		frames [][]byte
+		code   int
+		err    error
+	}{
+		{"unmasked", [][]byte{rawFrame(0x80|opText, []byte("x"), false)}, CloseProtocolError, ErrProtocol},
+		{"reserved bits", [][]byte{rawFrame(0xa0|opText, []byte("x"), true)}, CloseProtocolError, ErrProtocol},
+		{"RSV1 without deflate", [][]byte{rawFrame(0xc0|opText, []byte("x"), true)}, CloseProtocolError, ErrProtocol},
+		{"unknown opcode", [][]byte{rawFrame(0x83, nil, true)}, CloseProtocolError, ErrProtocol},
+		{"fragmented ping", [][]byte{rawFrame(opPing, nil, true)}, CloseProtocolError, ErrProtocol},
+		{"long ping", [][]byte{rawFrame(0x80|opPing, long, true)}, CloseProtocolError, ErrProtocol},
+		{"lone continuation", [][]byte{rawFrame(0x80|opContinuation, []byte("x"), true)}, CloseProtocolError, ErrProtocol},
+		{"message inside a message", [][]byte{rawFrame(opText, []byte("x"), true), rawFrame(0x80|opText, []byte("y"), true)}, CloseProtocolError, ErrProtocol},
+		{"invalid UTF-8", [][]byte{rawFrame(0x80|opText, []byte{'a', 0xc3}, true)}, CloseInvalidPayload, ErrInvalidUTF8},
+		{"too large", [][]byte{rawFrame(0x80|opBinary, long, true)}, CloseTooLarge, ErrMessageTooLarge},
+		{"too large in fragments", [][]byte{rawFrame(opBinary, long[:60], true), rawFrame(0x80|opContinuation, long[:60], true)}, CloseTooLarge, ErrMessageTooLarge},
+		{"invalid close code", [][]byte{rawFrame(0x80|opClose, []byte{0x03, 0xe8 + 4}, true)}, CloseProtocolError, ErrProtocol},
+	}
+	for _, tt := range tests {
+		// Test: The connection fails with the matching close code
+		server, client := tcpPair(t)
+		c := newConn(server, server, false, Options{MaxMessageSize: 100})
+		for _, f := range tt.frames {
+			client.Write(f)
+		}
+		_, _, err := c.ReadMessage()
+		assert.True(t, errors.Is(err, tt.err), "%s: %v", tt.name, err)
+		b0, payload := readRawFrame(t, client)
+		assert.Equal(t, byte(0x80|opClose), b0, tt.name)
+		require.Len(t, payload, 2, tt.name)
+		assert.Equal(t, tt.code, int(binary.BigEndian.Uint16(payload)), tt.name)
+	}
+
+	// Test: A connection dropping without a close frame
+	server, client := tcpPair(t)
+	c := newConn(server, server, false, Options{})
+	client.Close()
+	_, _, err := c.ReadMessage()
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+func TestCompression(t *testing.T) {
+	url := serve(t, echo(Options{Compression: true}, nil))
+	c := dial(t, url, Options{Compression: true})
+	assert.True(t, c.Compressed())
+
+	// Test: Compressed messages, fragmented or not, short ones plain
+	for _, msg := range []string{"tiny", strings.Repeat("compress me please ", 1000)} {
+		require.NoError(t, c.WriteMessage(TextMessage, []byte(msg)))
+		_, p, err := c.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(p))
+	}
+	require.NoError(t, c.Close(CloseNormal, ""))
+
+	// Test: Not negotiated when either end doesn't want it
+	c = dial(t, serve(t, echo(Options{}, nil)), Options{Compression: true})
+	assert.False(t, c.Compressed())
+	c.Close(CloseNormal, "")
+
+	// Test: The limit applies to the decompressed size
+	server, client := tcpPair(t)
+	sc := newConn(server, server, false, Options{MaxMessageSize: 1000})
+	sc.deflate = true
+	cc := newConn(client, client, true, Options{})
+	cc.deflate = true
+	require.NoError(t, cc.WriteMessage(BinaryMessage, make([]byte, 100000)))
+	_, _, err := sc.ReadMessage()
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+}
+
+func TestNegotiateDeflate(t *testing.T) {
+	offers := func(value string) []extension {
+		h := headers.NewHeaders()
+		h.Set("Sec-WebSocket-Extensions", value)
+		e, err := parseExtensions(h)
+		require.NoError(t, err, value)
+		return e
+	}
+	// Test: Offers we can and can't honor
+	assert.True(t, acceptDeflate(offers("permessage-deflate")))
+	assert.True(t, acceptDeflate(offers("permessage-deflate; client_max_window_bits")))
+	assert.True(t, acceptDeflate(offers(`permessage-deflate; client_max_window_bits="10"`)))
+	assert.False(t, acceptDeflate(offers("permessage-deflate; server_max_window_bits=10")))
+	assert.True(t, acceptDeflate(offers("permessage-deflate; server_max_window_bits=10, permessage-deflate")))
+	assert.False(t, acceptDeflate(offers("permessage-deflate; unknown")))
+	assert.False(t, acceptDeflate(offers("x-webkit-deflate-frame")))
+
+	// Test: Duplicate parameters are malformed
+	h := headers.NewHeaders()
+	h.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits; client_max_window_bits")
+	_, err := parseExtensions(h)
+	assert.Error(t, err)
+}